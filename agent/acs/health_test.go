@@ -0,0 +1,143 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package acs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/aws/amazon-ecs-agent/agent/auth"
+	"github.com/aws/amazon-ecs-agent/agent/config"
+)
+
+// startWebsocketServer serves websockets, sending each the given messages
+// and then reading from it until it closes if read is true and otherwise
+// never reading, and so never answering pings
+func startWebsocketServer(read bool, messages ...string) *httptest.Server {
+	upgrader := websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for _, message := range messages {
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+				return
+			}
+		}
+		if !read {
+			time.Sleep(5 * time.Second)
+			return
+		}
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func pingingClient(server *httptest.Server) *AgentCommunicationClient {
+	acc := NewAgentCommunicationClient(server.URL, &config.Config{Cluster: TEST_CLUSTER_ARN, AWSRegion: "test"}, auth.TestCredentialProvider{}, TEST_INSTANCE_ARN)
+	acc.pingInterval = 20 * time.Millisecond
+	acc.readTimeout = 200 * time.Millisecond
+	return acc
+}
+
+func TestPingsKeepConnectionAlive(t *testing.T) {
+	server := startWebsocketServer(true)
+	defer server.Close()
+	acc := pingingClient(server)
+
+	payloads, _, err := acc.Poll(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-payloads:
+		t.Fatal("Expected the connection to stay open while pongs arrive")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	health := acc.Health()
+	if !health.Connected || health.ConnectedAt.IsZero() {
+		t.Error("Expected the connection to be reported as connected", health)
+	}
+	if health.PingsSent == 0 || health.LastPongAt.IsZero() {
+		t.Error("Expected pings to be sent and answered", health)
+	}
+	acc.connection.Close()
+}
+
+func TestSlowPayloadKeepsConnectionAlive(t *testing.T) {
+	server := startWebsocketServer(true, `{"type":"PayloadMessage","message":{"tasks":[],"messageId":"messageId"}}`)
+	defer server.Close()
+	acc := pingingClient(server)
+
+	payloads, _, err := acc.Poll(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Handling the payload takes longer than the read timeout, while pongs
+	// queue up unread
+	time.Sleep(500 * time.Millisecond)
+	if payload, ok := <-payloads; !ok || payload.MessageId != "messageId" {
+		t.Fatal("Expected the payload to be delivered")
+	}
+	select {
+	case <-payloads:
+		t.Fatal("Expected the connection to stay open after a slow payload")
+	case <-time.After(500 * time.Millisecond):
+	}
+	acc.connection.Close()
+}
+
+func TestUnresponsiveConnectionCloses(t *testing.T) {
+	server := startWebsocketServer(false)
+	defer server.Close()
+	acc := pingingClient(server)
+
+	payloads, _, err := acc.Poll(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-payloads:
+		if ok {
+			t.Fatal("Expected no payload")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the connection to close once pongs stopped arriving")
+	}
+
+	health := acc.Health()
+	if health.Connected || health.DisconnectedAt.IsZero() || health.DisconnectReason == "" {
+		t.Error("Expected the disconnection to be reported", health)
+	}
+}
+
+func TestHeartbeatRecorded(t *testing.T) {
+	acc := testClient()
+	if _, _, err := acc.parseResponseLine([]byte(`{"type":"HeartbeatMessage","message":{"healthy":true}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if acc.Health().LastHeartbeatAt.IsZero() {
+		t.Error("Expected the heartbeat to be recorded")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package acs
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Message types ACS sends
+const (
+	PayloadMessageType            = "PayloadMessage"
+	HeartbeatMessageType          = "HeartbeatMessage"
+	TaskReconciliationMessageType = "TaskReconciliationMessage"
+
+	// The type of the message the agent reports a reconciliation's outcome
+	// with
+	ReconciliationResultMessageType = "TaskReconciliationResultMessage"
+)
+
+// A MessageHandler handles one message of a registered type, received by a
+// client. The message is what the type's newMessage function returned, with
+// the message decoded into it. If the message carries tasks, the handler
+// returns them in a Payload for Poll to emit; otherwise it returns nil.
+// Errors are emitted on Poll's error channel.
+type MessageHandler func(acc *AgentCommunicationClient, message interface{}) (*Payload, error)
+
+type messageType struct {
+	newMessage func() interface{}
+	handle     MessageHandler
+}
+
+// messageRegistry holds the handlers for each type of message ACS sends, and
+// counts the messages received of types that have none
+type messageRegistry struct {
+	lock    sync.RWMutex
+	types   map[string]messageType
+	unknown map[string]int
+}
+
+// messageTypes holds the message types every client handles
+var messageTypes = &messageRegistry{
+	types:   make(map[string]messageType),
+	unknown: make(map[string]int),
+}
+
+func init() {
+	RegisterMessageType(PayloadMessageType, func() interface{} { return &Payload{} }, handlePayload)
+	RegisterMessageType(HeartbeatMessageType, func() interface{} { return &HealthResponse{} }, handleHeartbeat)
+	RegisterMessageType(TaskReconciliationMessageType, func() interface{} { return &TaskReconciliation{} }, handleTaskReconciliation)
+}
+
+// RegisterMessageType registers the handler for messages of the given type.
+// newMessage returns a pointer to decode each message of the type into. Each
+// type may only be registered once.
+func RegisterMessageType(name string, newMessage func() interface{}, handler MessageHandler) {
+	messageTypes.register(name, newMessage, handler)
+}
+
+// UnknownMessageCounts returns how many messages of each unregistered type
+// have been received
+func UnknownMessageCounts() map[string]int {
+	return messageTypes.unknownCounts()
+}
+
+func (registry *messageRegistry) register(name string, newMessage func() interface{}, handler MessageHandler) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if _, exists := registry.types[name]; exists {
+		panic("acs: duplicate handler for message type " + name)
+	}
+	registry.types[name] = messageType{newMessage: newMessage, handle: handler}
+}
+
+func (registry *messageRegistry) unknownCounts() map[string]int {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	counts := make(map[string]int, len(registry.unknown))
+	for name, count := range registry.unknown {
+		counts[name] = count
+	}
+	return counts
+}
+
+// dispatch decodes a message and hands it to the handler for its type. It
+// returns a payload to emit, if any. Messages of unregistered types are
+// counted and logged, not returned as errors, so that ACS can introduce new
+// types without older agents failing.
+func (registry *messageRegistry) dispatch(acc *AgentCommunicationClient, response *PollResponse) (*Payload, error) {
+	if response.MessageType == "" {
+		return nil, errors.New("Could not unmarshal ACS response... Skipping")
+	}
+	registry.lock.RLock()
+	messageType, ok := registry.types[response.MessageType]
+	registry.lock.RUnlock()
+	if !ok {
+		registry.lock.Lock()
+		registry.unknown[response.MessageType]++
+		count := registry.unknown[response.MessageType]
+		registry.lock.Unlock()
+		log.Warn("Ignoring message of unknown type", "type", response.MessageType, "count", count)
+		return nil, nil
+	}
+
+	message := messageType.newMessage()
+	if len(response.Message) != 0 {
+		if err := json.Unmarshal(response.Message, message); err != nil {
+			return nil, errors.New("Could not unmarshal ACS " + response.MessageType + ": " + err.Error())
+		}
+	}
+	return messageType.handle(acc, message)
+}
+
+func handlePayload(acc *AgentCommunicationClient, message interface{}) (*Payload, error) {
+	return message.(*Payload), nil
+}
+
+func handleHeartbeat(acc *AgentCommunicationClient, message interface{}) (*Payload, error) {
+	acc.updateHealth(func(health *ConnectionHealth) { health.LastHeartbeatAt = time.Now() })
+	acc.resetHeartbeat()
+	return nil, nil
+}
+
+// handleTaskReconciliation emits a reconciliation as a payload, so that it is
+// applied in order with the payloads around it
+func handleTaskReconciliation(acc *AgentCommunicationClient, message interface{}) (*Payload, error) {
+	reconciliation := message.(*TaskReconciliation)
+	if reconciliation.Tasks == nil {
+		// An empty list stops every task; a missing one is more likely a
+		// mistake
+		return nil, errors.New("TaskReconciliationMessage " + reconciliation.MessageId + " has no task list")
+	}
+	return &Payload{MessageId: reconciliation.MessageId, Reconciliation: reconciliation.Tasks}, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package acs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+)
+
+func TestUnknownMessageTypesAreCounted(t *testing.T) {
+	client := testClient()
+	before := UnknownMessageCounts()["NewMessage"]
+
+	for i := 0; i < 2; i++ {
+		payload, skip, err := client.parseResponseLine([]byte(`{"type":"NewMessage","message":{"field":1}}`))
+		if err != nil {
+			t.Error("Expected an unknown message type not to be an error, got", err)
+		}
+		if !skip || payload != nil {
+			t.Error("Expected an unknown message type to be skipped")
+		}
+	}
+	if count := UnknownMessageCounts()["NewMessage"]; count != before+2 {
+		t.Error("Expected both messages to be counted, got", count-before)
+	}
+}
+
+type stopMessage struct {
+	TaskArn string `json:"taskArn"`
+}
+
+func TestRegisteredMessageTypeDispatch(t *testing.T) {
+	client := testClient()
+	registry := &messageRegistry{types: make(map[string]messageType), unknown: make(map[string]int)}
+
+	var stopped []string
+	registry.register("StopTaskMessage", func() interface{} { return &stopMessage{} }, func(acc *AgentCommunicationClient, message interface{}) (*Payload, error) {
+		stopped = append(stopped, message.(*stopMessage).TaskArn)
+		return nil, nil
+	})
+	registry.register("FailingMessage", func() interface{} { return &stopMessage{} }, func(acc *AgentCommunicationClient, message interface{}) (*Payload, error) {
+		return nil, errors.New("handler failed")
+	})
+
+	payload, err := registry.dispatch(client, &PollResponse{MessageType: "StopTaskMessage", Message: []byte(`{"taskArn":"arn1"}`)})
+	if err != nil || payload != nil {
+		t.Error("Unexpected result", payload, err)
+	}
+	if len(stopped) != 1 || stopped[0] != "arn1" {
+		t.Error("Expected the message to be handled, got", stopped)
+	}
+
+	if _, err := registry.dispatch(client, &PollResponse{MessageType: "FailingMessage"}); err == nil {
+		t.Error("Expected the handler's error to be returned")
+	}
+	if _, err := registry.dispatch(client, &PollResponse{MessageType: "StopTaskMessage", Message: []byte(`[]`)}); err == nil {
+		t.Error("Expected a malformed message to be an error")
+	}
+}
+
+func TestDuplicateMessageTypePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a type twice to panic")
+		}
+	}()
+	RegisterMessageType(PayloadMessageType, func() interface{} { return &Payload{} }, handlePayload)
+}
+
+func TestParseTaskReconciliation(t *testing.T) {
+	client := testClient()
+
+	payload, skip, err := client.parseResponseLine([]byte(`{"type":"TaskReconciliationMessage","message":{"messageId":"m1","tasks":[{"arn":"arn1","desiredStatus":"STOPPED"}]}}`))
+	if err != nil || skip {
+		t.Fatal("Expected a reconciliation payload", err, skip)
+	}
+	if payload.MessageId != "m1" || len(payload.Reconciliation) != 1 || payload.Reconciliation[0].Arn != "arn1" || payload.Reconciliation[0].DesiredStatus != api.TaskStopped {
+		t.Error("Unexpected payload", payload)
+	}
+
+	if _, _, err := client.parseResponseLine([]byte(`{"type":"TaskReconciliationMessage","message":{"messageId":"m2"}}`)); err == nil {
+		t.Error("Expected a reconciliation without a task list to be an error")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package acs
+
+import "sync"
+
+// Monitor tracks the health of the agent's connection to ACS across
+// reconnects, for introspection
+type Monitor struct {
+	lock        sync.Mutex
+	client      *AgentCommunicationClient
+	connections int
+}
+
+// MonitorHealth is the health of the agent's connection to ACS
+type MonitorHealth struct {
+	// Connections is how many times the agent has connected
+	Connections int
+	// Current is the health of the latest connection, if there has been one
+	Current *ConnectionHealth
+}
+
+// NewMonitor returns a monitor tracking no connection
+func NewMonitor() *Monitor {
+	return &Monitor{}
+}
+
+// Track makes a newly connected client the one the monitor reports on
+func (monitor *Monitor) Track(client *AgentCommunicationClient) {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	monitor.client = client
+	monitor.connections++
+}
+
+// Health returns the health of the latest connection
+func (monitor *Monitor) Health() MonitorHealth {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	health := MonitorHealth{Connections: monitor.connections}
+	if monitor.client != nil {
+		current := monitor.client.Health()
+		health.Current = &current
+	}
+	return health
+}
@@ -0,0 +1,240 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package acs
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/statemanager"
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+)
+
+const (
+	// How many times to try saving the tasks of a payload before giving up
+	// on it; it is not acked, so ACS sends it again
+	saveAttempts = 5
+	// How many times to try acking a payload; if every attempt fails, ACS
+	// sends it again and it is acked then
+	ackAttempts = 3
+	// How many accepted message ids to remember, to recognize payloads ACS
+	// sends again
+	rememberedMessages = 1000
+	// How long saving and acking a payload may take, retries included. The
+	// connection is not read while a payload is handled, so this stays well
+	// under READ_TIMEOUT; a payload not acked in time is sent again by ACS
+	handleTimeout = READ_TIMEOUT - 15*time.Second
+)
+
+// The reason recorded for tasks stopped because a reconciliation omits them
+const reconciliationStopReason = "Task is not in the task list from ECS"
+
+// TaskEngine is what payloads are applied to; engine.TaskEngine is one
+type TaskEngine interface {
+	AddTask(*api.Task)
+	ListTasks() ([]*api.Task, error)
+	StopTask(arn, reason string) bool
+}
+
+// Acker acknowledges payloads to ACS, and reports the outcome of
+// reconciliations; AgentCommunicationClient is one
+type Acker interface {
+	Ack(*Payload) error
+	ReportReconciliation(*ReconciliationResult) error
+}
+
+// PayloadHandler accepts the payloads ACS sends, one at a time and in the
+// order they are received. A payload is acked only once its tasks have been
+// added and saved, so that ACS sends again any payload the agent might lose
+// by crashing. Payloads ACS sends again after they were accepted, such as
+// because their ack was lost, are acked again without adding their tasks.
+//
+// One PayloadHandler should be used across connections, so that payloads are
+// recognized when they are sent again on a new connection.
+type PayloadHandler struct {
+	taskEngine TaskEngine
+	saver      statemanager.ForceSaver
+
+	// accepted holds the ids of accepted payloads; acceptedOrder holds them
+	// in the order they were accepted, to forget the oldest
+	accepted      map[string]bool
+	acceptedOrder []string
+
+	saveBackoff   utils.Backoff
+	ackBackoff    utils.Backoff
+	handleTimeout time.Duration
+}
+
+// NewPayloadHandler returns a handler adding tasks to taskEngine and saving
+// them with saver
+func NewPayloadHandler(taskEngine TaskEngine, saver statemanager.ForceSaver) *PayloadHandler {
+	return &PayloadHandler{
+		taskEngine:    taskEngine,
+		saver:         saver,
+		accepted:      make(map[string]bool),
+		saveBackoff:   utils.NewSimpleBackoff(time.Second, 10*time.Second, 0.2, 2),
+		ackBackoff:    utils.NewSimpleBackoff(250*time.Millisecond, 2*time.Second, 0.2, 2),
+		handleTimeout: handleTimeout,
+	}
+}
+
+// Run handles the payloads received on a connection, in order, until the
+// connection closes. It acks them with acker. Errors reading the connection
+// are logged; the last is returned. It also returns how many payloads were
+// received.
+func (handler *PayloadHandler) Run(payloads <-chan *Payload, errc <-chan error, acker Acker) (int, error) {
+	var err error
+	received := 0
+	for payloads != nil {
+		select {
+		case payload, ok := <-payloads:
+			if !ok {
+				// The connection closed
+				payloads = nil
+				break
+			}
+			received++
+			handler.Handle(payload, acker)
+		case readErr, ok := <-errc:
+			if !ok {
+				log.Error("Error channel unexpectedly closed")
+				payloads = nil
+				break
+			}
+			log.Error("Error in state", "err", readErr)
+			err = readErr
+		}
+	}
+	return received, err
+}
+
+// Handle accepts a single payload and acks it, returning whether it was
+// acked. A reconciliation's outcome is reported after it is acked. Saving
+// and acking are retried for at most handleTimeout, so that the connection is
+// read again before it times out.
+func (handler *PayloadHandler) Handle(payload *Payload, acker Acker) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), handler.handleTimeout)
+	defer cancel()
+
+	if handler.accepted[payload.MessageId] {
+		log.Info("Payload was already accepted; acking it again", "messageId", payload.MessageId)
+		return handler.ack(ctx, payload, acker)
+	}
+
+	for _, task := range payload.Tasks {
+		handler.taskEngine.AddTask(task)
+	}
+	var result *ReconciliationResult
+	if payload.Reconciliation != nil {
+		var err error
+		result, err = handler.reconcile(payload)
+		if err != nil {
+			log.Error("Could not reconcile tasks; not acking", "messageId", payload.MessageId, "err", err)
+			return false
+		}
+	}
+	handler.saveBackoff.Reset()
+	err := utils.RetryNWithBackoffCtx(ctx, handler.saveBackoff, saveAttempts, func() error {
+		err := handler.saver.ForceSave()
+		if err != nil {
+			log.Error("Error saving state for payload", "messageId", payload.MessageId, "err", err)
+		}
+		return err
+	})
+	if err != nil {
+		// Adding the tasks again when ACS resends the payload is harmless
+		log.Crit("Could not save payload; not acking it", "messageId", payload.MessageId, "err", err)
+		return false
+	}
+	handler.remember(payload.MessageId)
+	if !handler.ack(ctx, payload, acker) {
+		return false
+	}
+	if result != nil {
+		if err := acker.ReportReconciliation(result); err != nil {
+			log.Warn("Could not report reconciliation", "messageId", payload.MessageId, "err", err)
+		}
+	}
+	return true
+}
+
+// reconcile stops every task the agent has that a reconciliation omits or
+// lists as stopped, and finds the listed tasks the agent does not have
+func (handler *PayloadHandler) reconcile(payload *Payload) (*ReconciliationResult, error) {
+	tasks, err := handler.taskEngine.ListTasks()
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]api.TaskStatus, len(payload.Reconciliation))
+	for _, task := range payload.Reconciliation {
+		listed[task.Arn] = task.DesiredStatus
+	}
+
+	result := &ReconciliationResult{MessageId: payload.MessageId, StoppedTasks: []StoppedTask{}, UnknownTasks: []string{}}
+	known := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		known[task.Arn] = true
+		if task.DesiredStatus >= api.TaskStopped {
+			continue
+		}
+		desired, ok := listed[task.Arn]
+		reason := reconciliationStopReason
+		if ok {
+			if desired < api.TaskStopped {
+				continue
+			}
+			reason = ""
+		}
+		if handler.taskEngine.StopTask(task.Arn, reason) {
+			log.Info("Stopping task by reconciliation", "task", task.Arn, "reason", reason)
+			result.StoppedTasks = append(result.StoppedTasks, StoppedTask{Arn: task.Arn, Reason: reason})
+		}
+	}
+	for _, task := range payload.Reconciliation {
+		if !known[task.Arn] {
+			result.UnknownTasks = append(result.UnknownTasks, task.Arn)
+		}
+	}
+	return result, nil
+}
+
+func (handler *PayloadHandler) ack(ctx context.Context, payload *Payload, acker Acker) bool {
+	handler.ackBackoff.Reset()
+	err := utils.RetryNWithBackoffCtx(ctx, handler.ackBackoff, ackAttempts, func() error {
+		err := acker.Ack(payload)
+		if err != nil {
+			log.Warn("Error acking payload", "messageId", payload.MessageId, "err", err)
+		}
+		return err
+	})
+	if err == context.DeadlineExceeded {
+		log.Error("Ran out of time acking payload", "messageId", payload.MessageId)
+	}
+	return err == nil
+}
+
+// remember records a payload as accepted, forgetting the oldest once too
+// many are remembered
+func (handler *PayloadHandler) remember(messageId string) {
+	if messageId == "" {
+		return
+	}
+	handler.accepted[messageId] = true
+	handler.acceptedOrder = append(handler.acceptedOrder, messageId)
+	if len(handler.acceptedOrder) > rememberedMessages {
+		delete(handler.accepted, handler.acceptedOrder[0])
+		handler.acceptedOrder = handler.acceptedOrder[1:]
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package acs
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+)
+
+// recorder records the handler's calls, in order, and fails the first
+// saveFailures saves and ackFailures acks. Its tasks are what ListTasks
+// returns.
+type recorder struct {
+	calls        []string
+	saveFailures int
+	ackFailures  int
+
+	tasks   []*api.Task
+	results []*ReconciliationResult
+}
+
+func (r *recorder) AddTask(task *api.Task) {
+	r.calls = append(r.calls, "add "+task.Arn)
+}
+
+func (r *recorder) ListTasks() ([]*api.Task, error) {
+	return r.tasks, nil
+}
+
+func (r *recorder) StopTask(arn, reason string) bool {
+	r.calls = append(r.calls, "stop "+arn)
+	for _, task := range r.tasks {
+		if task.Arn == arn {
+			task.DesiredStatus = api.TaskStopped
+			task.StoppedReason = reason
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recorder) ReportReconciliation(result *ReconciliationResult) error {
+	r.calls = append(r.calls, "report "+result.MessageId)
+	r.results = append(r.results, result)
+	return nil
+}
+
+func (r *recorder) ForceSave() error {
+	r.calls = append(r.calls, "save")
+	if r.saveFailures > 0 {
+		r.saveFailures--
+		return errors.New("save failed")
+	}
+	return nil
+}
+
+func (r *recorder) Ack(payload *Payload) error {
+	r.calls = append(r.calls, "ack "+payload.MessageId)
+	if r.ackFailures > 0 {
+		r.ackFailures--
+		return errors.New("ack failed")
+	}
+	return nil
+}
+
+func testPayloadHandler(r *recorder) *PayloadHandler {
+	handler := NewPayloadHandler(r, r)
+	handler.saveBackoff = utils.NewSimpleBackoff(time.Millisecond, time.Millisecond, 0, 1)
+	handler.ackBackoff = utils.NewSimpleBackoff(time.Millisecond, time.Millisecond, 0, 1)
+	return handler
+}
+
+func payload(messageId string, arns ...string) *Payload {
+	tasks := make([]*api.Task, len(arns))
+	for i, arn := range arns {
+		tasks[i] = &api.Task{Arn: arn}
+	}
+	return &Payload{Tasks: tasks, MessageId: messageId}
+}
+
+func expectCalls(t *testing.T, r *recorder, expected ...string) {
+	if !utils.StrSliceEqual(r.calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, r.calls)
+	}
+	r.calls = nil
+}
+
+func TestRunHandlesPayloadsInOrder(t *testing.T) {
+	r := &recorder{}
+	handler := testPayloadHandler(r)
+
+	payloads := make(chan *Payload)
+	errc := make(chan error)
+	go func() {
+		payloads <- payload("m1", "t1", "t2")
+		errc <- errors.New("read error")
+		payloads <- payload("m2", "t3")
+		close(payloads)
+	}()
+
+	received, err := handler.Run(payloads, errc, r)
+	if received != 2 {
+		t.Error("Expected 2 payloads to be received, got", received)
+	}
+	if err == nil {
+		t.Error("Expected the read error to be returned")
+	}
+	expectCalls(t, r, "add t1", "add t2", "save", "ack m1", "add t3", "save", "ack m2")
+}
+
+func TestHandleDeduplicates(t *testing.T) {
+	r := &recorder{}
+	handler := testPayloadHandler(r)
+
+	handler.Handle(payload("m1", "t1"), r)
+	expectCalls(t, r, "add t1", "save", "ack m1")
+	if !handler.Handle(payload("m1", "t1"), r) {
+		t.Error("Expected the resent payload to be acked")
+	}
+	expectCalls(t, r, "ack m1")
+}
+
+func TestHandleAcksOnlyAfterSaving(t *testing.T) {
+	r := &recorder{saveFailures: 2}
+	handler := testPayloadHandler(r)
+	if !handler.Handle(payload("m1", "t1"), r) {
+		t.Error("Expected the payload to be acked once saved")
+	}
+	expectCalls(t, r, "add t1", "save", "save", "save", "ack m1")
+
+	r.saveFailures = saveAttempts
+	if handler.Handle(payload("m2", "t2"), r) {
+		t.Error("Expected a payload that could not be saved not to be acked")
+	}
+	for _, call := range r.calls {
+		if call == "ack m2" {
+			t.Error("Expected no ack of an unsaved payload")
+		}
+	}
+	r.calls = nil
+
+	// Once resent it is accepted
+	handler.Handle(payload("m2", "t2"), r)
+	expectCalls(t, r, "add t2", "save", "ack m2")
+}
+
+func TestHandleGivesUpSavingInTime(t *testing.T) {
+	r := &recorder{saveFailures: saveAttempts}
+	handler := testPayloadHandler(r)
+	// Backing off once takes longer than handling may
+	handler.saveBackoff = utils.NewSimpleBackoff(time.Hour, time.Hour, 0, 1)
+	handler.handleTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if handler.Handle(payload("m1", "t1"), r) {
+		t.Error("Expected a payload that could not be saved not to be acked")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected saving to be given up on after the handle timeout, took %v", elapsed)
+	}
+	expectCalls(t, r, "add t1", "save")
+}
+
+func TestHandleRetriesAcks(t *testing.T) {
+	r := &recorder{ackFailures: 1}
+	handler := testPayloadHandler(r)
+	if !handler.Handle(payload("m1"), r) {
+		t.Error("Expected the ack to be retried")
+	}
+	expectCalls(t, r, "save", "ack m1", "ack m1")
+
+	r.ackFailures = ackAttempts
+	if handler.Handle(payload("m2"), r) {
+		t.Error("Expected the ack to fail")
+	}
+	// Once resent, it is acked without being saved again
+	handler.Handle(payload("m2"), r)
+	expectCalls(t, r, "save", "ack m2", "ack m2", "ack m2", "ack m2")
+}
+
+func TestRememberForgetsOldest(t *testing.T) {
+	handler := testPayloadHandler(&recorder{})
+	for i := 0; i <= rememberedMessages; i++ {
+		handler.remember(strconv.Itoa(i))
+	}
+	if len(handler.accepted) != rememberedMessages || handler.accepted["0"] {
+		t.Error("Expected the oldest message to be forgotten")
+	}
+}
+
+func TestHandleReconciliation(t *testing.T) {
+	r := &recorder{tasks: []*api.Task{
+		{Arn: "listed", DesiredStatus: api.TaskRunning},
+		{Arn: "listed-stopped", DesiredStatus: api.TaskRunning},
+		{Arn: "unlisted", DesiredStatus: api.TaskRunning},
+		{Arn: "already-stopped", DesiredStatus: api.TaskStopped},
+	}}
+	handler := testPayloadHandler(r)
+
+	reconciliation := &Payload{MessageId: "m1", Reconciliation: []TaskDesiredStatus{
+		{Arn: "listed", DesiredStatus: api.TaskRunning},
+		{Arn: "listed-stopped", DesiredStatus: api.TaskStopped},
+		{Arn: "new", DesiredStatus: api.TaskRunning},
+	}}
+	if !handler.Handle(reconciliation, r) {
+		t.Fatal("Expected the reconciliation to be acked")
+	}
+	expectCalls(t, r, "stop listed-stopped", "stop unlisted", "save", "ack m1", "report m1")
+
+	if len(r.results) != 1 {
+		t.Fatal("Expected one report, got", len(r.results))
+	}
+	result := r.results[0]
+	if len(result.StoppedTasks) != 2 || result.StoppedTasks[1].Arn != "unlisted" || result.StoppedTasks[1].Reason != reconciliationStopReason {
+		t.Error("Unexpected stopped tasks", result.StoppedTasks)
+	}
+	if !utils.StrSliceEqual(result.UnknownTasks, []string{"new"}) {
+		t.Error("Expected the task the agent lacks to be reported, got", result.UnknownTasks)
+	}
+	if r.tasks[0].DesiredStatus != api.TaskRunning || r.tasks[2].StoppedReason != reconciliationStopReason {
+		t.Error("Expected only the tasks not listed as running to be stopped")
+	}
+
+	// Sent again, it is only acked
+	handler.Handle(reconciliation, r)
+	expectCalls(t, r, "ack m1")
+}
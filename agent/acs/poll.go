@@ -14,7 +14,6 @@
 package acs
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -22,11 +21,13 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/amazon-ecs-agent/agent/api"
 	"github.com/aws/amazon-ecs-agent/agent/ecs_client/authv4"
 	"github.com/aws/amazon-ecs-agent/agent/ecs_client/authv4/credentials"
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/client/dialer"
 	"github.com/aws/amazon-ecs-agent/agent/utils"
 
 	"github.com/gorilla/websocket"
@@ -43,6 +44,14 @@ const CONNECT_TIMEOUT = 3 * time.Second
 const HEARTBEAT_TIMEOUT = 5 * time.Minute
 const HEARTBEAT_JITTER = 3 * time.Minute
 
+// How often to ping ACS, and how long to wait without any message or pong
+// from it before deciding the connection is dead
+const PING_INTERVAL = 30 * time.Second
+const READ_TIMEOUT = 45 * time.Second
+
+// How long a write to ACS may take
+const WRITE_TIMEOUT = 10 * time.Second
+
 // AgentCommunicationClient is a client that keeps track of connections to the
 // AgentCommunication backend service. It stores configuration needed to connect
 // and its current connection
@@ -55,6 +64,28 @@ type AgentCommunicationClient struct {
 
 	connection     *websocket.Conn
 	heartbeatTimer *time.Timer
+	pingInterval   time.Duration
+	readTimeout    time.Duration
+
+	healthLock sync.Mutex
+	health     ConnectionHealth
+}
+
+// ConnectionHealth describes a client's connection to ACS
+type ConnectionHealth struct {
+	Endpoint  string
+	Connected bool
+
+	ConnectedAt    time.Time
+	DisconnectedAt time.Time
+	// DisconnectReason is the error that ended the connection
+	DisconnectReason string
+
+	MessagesReceived int
+	LastMessageAt    time.Time
+	LastHeartbeatAt  time.Time
+	PingsSent        int
+	LastPongAt       time.Time
 }
 
 func NewAgentCommunicationClient(endpoint string, cfg *config.Config, credentialProvider credentials.AWSCredentialProvider, containerInstanceArn string) *AgentCommunicationClient {
@@ -64,6 +95,9 @@ func NewAgentCommunicationClient(endpoint string, cfg *config.Config, credential
 		containerInstanceArn: containerInstanceArn,
 		endpoint:             endpoint,
 		port:                 443,
+		pingInterval:         PING_INTERVAL,
+		readTimeout:          READ_TIMEOUT,
+		health:               ConnectionHealth{Endpoint: endpoint},
 	}
 }
 
@@ -98,10 +132,29 @@ func (acc *AgentCommunicationClient) Poll(acceptInvalidCert bool) (<-chan *Paylo
 	// it did above
 	request, _ := http.NewRequest("GET", acsUrl, nil)
 	signer.SignHttpRequest(request)
+	// The signer sets the Host header it signed; the websocket client sends
+	// the same host itself, and a second Host header is rejected
+	request.Header.Del("Host")
+
+	// The endpoint may name a port; otherwise the client's is used
+	host, port := parsedAcsUrl.Host, strconv.Itoa(acc.port)
+	if splitHost, splitPort, err := net.SplitHostPort(parsedAcsUrl.Host); err == nil {
+		host, port = splitHost, splitPort
+	}
+
+	proxy, err := api.NewProxy(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig, err := api.NewTLSConfig(cfg, acceptInvalidCert)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	timeoutDialer := &net.Dialer{Timeout: CONNECT_TIMEOUT}
-	log.Info("Creating poll dialer", "host", parsedAcsUrl.Host)
-	acsConn, err := tls.DialWithDialer(timeoutDialer, "tcp", net.JoinHostPort(parsedAcsUrl.Host, strconv.Itoa(acc.port)), &tls.Config{InsecureSkipVerify: acceptInvalidCert})
+	log.Info("Creating poll dialer", "host", parsedAcsUrl.Host, "proxied", !proxy.Bypass(parsedAcsUrl.Host))
+	acsConn, err := dialer.DialTLS(timeoutDialer, net.JoinHostPort(host, port), tlsConfig, proxy)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -120,11 +173,26 @@ func (acc *AgentCommunicationClient) Poll(acceptInvalidCert bool) (<-chan *Paylo
 	}
 
 	acc.connection = websocketConn
+	acc.updateHealth(func(health *ConnectionHealth) {
+		health.Connected = true
+		health.ConnectedAt = time.Now()
+	})
 	acc.resetHeartbeat()
 
+	// Any message or pong shows the connection is alive; without one for
+	// readTimeout, the read fails and the connection is closed
+	websocketConn.SetReadDeadline(time.Now().Add(acc.readTimeout))
+	websocketConn.SetPongHandler(func(string) error {
+		acc.updateHealth(func(health *ConnectionHealth) { health.LastPongAt = time.Now() })
+		return websocketConn.SetReadDeadline(time.Now().Add(acc.readTimeout))
+	})
+	stopPinging := make(chan struct{})
+	go acc.ping(websocketConn, stopPinging)
+
 	log.Info("Starting websocket poll loop")
 	go func() {
 		defer close(tasksc)
+		defer acc.disconnected(stopPinging)
 		for {
 			messageType, message, err := websocketConn.ReadMessage()
 			if err != nil {
@@ -133,8 +201,13 @@ func (acc *AgentCommunicationClient) Poll(acceptInvalidCert bool) (<-chan *Paylo
 				} else {
 					log.Error("Error getting message from acs", "err", err)
 				}
+				acc.updateHealth(func(health *ConnectionHealth) { health.DisconnectReason = err.Error() })
 				break
 			}
+			acc.updateHealth(func(health *ConnectionHealth) {
+				health.MessagesReceived++
+				health.LastMessageAt = time.Now()
+			})
 			if messageType != websocket.TextMessage {
 				log.Error("Unexpected messageType", "type", messageType)
 			}
@@ -146,6 +219,10 @@ func (acc *AgentCommunicationClient) Poll(acceptInvalidCert bool) (<-chan *Paylo
 			} else if !skip {
 				tasksc <- tasks
 			} // skip
+			// Delivery waits for the payload before it to be handled, which
+			// can outlast readTimeout. Pongs that arrived meanwhile are only
+			// processed by the next read, so the deadline starts from now.
+			websocketConn.SetReadDeadline(time.Now().Add(acc.readTimeout))
 		}
 	}()
 
@@ -154,10 +231,9 @@ func (acc *AgentCommunicationClient) Poll(acceptInvalidCert bool) (<-chan *Paylo
 
 // Ack acknowledges that a given message was recieved. It is expected to be
 // called after Poll such that Poll has already opened a websocket connection.
-func (acc *AgentCommunicationClient) Ack(message *Payload) bool {
+func (acc *AgentCommunicationClient) Ack(message *Payload) error {
 	if acc.connection == nil {
-		log.Error("Could not ack message, connection nil")
-		return false
+		return errors.New("Could not ack message, connection nil")
 	}
 
 	log.Info("Acking a message", "messageId", message.MessageId)
@@ -165,11 +241,77 @@ func (acc *AgentCommunicationClient) Ack(message *Payload) bool {
 	result, err := json.Marshal(ackRequest)
 	if err != nil {
 		log.Error("Unable to marshal ack; this is odd", "err", err)
-		return false
+		return err
 	}
 
-	acc.connection.WriteMessage(websocket.TextMessage, result)
-	return true
+	acc.connection.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	return acc.connection.WriteMessage(websocket.TextMessage, result)
+}
+
+// ReportReconciliation reports the outcome of a reconciliation to ACS
+func (acc *AgentCommunicationClient) ReportReconciliation(result *ReconciliationResult) error {
+	if acc.connection == nil {
+		return errors.New("Could not report reconciliation, connection nil")
+	}
+
+	result.MessageType = ReconciliationResultMessageType
+	result.ClusterArn = acc.cfg.Cluster
+	result.ContainerInstanceArn = acc.containerInstanceArn
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Error("Unable to marshal reconciliation result; this is odd", "err", err)
+		return err
+	}
+
+	acc.connection.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	return acc.connection.WriteMessage(websocket.TextMessage, data)
+}
+
+// ping pings ACS every pingInterval until stopped. A failed ping closes the
+// connection, so that it is reconnected promptly.
+func (acc *AgentCommunicationClient) ping(conn *websocket.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(acc.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WRITE_TIMEOUT))
+		if err != nil {
+			log.Error("Error pinging acs; closing connection", "err", err)
+			conn.Close()
+			return
+		}
+		acc.updateHealth(func(health *ConnectionHealth) { health.PingsSent++ })
+	}
+}
+
+// disconnected cleans up after the connection ends
+func (acc *AgentCommunicationClient) disconnected(stopPinging chan<- struct{}) {
+	close(stopPinging)
+	if acc.heartbeatTimer != nil {
+		acc.heartbeatTimer.Stop()
+	}
+	acc.connection.Close()
+	acc.updateHealth(func(health *ConnectionHealth) {
+		health.Connected = false
+		health.DisconnectedAt = time.Now()
+	})
+}
+
+// Health returns the health of the client's connection
+func (acc *AgentCommunicationClient) Health() ConnectionHealth {
+	acc.healthLock.Lock()
+	defer acc.healthLock.Unlock()
+	return acc.health
+}
+
+func (acc *AgentCommunicationClient) updateHealth(update func(*ConnectionHealth)) {
+	acc.healthLock.Lock()
+	defer acc.healthLock.Unlock()
+	update(&acc.health)
 }
 
 func (acc *AgentCommunicationClient) resetHeartbeat() {
@@ -190,18 +332,8 @@ func (acc *AgentCommunicationClient) parseResponseLine(line []byte) (*Payload, b
 		return nil, true, err
 	}
 
-	log.Info("Message = ", "message", response)
-
-	if response.MessageType == "PayloadMessage" {
-		// Payload PollResponse, we unmarshaled it right
-		return &response.Message, false, err
-	}
-
-	if response.MessageType == "HeartbeatMessage" {
-		acc.resetHeartbeat()
-		return nil, true, err
-	}
+	log.Info("Message = ", "type", response.MessageType)
 
-	err = errors.New("Could not unmarshal ACS response... Skipping")
-	return nil, true, err
+	payload, err := messageTypes.dispatch(acc, &response)
+	return payload, payload == nil, err
 }
@@ -13,19 +13,61 @@
 
 package acs
 
-import "github.com/aws/amazon-ecs-agent/agent/api"
+import (
+	"encoding/json"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+)
 
 type PollCallback func(api.Task)
 
+// PollResponse is a message ACS sends. Message is decoded according to
+// MessageType by the handler registered for it.
 type PollResponse struct {
-	MessageType string `json:"type"`
-	//TODO handle other messageTypes, this should be union type
-	Message Payload `json:"message"`
+	MessageType string          `json:"type"`
+	Message     json.RawMessage `json:"message"`
 }
 
 type Payload struct {
 	Tasks     []*api.Task
 	MessageId string
+
+	// Reconciliation, if set, is the authoritative set of tasks the instance
+	// should have, from a TaskReconciliationMessage
+	Reconciliation []TaskDesiredStatus `json:"-"`
+}
+
+// TaskReconciliation is the message of a TaskReconciliationMessage. It lists
+// every task the instance should have; the agent stops any other task.
+type TaskReconciliation struct {
+	MessageId string              `json:"messageId"`
+	Tasks     []TaskDesiredStatus `json:"tasks"`
+}
+
+// TaskDesiredStatus is a task of a TaskReconciliation
+type TaskDesiredStatus struct {
+	Arn           string         `json:"arn"`
+	DesiredStatus api.TaskStatus `json:"desiredStatus"`
+}
+
+// ReconciliationResult reports to ACS the outcome of a TaskReconciliation
+type ReconciliationResult struct {
+	MessageType          string `json:"type"`
+	ClusterArn           string `json:"cluster"`
+	ContainerInstanceArn string `json:"containerInstance"`
+	MessageId            string `json:"messageId"`
+
+	// StoppedTasks are the tasks the agent stopped
+	StoppedTasks []StoppedTask `json:"stoppedTasks"`
+	// UnknownTasks are the listed tasks the agent does not have; ACS must
+	// send them in a payload
+	UnknownTasks []string `json:"unknownTasks"`
+}
+
+// StoppedTask is a task stopped by reconciliation, and why
+type StoppedTask struct {
+	Arn    string `json:"arn"`
+	Reason string `json:"reason"`
 }
 
 type HealthResponse struct {
@@ -14,6 +14,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	mathrand "math/rand"
 	"os"
@@ -29,6 +30,7 @@ import (
 	"github.com/aws/amazon-ecs-agent/agent/handlers"
 	"github.com/aws/amazon-ecs-agent/agent/logger"
 	"github.com/aws/amazon-ecs-agent/agent/sighandlers"
+	"github.com/aws/amazon-ecs-agent/agent/standalone"
 	"github.com/aws/amazon-ecs-agent/agent/statemanager"
 	"github.com/aws/amazon-ecs-agent/agent/utils"
 )
@@ -38,6 +40,10 @@ func init() {
 }
 
 func main() {
+	if ran, code := runSubcommand(os.Args[1:]); ran {
+		os.Exit(code)
+	}
+
 	acceptInsecureCert := flag.Bool("k", false, "Do not verify ssl certs")
 	logLevel := flag.String("loglevel", "", "Loglevel: [<crit>|<error>|<warn>|<info>|<debug>]")
 	flag.Parse()
@@ -53,6 +59,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The EC2 metadata service is trusted the same way as the backend,
+	// without its pinned keys
+	tlsPolicy, err := api.NewTLSPolicy(cfg)
+	if err != nil {
+		log.Crit("Invalid TLS configuration", "err", err)
+		os.Exit(1)
+	}
+	tlsPolicy.PinnedKeys = nil
+	metadataTLSConfig, err := tlsPolicy.Config(false)
+	if err != nil {
+		log.Crit("Invalid TLS configuration", "err", err)
+		os.Exit(1)
+	}
+	ec2.DefaultClient = ec2.NewEC2MetadataClientWithTLS(metadataTLSConfig)
+
 	var currentEc2InstanceID, containerInstanceArn string
 	var taskEngine engine.TaskEngine
 
@@ -80,14 +101,17 @@ func main() {
 				configuredCluster = config.DEFAULT_CLUSTER_NAME
 			}
 			if previousCluster != configuredCluster {
-				log.Crit("Data mismatch; saved cluster does not match configured cluster. Perhaps you want to delete the configured checkpoint file?", "saved", previousCluster, "configured", configuredCluster)
+				log.Crit("Data mismatch; saved cluster does not match configured cluster. Perhaps you want to change it with `agent state set-cluster`?", "saved", previousCluster, "configured", configuredCluster)
 				os.Exit(1)
 			}
 			cfg.Cluster = previousCluster
 			log.Info("Restored cluster", "cluster", cfg.Cluster)
 		}
 
-		if instanceIdentityDoc, err := ec2.GetInstanceIdentityDocument(); err == nil {
+		if cfg.Standalone {
+			// Not necessarily on EC2; there is no instance to check against
+			currentEc2InstanceID = previousEc2InstanceID
+		} else if instanceIdentityDoc, err := ec2.GetInstanceIdentityDocument(); err == nil {
 			currentEc2InstanceID = instanceIdentityDoc.InstanceId
 		} else {
 			log.Crit("Unable to access EC2 Metadata service to determine EC2 ID", "err", err)
@@ -115,7 +139,17 @@ func main() {
 	}
 
 	credentialProvider := auth.NewBasicAWSCredentialProvider()
-	client := api.NewECSClient(credentialProvider, cfg, *acceptInsecureCert)
+	var client api.ECSClient
+	if cfg.Standalone {
+		// State changes go to the local sink instead of ECS
+		client, err = standalone.NewSink(cfg.StandaloneSink)
+		if err != nil {
+			log.Crit("Error opening standalone state change sink", "err", err)
+			os.Exit(1)
+		}
+	} else {
+		client = api.NewECSClient(credentialProvider, cfg, *acceptInsecureCert)
+	}
 
 	if containerInstanceArn == "" {
 		log.Info("Registering Instance with ECS")
@@ -138,13 +172,27 @@ func main() {
 	sighandlers.StartTerminationHandler(stateManager)
 
 	// Agent introspection api
-	go handlers.ServeHttp(&containerInstanceArn, taskEngine, cfg)
+	acsMonitor := acs.NewMonitor()
+	go handlers.ServeHttp(&containerInstanceArn, taskEngine, acsMonitor, cfg)
 
 	// Start sending events to the backend
 	go eventhandler.HandleEngineEvents(taskEngine, client, stateManager)
 
+	if cfg.Standalone {
+		log.Info("Running standalone; reading tasks from local sources")
+		err = standalone.Run(standalone.Sources(cfg), taskEngine, stateManager)
+		log.Crit("Standalone mode stopped", "err", err)
+		os.Exit(1)
+	}
+
+	forceSaver, ok := stateManager.(statemanager.ForceSaver)
+	if !ok {
+		log.Crit("State manager cannot save immediately")
+		os.Exit(1)
+	}
+	payloadHandler := acs.NewPayloadHandler(taskEngine, forceSaver)
+
 	log.Info("Beginning Polling for updates")
-	// Todo, split into separate package
 	for {
 		backoff := utils.NewSimpleBackoff(time.Second, 1*time.Minute, 0.2, 2)
 		utils.RetryWithBackoff(backoff, func() error {
@@ -162,43 +210,18 @@ func main() {
 				return err
 			}
 
-			var err_ok bool
-			for state_changes != nil {
-				select {
-				case state, state_ok := <-state_changes:
-					if state_ok {
-						backoff.Reset()
-
-						go func(payload *acs.Payload) {
-							for _, task := range payload.Tasks {
-								taskEngine.AddTask(task)
-							}
-
-							err = stateManager.Save()
-							if err != nil {
-								log.Error("Error saving state", "err", err)
-							}
-							acsObj.Ack(payload)
-						}(state)
-					} else {
-						// Break out of the loop to reconnect
-						state_changes = nil
-					}
-				case err, err_ok = <-errc:
-					if !err_ok {
-						log.Error("Error channel unexpectedly closed")
-
-						state_changes = nil // break out
-					}
-					log.Error("Error in state", "err", err)
-				}
+			acsMonitor.Track(acsObj)
+
+			_, err = payloadHandler.Run(state_changes, errc, acsObj)
+			if acsObj.Health().MessagesReceived > 0 {
+				// The connection worked, so reconnect promptly
+				backoff.Reset()
 			}
-			if err != nil {
-				log.Warn("Error polling. Waiting and retrying")
-				return err
+			if err == nil {
+				err = errors.New("ACS connection closed")
 			}
-			// Shouldn't happen
-			return nil
+			log.Warn("Error polling. Waiting and retrying", "err", err)
+			return err
 		})
 	}
 }
@@ -207,12 +230,16 @@ func initializeStateManager(cfg *config.Config, taskEngine engine.TaskEngine, cl
 	if !cfg.Checkpoint {
 		return statemanager.NewNoopStateManager(), nil
 	}
-	stateManager, err := statemanager.NewStateManager(cfg,
+	options := []statemanager.Option{
 		statemanager.AddSaveable("TaskEngine", taskEngine),
 		statemanager.AddSaveable("ContainerInstanceArn", containerInstanceArn),
 		statemanager.AddSaveable("Cluster", cluster),
 		statemanager.AddSaveable("EC2InstanceID", savedInstanceID),
-	)
+	}
+	if replayer, ok := taskEngine.(statemanager.JournalReplayer); ok {
+		options = append(options, statemanager.WithJournalReplayer(replayer))
+	}
+	stateManager, err := statemanager.NewStateManager(cfg, options...)
 	if err != nil {
 		return nil, err
 	}
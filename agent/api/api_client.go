@@ -14,9 +14,9 @@
 package api
 
 import (
-	"crypto/tls"
 	"errors"
 	"runtime"
+	"sync"
 
 	"github.com/aws/amazon-ecs-agent/agent/ecs_client/awsjson/codec"
 	"github.com/aws/amazon-ecs-agent/agent/ecs_client/client/dialer"
@@ -51,6 +51,9 @@ type ApiECSClient struct {
 
 	// Swappable impl for testing
 	serviceClientFn func() (svc.AmazonEC2ContainerServiceV20141113, error)
+
+	serviceClientLock   sync.Mutex
+	cachedServiceClient svc.AmazonEC2ContainerServiceV20141113
 }
 
 const (
@@ -65,7 +68,17 @@ func NewECSClient(credentialProvider credentials.AWSCredentialProvider, config *
 		insecureSkipVerify: insecureSkipVerify,
 	}
 	client.serviceClientFn = func() (svc.AmazonEC2ContainerServiceV20141113, error) {
-		return client.serviceClientImpl()
+		// The service client, and so its connections, are reused across calls
+		client.serviceClientLock.Lock()
+		defer client.serviceClientLock.Unlock()
+		if client.cachedServiceClient == nil {
+			ecs, err := client.serviceClientImpl()
+			if err != nil {
+				return nil, err
+			}
+			client.cachedServiceClient = ecs
+		}
+		return client.cachedServiceClient, nil
 	}
 	return client
 }
@@ -83,7 +96,17 @@ func (client *ApiECSClient) serviceClientImpl() (svc.AmazonEC2ContainerServiceV2
 
 	c := codec.AwsJson{Host: config.APIEndpoint, SignerV4: signer}
 
-	d, err := dialer.TLS(config.APIEndpoint, config.APIPort, &tls.Config{InsecureSkipVerify: client.insecureSkipVerify})
+	proxy, err := NewProxy(config)
+	if err != nil {
+		log.Error("Invalid proxy", "proxy", config.HTTPProxy, "err", err)
+		return nil, err
+	}
+	tlsConfig, err := NewTLSConfig(config, client.insecureSkipVerify)
+	if err != nil {
+		log.Error("Invalid TLS configuration", "err", err)
+		return nil, err
+	}
+	d, err := dialer.ProxiedTLS(config.APIEndpoint, config.APIPort, tlsConfig, proxy)
 
 	if err != nil {
 		log.Error("Cannot resolve url", "url", config.APIEndpoint, "port", config.APIPort, "err", err)
@@ -264,6 +287,13 @@ func (client *ApiECSClient) SubmitTaskStateChange(change ContainerStateChange) u
 	req.SetTask(&change.TaskArn)
 	req.SetStatus(&stat)
 	req.SetCluster(&client.config.Cluster)
+	if stat == "STOPPED" && change.Task != nil && change.Task.StoppedReason != "" {
+		reason := change.Task.StoppedReason
+		if len(reason) > EcsMaxReasonLength {
+			reason = reason[:EcsMaxReasonLength]
+		}
+		req.SetReason(&reason)
+	}
 
 	c, err := client.serviceClient()
 	if err != nil {
@@ -55,6 +55,12 @@ func (mock *mockAmazonEC2ContainerServiceV20141113Client) SubmitContainerStateCh
 	return resp.(svc.SubmitContainerStateChangeResponse), err
 }
 
+func (mock *mockAmazonEC2ContainerServiceV20141113Client) SubmitTaskStateChange(req svc.SubmitTaskStateChangeRequest) (svc.SubmitTaskStateChangeResponse, error) {
+	mock.addRequest(req)
+	resp, err := mock.getResponse("SubmitTaskStateChange", svc.NewSubmitTaskStateChangeResponse(), nil)
+	return resp.(svc.SubmitTaskStateChangeResponse), err
+}
+
 func NewMockClient() (ECSClient, *mockAmazonEC2ContainerServiceV20141113Client) {
 	client := NewECSClient(auth.TestCredentialProvider{}, &config.Config{Cluster: configuredCluster}, false)
 	mockSvcClient := &mockAmazonEC2ContainerServiceV20141113Client{}
@@ -155,6 +161,23 @@ func TestSubmitContainerStateChangeLongReason(t *testing.T) {
 	}
 }
 
+func TestSubmitTaskStateChangeReason(t *testing.T) {
+	client, mockSvcClient := NewMockClient()
+
+	err := client.SubmitTaskStateChange(ContainerStateChange{
+		TaskArn:    "arn",
+		TaskStatus: TaskStopped,
+		Task:       &Task{Arn: "arn", StoppedReason: strings.Repeat("a", EcsMaxReasonLength+1)},
+	})
+	if err != nil {
+		t.Error("Unable to submit task state change", err)
+	}
+	req := mockSvcClient.lastRequest().(svc.SubmitTaskStateChangeRequest)
+	if *req.Reason() != strings.Repeat("a", EcsMaxReasonLength) {
+		t.Error("Submitted wrong reason")
+	}
+}
+
 func (mock *mockAmazonEC2ContainerServiceV20141113Client) RegisterContainerInstance(req svc.RegisterContainerInstanceRequest) (svc.RegisterContainerInstanceResponse, error) {
 	mock.addRequest(req)
 	defaultResponse := svc.NewRegisterContainerInstanceResponse()
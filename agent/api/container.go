@@ -34,3 +34,14 @@ func (c *Container) KnownTerminal() bool {
 func (c *Container) DesiredTerminal() bool {
 	return c.DesiredStatus.Terminal()
 }
+
+// runsAfter returns true if the named container is one of this container's
+// RunDependencies
+func (c *Container) runsAfter(name string) bool {
+	for _, dependency := range c.RunDependencies {
+		if dependency == name {
+			return true
+		}
+	}
+	return false
+}
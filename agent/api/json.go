@@ -145,13 +145,33 @@ func (tv *TaskVolume) UnmarshalJSON(b []byte) error {
 			return err
 		}
 		if hv.FSSourcePath == "" {
-			tv.Volume = &EmptyHostVolume{}
+			var empty EmptyHostVolume
+			if err := json.Unmarshal(host, &empty); err != nil {
+				return err
+			}
+			tv.Volume = &empty
 		} else {
 			tv.Volume = &hv
 		}
 		return nil
 	}
 
+	if docker, ok := intermediate["dockerVolumeConfiguration"]; ok {
+		var dv DockerVolume
+		if err := json.Unmarshal(docker, &dv); err != nil {
+			return err
+		}
+		switch dv.Scope {
+		case "":
+			dv.Scope = DockerVolumeScopeTask
+		case DockerVolumeScopeTask, DockerVolumeScopeShared:
+		default:
+			return errors.New("invalid Volume; unknown docker volume scope: " + dv.Scope)
+		}
+		tv.Volume = &dv
+		return nil
+	}
+
 	return errors.New("unrecognized volume type; try updating me")
 }
 
@@ -165,6 +185,8 @@ func (tv *TaskVolume) MarshalJSON() ([]byte, error) {
 		result["host"] = v
 	case *EmptyHostVolume:
 		result["host"] = v
+	case *DockerVolume:
+		result["dockerVolumeConfiguration"] = v
 	default:
 		log.Crit("Unknown task volume type in marshal")
 	}
@@ -0,0 +1,39 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package api
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/client/dialer"
+)
+
+// The EC2 metadata service's host, which is never proxied
+const ec2MetadataHost = "169.254.169.254"
+
+// NewProxy returns the proxy connections to ECS and ACS are tunneled
+// through, or nil if cfg configures none. The EC2 metadata service and a
+// Docker daemon reached over TCP are always connected to directly.
+func NewProxy(cfg *config.Config) (*dialer.Proxy, error) {
+	noProxy := []string{ec2MetadataHost}
+	if endpoint, err := url.Parse(cfg.DockerEndpoint); err == nil && endpoint.Scheme != "unix" && endpoint.Host != "" {
+		noProxy = append(noProxy, endpoint.Host)
+	}
+	if cfg.NoProxy != "" {
+		noProxy = append(noProxy, cfg.NoProxy)
+	}
+	return dialer.ParseProxy(cfg.HTTPProxy, strings.Join(noProxy, ","))
+}
@@ -0,0 +1,43 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+)
+
+func TestNewProxy(t *testing.T) {
+	if proxy, err := NewProxy(&config.Config{}); proxy != nil || err != nil {
+		t.Error("Expected no proxy by default", proxy, err)
+	}
+
+	proxy, err := NewProxy(&config.Config{
+		HTTPProxy:      "http://proxy:3128",
+		NoProxy:        ".internal",
+		DockerEndpoint: "tcp://docker.example.com:2375",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"169.254.169.254:80", "docker.example.com:2375", "ecs.internal:443"} {
+		if !proxy.Bypass(host) {
+			t.Error("Expected a direct connection to", host)
+		}
+	}
+	if proxy.Bypass("ecs.us-west-2.amazonaws.com:443") {
+		t.Error("Expected ECS to be proxied")
+	}
+}
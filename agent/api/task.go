@@ -20,10 +20,13 @@ import (
 	"time"
 
 	"github.com/aws/amazon-ecs-agent/agent/engine/emptyvolume"
+	"github.com/aws/amazon-ecs-agent/agent/engine/pause"
+	"github.com/aws/amazon-ecs-agent/agent/utils/ttime"
 	"github.com/fsouza/go-dockerclient"
 )
 
 const emptyHostVolumeName = "~internal~ecs-emptyvolume-source"
+const pauseContainerName = "~internal~ecs-pause"
 
 // PostUnmarshalTask is run after a task has been unmarshalled, but before it has been
 // run. It is possible it will be subsequently called after that and should be
@@ -33,6 +36,7 @@ func (task *Task) PostUnmarshalTask() {
 	// hook into this
 
 	task.initializeEmptyVolumes()
+	task.initializeSharedNetwork()
 }
 
 func (task *Task) initializeEmptyVolumes() {
@@ -43,7 +47,7 @@ func (task *Task) initializeEmptyVolumes() {
 			if !ok {
 				continue
 			}
-			if _, ok := vol.(*EmptyHostVolume); ok {
+			if empty, ok := vol.(*EmptyHostVolume); ok && empty.ManagedPath == "" {
 				if container.RunDependencies == nil {
 					container.RunDependencies = make([]string, 0)
 				}
@@ -81,6 +85,84 @@ func (task *Task) initializeEmptyVolumes() {
 
 }
 
+// initializeSharedNetwork adds an 'internal' pause container to tasks using
+// the shared network mode. Every other container joins its network namespace,
+// and so must wait for it to run. It is essential: without it, the other
+// containers have no network.
+func (task *Task) initializeSharedNetwork() {
+	if task.NetworkMode != NetworkModeShared {
+		return
+	}
+
+	for _, container := range task.Containers {
+		if !container.IsInternal && !container.runsAfter(pauseContainerName) {
+			container.RunDependencies = append(container.RunDependencies, pauseContainerName)
+		}
+	}
+
+	if _, ok := task.ContainerByName(pauseContainerName); !ok {
+		pauseContainer := &Container{
+			Name:          pauseContainerName,
+			Image:         pause.Image + ":" + pause.Tag,
+			Essential:     true,
+			IsInternal:    true,
+			DesiredStatus: ContainerRunning,
+		}
+		task.Containers = append(task.Containers, pauseContainer)
+		task.containersByNameLock.Lock()
+		task.containersByName = nil
+		task.containersByNameLock.Unlock()
+	}
+}
+
+// PauseContainer returns the internal pause container of a task using the
+// shared network mode
+func (task *Task) PauseContainer() (*Container, bool) {
+	if task.NetworkMode != NetworkModeShared {
+		return nil, false
+	}
+	return task.ContainerByName(pauseContainerName)
+}
+
+// joinsSharedNetwork returns true if the container runs in the network
+// namespace of the task's pause container
+func (task *Task) joinsSharedNetwork(container *Container) bool {
+	return task.NetworkMode == NetworkModeShared && !container.IsInternal
+}
+
+// ContainerIPAddress returns the IP address the given container is reachable
+// at, or an empty string if it has none of its own
+func (task *Task) ContainerIPAddress(container *Container) string {
+	if task.joinsSharedNetwork(container) {
+		if pauseContainer, ok := task.ContainerByName(pauseContainerName); ok {
+			return pauseContainer.KnownIPAddress
+		}
+	}
+	return container.KnownIPAddress
+}
+
+// SharedPortBindings returns the port bindings of the task's pause container
+// that belong to the given container, if it joins the shared network
+func (task *Task) SharedPortBindings(container *Container) ([]PortBinding, bool) {
+	if !task.joinsSharedNetwork(container) {
+		return nil, false
+	}
+	pauseContainer, ok := task.ContainerByName(pauseContainerName)
+	if !ok {
+		return nil, false
+	}
+	bindings := []PortBinding{}
+	for _, binding := range pauseContainer.KnownPortBindings {
+		for _, port := range container.Ports {
+			if binding.ContainerPort == port.ContainerPort {
+				bindings = append(bindings, binding)
+				break
+			}
+		}
+	}
+	return bindings, true
+}
+
 func (task *Task) _containersByName() map[string]*Container {
 	task.containersByNameLock.Lock()
 	defer task.containersByNameLock.Unlock()
@@ -120,7 +202,7 @@ func (task *Task) UpdateMountPoints(cont *Container, vols map[string]string) {
 		}
 		if ok {
 			if hostVolume, exists := task.HostVolumeByName(mountPoint.SourceVolume); exists {
-				if empty, ok := hostVolume.(*EmptyHostVolume); ok {
+				if empty, ok := hostVolume.(*EmptyHostVolume); ok && empty.ManagedPath == "" {
 					empty.hostPath = hostPath
 				}
 			}
@@ -157,7 +239,7 @@ func (task *Task) UpdateTaskStatus() (newStatus TaskStatus) {
 	log.Debug("Updating task", "task", task)
 	defer func() {
 		if newStatus != TaskStatusNone {
-			task.KnownTime = time.Now()
+			task.KnownTime = ttime.Now()
 		}
 	}()
 
@@ -277,10 +359,29 @@ func (task *Task) dockerConfig(container *Container) (*docker.Config, error) {
 	return config, nil
 }
 
+// publishedPorts returns the ports docker should publish for the given
+// container. In the shared network mode, the pause container publishes the
+// ports of every container in the task.
+func (task *Task) publishedPorts(container *Container) []PortBinding {
+	if task.joinsSharedNetwork(container) {
+		return nil
+	}
+	if task.NetworkMode == NetworkModeShared && container.Name == pauseContainerName {
+		ports := []PortBinding{}
+		for _, cont := range task.Containers {
+			if task.joinsSharedNetwork(cont) {
+				ports = append(ports, cont.Ports...)
+			}
+		}
+		return ports
+	}
+	return container.Ports
+}
+
 func (task *Task) dockerExposedPorts(container *Container) map[docker.Port]struct{} {
 	dockerExposedPorts := make(map[docker.Port]struct{})
 
-	for _, portBinding := range container.Ports {
+	for _, portBinding := range task.publishedPorts(container) {
 		dockerPort := docker.Port(strconv.Itoa(int(portBinding.ContainerPort)) + "/tcp")
 		dockerExposedPorts[dockerPort] = struct{}{}
 	}
@@ -331,15 +432,51 @@ func (task *Task) dockerHostConfig(container *Container, dockerContainerMap map[
 		return nil, err
 	}
 
+	networkMode, err := task.dockerNetworkMode(container, dockerContainerMap)
+	if err != nil {
+		return nil, err
+	}
+
 	hostConfig := &docker.HostConfig{
 		Links:        dockerLinkArr,
 		Binds:        binds,
 		PortBindings: dockerPortMap,
 		VolumesFrom:  volumesFrom,
+		NetworkMode:  networkMode,
 	}
 	return hostConfig, nil
 }
 
+// dockerNetworkMode returns the docker network mode for the given container
+// in this task's network mode
+func (task *Task) dockerNetworkMode(container *Container, dockerContainerMap map[string]*DockerContainer) (string, error) {
+	switch task.NetworkMode {
+	case "", NetworkModeBridge:
+		// Docker's default
+		return "", nil
+	case NetworkModeNone:
+		return NetworkModeNone, nil
+	case NetworkModeHost:
+		if len(container.Links) > 0 {
+			return "", errors.New("Links are not supported in the host network mode")
+		}
+		return NetworkModeHost, nil
+	case NetworkModeShared:
+		if !task.joinsSharedNetwork(container) {
+			return "", nil
+		}
+		if len(container.Links) > 0 {
+			return "", errors.New("Links are not supported in the shared network mode; containers share localhost")
+		}
+		pauseContainer, ok := dockerContainerMap[pauseContainerName]
+		if !ok {
+			return "", errors.New("Shared network not available")
+		}
+		return "container:" + pauseContainer.DockerName, nil
+	}
+	return "", errors.New("Unsupported network mode: " + task.NetworkMode)
+}
+
 func (task *Task) dockerLinks(container *Container, dockerContainerMap map[string]*DockerContainer) ([]string, error) {
 	dockerLinkArr := make([]string, len(container.Links))
 	for i, link := range container.Links {
@@ -369,7 +506,7 @@ func (task *Task) dockerLinks(container *Container, dockerContainerMap map[strin
 func (task *Task) dockerPortMap(container *Container) map[docker.Port][]docker.PortBinding {
 	dockerPortMap := make(map[docker.Port][]docker.PortBinding)
 
-	for _, portBinding := range container.Ports {
+	for _, portBinding := range task.publishedPorts(container) {
 		dockerPort := docker.Port(strconv.Itoa(int(portBinding.ContainerPort)) + "/tcp")
 		currentMappings, existing := dockerPortMap[dockerPort]
 		if existing {
@@ -424,3 +561,28 @@ func (task *Task) dockerHostBinds(container *Container) ([]string, error) {
 
 	return binds, nil
 }
+
+// StopRequested records that the named container was asked to stop
+func (shutdown *TaskShutdown) StopRequested(name string, at time.Time) {
+	for _, cont := range shutdown.Containers {
+		if cont.Name == name {
+			// Asked again after a failed attempt; keep the first request
+			return
+		}
+	}
+	shutdown.Containers = append(shutdown.Containers, &ContainerShutdown{Name: name, StopRequestedAt: at})
+}
+
+// Stopped records that the named container is known to have stopped. A
+// container that stopped without being asked to is recorded as well.
+func (shutdown *TaskShutdown) Stopped(name string, at time.Time) {
+	for _, cont := range shutdown.Containers {
+		if cont.Name == name {
+			if cont.StoppedAt.IsZero() {
+				cont.StoppedAt = at
+			}
+			return
+		}
+	}
+	shutdown.Containers = append(shutdown.Containers, &ContainerShutdown{Name: name, StoppedAt: at})
+}
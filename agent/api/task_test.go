@@ -93,3 +93,100 @@ func TestDockerHostConfigVolumesFrom(t *testing.T) {
 		t.Error("Expected volumesFrom to be resolved, was: ", config.VolumesFrom)
 	}
 }
+
+func TestSharedNetworkMode(t *testing.T) {
+	testTask := &Task{
+		NetworkMode: NetworkModeShared,
+		Containers: []*Container{
+			&Container{
+				Name:  "web",
+				Ports: []PortBinding{PortBinding{80, 8080, ""}},
+			},
+			&Container{
+				Name:  "sidecar",
+				Ports: []PortBinding{PortBinding{9000, 9000, ""}},
+			},
+		},
+	}
+	testTask.PostUnmarshalTask()
+	testTask.PostUnmarshalTask()
+
+	if len(testTask.Containers) != 3 {
+		t.Fatal("Expected a single pause container to be added, got containers: ", len(testTask.Containers))
+	}
+	pauseContainer, ok := testTask.PauseContainer()
+	if !ok || !pauseContainer.IsInternal || !pauseContainer.Essential {
+		t.Fatal("Expected an internal, essential pause container")
+	}
+	for _, cont := range testTask.Containers[:2] {
+		if !reflect.DeepEqual(cont.RunDependencies, []string{pauseContainerName}) {
+			t.Error("Expected container to run after the pause container, got: ", cont.RunDependencies)
+		}
+	}
+
+	webConfig, err := testTask.DockerHostConfig(testTask.Containers[0], dockerMap(testTask))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webConfig.NetworkMode != "container:dockername-"+pauseContainerName {
+		t.Error("Expected web to join the pause container's network, got: ", webConfig.NetworkMode)
+	}
+	if len(webConfig.PortBindings) != 0 {
+		t.Error("Expected web to publish no ports itself, got: ", webConfig.PortBindings)
+	}
+
+	pauseConfig, err := testTask.DockerHostConfig(pauseContainer, dockerMap(testTask))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pauseConfig.NetworkMode != "" {
+		t.Error("Expected the pause container to use the default network, got: ", pauseConfig.NetworkMode)
+	}
+	if len(pauseConfig.PortBindings) != 2 || pauseConfig.PortBindings["80/tcp"][0].HostPort != "8080" {
+		t.Error("Expected the pause container to publish every container's ports, got: ", pauseConfig.PortBindings)
+	}
+
+	pauseContainer.KnownIPAddress = "172.17.0.2"
+	pauseContainer.KnownPortBindings = []PortBinding{PortBinding{80, 8080, "0.0.0.0"}, PortBinding{9000, 9000, "0.0.0.0"}}
+	if ip := testTask.ContainerIPAddress(testTask.Containers[1]); ip != "172.17.0.2" {
+		t.Error("Expected sidecar to be reachable at the pause container's address, got: ", ip)
+	}
+	bindings, ok := testTask.SharedPortBindings(testTask.Containers[0])
+	if !ok || !reflect.DeepEqual(bindings, []PortBinding{PortBinding{80, 8080, "0.0.0.0"}}) {
+		t.Error("Expected web's port bindings from the pause container, got: ", bindings)
+	}
+}
+
+func TestDockerHostConfigNetworkMode(t *testing.T) {
+	testTask := &Task{
+		NetworkMode: NetworkModeHost,
+		Containers: []*Container{
+			&Container{
+				Name: "c1",
+			},
+		},
+	}
+	testTask.PostUnmarshalTask()
+	if len(testTask.Containers) != 1 {
+		t.Error("Host network mode should not add a pause container")
+	}
+
+	config, err := testTask.DockerHostConfig(testTask.Containers[0], dockerMap(testTask))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.NetworkMode != "host" {
+		t.Error("Expected host network mode, got: ", config.NetworkMode)
+	}
+
+	testTask.Containers[0].Links = []string{"c2:c2"}
+	if _, err = testTask.DockerHostConfig(testTask.Containers[0], dockerMap(testTask)); err == nil {
+		t.Error("Expected links to be rejected in the host network mode")
+	}
+
+	testTask.Containers[0].Links = nil
+	testTask.NetworkMode = "overlay"
+	if _, err = testTask.DockerHostConfig(testTask.Containers[0], dockerMap(testTask)); err == nil {
+		t.Error("Expected an unsupported network mode to be rejected")
+	}
+}
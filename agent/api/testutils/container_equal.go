@@ -73,6 +73,9 @@ func ContainersEqual(lhs, rhs *api.Container) bool {
 	if !reflect.DeepEqual(lhs.Environment, rhs.Environment) {
 		return false
 	}
+	if !reflect.DeepEqual(lhs.Secrets, rhs.Secrets) {
+		return false
+	}
 	if !ContainerOverridesEqual(lhs.Overrides, rhs.Overrides) {
 		return false
 	}
@@ -0,0 +1,55 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package api
+
+import (
+	"crypto/tls"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/client/dialer"
+)
+
+// NewTLSPolicy returns the TLS policy cfg configures for connections to ECS
+// and ACS. The EC2 metadata service uses it without its pinned keys.
+func NewTLSPolicy(cfg *config.Config) (*dialer.TLSPolicy, error) {
+	minVersion, err := dialer.ParseTLSVersion(cfg.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := dialer.ParseCipherPolicy(cfg.TLSCipherPolicy)
+	if err != nil {
+		return nil, err
+	}
+	pinnedKeys, err := dialer.ParsePinnedKeys(cfg.TLSPinnedKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &dialer.TLSPolicy{
+		CAFile:       cfg.TLSCAFile,
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		PinnedKeys:   pinnedKeys,
+	}, nil
+}
+
+// NewTLSConfig returns the tls.Config of connections to ECS and ACS. If
+// insecureSkipVerify is set, certificate chains are not verified but pinned
+// keys are still required.
+func NewTLSConfig(cfg *config.Config, insecureSkipVerify bool) (*tls.Config, error) {
+	policy, err := NewTLSPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return policy.Config(insecureSkipVerify)
+}
@@ -53,6 +53,22 @@ type PortBinding struct {
 
 type TaskOverrides struct{}
 
+// Network modes a task may use. Every container in the task is attached to
+// the network in the same way.
+const (
+	// NetworkModeBridge attaches each container to docker's default bridge;
+	// containers reach each other through links. It is the default.
+	NetworkModeBridge = "bridge"
+	// NetworkModeHost uses the host's network stack directly
+	NetworkModeHost = "host"
+	// NetworkModeNone gives each container only a loopback interface
+	NetworkModeNone = "none"
+	// NetworkModeShared places every container in the network namespace of
+	// an internal pause container, like a pod; containers reach each other
+	// over localhost.
+	NetworkModeShared = "shared"
+)
+
 type Task struct {
 	Arn        string
 	Overrides  TaskOverrides `json:"-"`
@@ -60,6 +76,9 @@ type Task struct {
 	Version    string
 	Containers []*Container
 	Volumes    []TaskVolume `json:"volumes"`
+	// NetworkMode is one of the NetworkMode constants; if empty, the bridge
+	// mode is used
+	NetworkMode string `json:"networkMode"`
 
 	DesiredStatus TaskStatus
 	KnownStatus   TaskStatus
@@ -67,10 +86,42 @@ type Task struct {
 
 	SentStatus TaskStatus
 
+	// StoppedReason records why the agent was asked to stop the task, if it
+	// was asked with a reason. It is reported with the task's stopped state
+	// changes.
+	StoppedReason string `json:",omitempty"`
+
+	// Shutdown records how the task's containers were stopped, for debugging.
+	// It is nil until the task starts stopping.
+	Shutdown *TaskShutdown `json:",omitempty"`
+
 	containersByNameLock sync.Mutex
 	containersByName     map[string]*Container
 }
 
+// TaskShutdown records the order and timing in which the agent stopped the
+// containers of a task. Containers are stopped before the containers they
+// depend on unless the deadline passes first.
+type TaskShutdown struct {
+	StartedAt time.Time
+	Deadline  time.Time
+	// DeadlineExceeded is true if the deadline passed before every container
+	// had stopped, after which the remaining containers were stopped without
+	// regard to their dependencies
+	DeadlineExceeded bool
+
+	// Containers is in the order the containers were asked to stop
+	Containers []*ContainerShutdown
+}
+
+// ContainerShutdown records when a container was asked to stop and when it
+// was known to have stopped
+type ContainerShutdown struct {
+	Name            string
+	StopRequestedAt time.Time
+	StoppedAt       time.Time
+}
+
 // TaskVolume is a definition of all the volumes available for containers to
 // reference within a task. It must be named.
 type TaskVolume struct {
@@ -103,14 +154,60 @@ func (fs *FSHostVolume) SourcePath() string {
 	return fs.FSSourcePath
 }
 
+// EmptyHostVolume is a type of HostVolume that starts out empty and lasts as
+// long as its task. It is provided either by a volume of the internal
+// emptyvolume container, or by a directory the agent manages.
 type EmptyHostVolume struct {
+	// ManagedPath is the agent-managed directory backing the volume, as the
+	// agent sees it. It is empty if the volume is provided by the emptyvolume
+	// container.
+	ManagedPath string `json:"managedPath,omitempty"`
+	// ManagedHostPath is ManagedPath as the host sees it, which is bound into
+	// containers. Volumes saved before it was recorded are bound from
+	// ManagedPath.
+	ManagedHostPath string `json:"managedHostPath,omitempty"`
+
 	hostPath string `json:"-"`
 }
 
 func (e *EmptyHostVolume) SourcePath() string {
+	if e.ManagedHostPath != "" {
+		return e.ManagedHostPath
+	}
+	if e.ManagedPath != "" {
+		return e.ManagedPath
+	}
 	return e.hostPath
 }
 
+// Docker volume scopes
+const (
+	// DockerVolumeScopeTask volumes are created for a single task and removed
+	// along with its containers. It is the default.
+	DockerVolumeScopeTask = "task"
+	// DockerVolumeScopeShared volumes are named "ecs-shared-" followed by the
+	// task volume's name, reused by every task that uses that name, and
+	// removed once no task references them.
+	DockerVolumeScopeShared = "shared"
+)
+
+// DockerVolume is a type of HostVolume backed by a docker named volume, which
+// the agent creates with the given driver and driver options.
+type DockerVolume struct {
+	Scope      string            `json:"scope"`
+	Driver     string            `json:"driver"`
+	DriverOpts map[string]string `json:"driverOpts"`
+
+	// DockerName is the name of the volume in docker. It is set when the
+	// volume is first used by the task.
+	DockerName string `json:"dockerName"`
+}
+
+// SourcePath returns the name of the docker volume to mount
+func (v *DockerVolume) SourcePath() string {
+	return v.DockerName
+}
+
 type ContainerStateChange struct {
 	TaskArn       string
 	ContainerName string
@@ -168,9 +265,17 @@ type Container struct {
 	Environment map[string]string  `json:"environment"`
 	Overrides   ContainerOverrides `json:"overrides"`
 
+	// Secrets are environment variables whose values are looked up by the
+	// agent when the container is created. Only their names are stored.
+	Secrets []Secret `json:"secrets"`
+
 	DesiredStatus ContainerStatus `json:"desiredStatus"`
 	KnownStatus   ContainerStatus
 
+	// DependsOn is a list of conditions other containers in the task must
+	// meet before this one is started
+	DependsOn []DependsOn `json:"dependsOn"`
+
 	// RunDependencies is a list of containers that must be run before
 	// this one is created
 	RunDependencies []string
@@ -184,9 +289,38 @@ type Container struct {
 
 	KnownExitCode     *int
 	KnownPortBindings []PortBinding
+	KnownIPAddress    string
+}
+
+// DependencyCondition is the condition a container must meet before the
+// containers that depend on it may start
+type DependencyCondition string
+
+const (
+	// DependencyStart is met once the container is running
+	DependencyStart DependencyCondition = "START"
+	// DependencyComplete is met once the container has exited
+	DependencyComplete DependencyCondition = "COMPLETE"
+	// DependencySuccess is met once the container has exited with code 0
+	DependencySuccess DependencyCondition = "SUCCESS"
+	// DependencyHealthy would be met once the container reports that it is
+	// healthy. It is not supported, because the docker API the agent uses
+	// does not report container health; tasks using it are rejected.
+	DependencyHealthy DependencyCondition = "HEALTHY"
+)
+
+// DependsOn declares that a container may not start until another container
+// in the same task has met a condition.
+type DependsOn struct {
+	ContainerName string              `json:"containerName"`
+	Condition     DependencyCondition `json:"condition"`
+}
 
-	// Not upstream; todo move this out into a wrapper type
-	StatusLock sync.Mutex
+// Secret sets the environment variable Name to the value of the secret
+// ValueFrom.
+type Secret struct {
+	Name      string `json:"name"`
+	ValueFrom string `json:"valueFrom"`
 }
 
 // VolumeFrom is a volume which references another container as its source.
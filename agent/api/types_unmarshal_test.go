@@ -52,3 +52,67 @@ func TestHostHostVolumeUnmarshal(t *testing.T) {
 		t.Error("Wrong host path: ", fsv.SourcePath())
 	}
 }
+
+func TestDockerVolumeUnmarshal(t *testing.T) {
+	var task Task
+	err := json.Unmarshal([]byte(`{"volumes":[{"name":"test","dockerVolumeConfiguration":{"scope":"shared","driver":"local","driverOpts":{"type":"tmpfs"}}},{"name":"scratch","dockerVolumeConfiguration":{}}]}`), &task)
+	if err != nil {
+		t.Fatal("Could not unmarshal: ", err)
+	}
+	dv, ok := task.Volumes[0].Volume.(*DockerVolume)
+	if !ok {
+		t.Fatal("Wrong type")
+	}
+	if dv.Scope != DockerVolumeScopeShared || dv.Driver != "local" || dv.DriverOpts["type"] != "tmpfs" {
+		t.Error("Wrong docker volume configuration: ", dv)
+	}
+	if dv, ok = task.Volumes[1].Volume.(*DockerVolume); !ok || dv.Scope != DockerVolumeScopeTask {
+		t.Error("Docker volumes should default to the task scope")
+	}
+
+	// The docker name is persisted once it is set
+	dv.DockerName = "ecs-scratch"
+	data, err := json.Marshal(&task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Task
+	if err = json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Volumes[1].Volume.SourcePath() != "ecs-scratch" {
+		t.Error("Docker volume name was not restored: ", restored.Volumes[1].Volume.SourcePath())
+	}
+
+	err = json.Unmarshal([]byte(`{"volumes":[{"name":"test","dockerVolumeConfiguration":{"scope":"forever"}}]}`), &task)
+	if err == nil {
+		t.Error("Expected an unknown scope to be rejected")
+	}
+}
+
+func TestManagedEmptyHostVolumeUnmarshal(t *testing.T) {
+	var task Task
+	err := json.Unmarshal([]byte(`{"volumes":[{"name":"test","host":{"managedPath":"/data/emptyvolumes/task/test"}}]}`), &task)
+	if err != nil {
+		t.Fatal("Could not unmarshal: ", err)
+	}
+	empty, ok := task.Volumes[0].Volume.(*EmptyHostVolume)
+	if !ok {
+		t.Fatal("Wrong type")
+	}
+	if empty.SourcePath() != "/data/emptyvolumes/task/test" {
+		t.Error("Wrong host path: ", empty.SourcePath())
+	}
+
+	data, err := json.Marshal(&task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Task
+	if err = json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Volumes[0].Volume.SourcePath() != "/data/emptyvolumes/task/test" {
+		t.Error("Managed path was not restored: ", restored.Volumes[0].Volume.SourcePath())
+	}
+}
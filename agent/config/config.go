@@ -22,6 +22,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/amazon-ecs-agent/agent/ec2"
 	"github.com/aws/amazon-ecs-agent/agent/logger"
@@ -40,6 +41,11 @@ const (
 	AGENT_INTROSPECTION_PORT = 51678
 
 	DEFAULT_CLUSTER_NAME = "default"
+
+	DEFAULT_DOCKER_CONCURRENCY      = 10
+	DEFAULT_DOCKER_PULL_CONCURRENCY = 2
+
+	DEFAULT_TASK_STOP_TIMEOUT = 5 * time.Minute
 )
 
 // Merge merges two config files, preferring the ones on the left. Any nil or
@@ -115,6 +121,12 @@ func DefaultConfig() Config {
 		AWSRegion:      awsRegion,
 		ReservedPorts:  []uint16{SSH_PORT, DOCKER_RESERVED_PORT, DOCKER_RESERVED_SSL_PORT, AGENT_INTROSPECTION_PORT},
 		DataDir:        "/data/",
+
+		DockerConcurrency:     DEFAULT_DOCKER_CONCURRENCY,
+		DockerPullConcurrency: DEFAULT_DOCKER_PULL_CONCURRENCY,
+		TaskStopTimeout:       DEFAULT_TASK_STOP_TIMEOUT,
+		EmptyVolumeMode:       EmptyVolumeModeContainer,
+		StateBackend:          StateBackendJSON,
 	}
 }
 
@@ -161,8 +173,51 @@ func EnvironmentConfig() Config {
 	engineAuthType := os.Getenv("ECS_ENGINE_AUTH_TYPE")
 	engineAuthData := os.Getenv("ECS_ENGINE_AUTH_DATA")
 
+	dockerConcurrency, _ := strconv.Atoi(os.Getenv("ECS_DOCKER_CONCURRENCY"))
+	dockerPullConcurrency, _ := strconv.Atoi(os.Getenv("ECS_DOCKER_PULL_CONCURRENCY"))
+
+	var taskStopTimeout time.Duration
+	if taskStopTimeoutEnv := os.Getenv("ECS_TASK_STOP_TIMEOUT"); taskStopTimeoutEnv != "" {
+		parsed, err := time.ParseDuration(taskStopTimeoutEnv)
+		if err == nil {
+			taskStopTimeout = parsed
+		} else {
+			log.Warn("Invalid format for \"ECS_TASK_STOP_TIMEOUT\" environment variable; expected a duration like 5m.", "err", err)
+		}
+	}
+
+	emptyVolumeMode := os.Getenv("ECS_EMPTY_VOLUME_MODE")
+	switch emptyVolumeMode {
+	case "", EmptyVolumeModeContainer, EmptyVolumeModeHostDir:
+	default:
+		log.Warn("Invalid value for \"ECS_EMPTY_VOLUME_MODE\" environment variable; expected \"container\" or \"hostdir\".", "value", emptyVolumeMode)
+		emptyVolumeMode = ""
+	}
+	emptyVolumeSizeLimit, _ := strconv.ParseInt(os.Getenv("ECS_EMPTY_VOLUME_SIZE_LIMIT"), 10, 64)
+
+	pauseContainerImage := os.Getenv("ECS_PAUSE_CONTAINER_IMAGE")
+
+	secretsProvider := os.Getenv("ECS_SECRETS_PROVIDER")
+	secretsPath := os.Getenv("ECS_SECRETS_PATH")
+	secretsKeyFile := os.Getenv("ECS_SECRETS_KEY_FILE")
+	secretsEndpoint := os.Getenv("ECS_SECRETS_ENDPOINT")
+
+	standalone := utils.ParseBool(os.Getenv("ECS_STANDALONE"), false)
+	standaloneTaskDir := os.Getenv("ECS_STANDALONE_TASK_DIR")
+	standaloneListenAddress := os.Getenv("ECS_STANDALONE_LISTEN_ADDRESS")
+	standaloneSink := os.Getenv("ECS_STANDALONE_SINK")
+
+	httpProxy := firstEnv("HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy")
+	noProxy := firstEnv("NO_PROXY", "no_proxy")
+
+	tlsCAFile := os.Getenv("ECS_TLS_CA_FILE")
+	tlsMinVersion := os.Getenv("ECS_TLS_MIN_VERSION")
+	tlsCipherPolicy := os.Getenv("ECS_TLS_CIPHER_POLICY")
+	tlsPinnedKeys := os.Getenv("ECS_TLS_PINNED_KEYS")
+
 	var checkpoint bool
 	dataDir := os.Getenv("ECS_DATADIR")
+	hostDataDir := os.Getenv("ECS_HOST_DATA_DIR")
 	if dataDir != "" {
 		// if we have a directory to checkpoint to, default it to be on
 		checkpoint = utils.ParseBool(os.Getenv("ECS_CHECKPOINT"), true)
@@ -172,6 +227,15 @@ func EnvironmentConfig() Config {
 		checkpoint = utils.ParseBool(os.Getenv("ECS_CHECKPOINT"), false)
 	}
 
+	stateKeyFile := os.Getenv("ECS_STATE_KEY_FILE")
+	stateBackend := os.Getenv("ECS_STATE_BACKEND")
+	switch stateBackend {
+	case "", StateBackendJSON, StateBackendKV:
+	default:
+		log.Warn("Invalid value for \"ECS_STATE_BACKEND\" environment variable; expected \"json\" or \"kv\".", "value", stateBackend)
+		stateBackend = ""
+	}
+
 	// Format: json array, e.g. [1,2,3]
 	reservedPortEnv := os.Getenv("ECS_RESERVED_PORTS")
 	portDecoder := json.NewDecoder(strings.NewReader(reservedPortEnv))
@@ -193,10 +257,48 @@ func EnvironmentConfig() Config {
 		DockerEndpoint: dockerEndpoint,
 		ReservedPorts:  reservedPorts,
 		DataDir:        dataDir,
+		HostDataDir:    hostDataDir,
 		Checkpoint:     checkpoint,
+		StateKeyFile:   stateKeyFile,
+		StateBackend:   stateBackend,
 		EngineAuthType: engineAuthType,
 		EngineAuthData: []byte(engineAuthData),
+
+		DockerConcurrency:     dockerConcurrency,
+		DockerPullConcurrency: dockerPullConcurrency,
+		TaskStopTimeout:       taskStopTimeout,
+		EmptyVolumeMode:       emptyVolumeMode,
+		EmptyVolumeSizeLimit:  emptyVolumeSizeLimit,
+		PauseContainerImage:   pauseContainerImage,
+		SecretsProvider:       secretsProvider,
+		SecretsPath:           secretsPath,
+		SecretsKeyFile:        secretsKeyFile,
+		SecretsEndpoint:       secretsEndpoint,
+
+		Standalone:              standalone,
+		StandaloneTaskDir:       standaloneTaskDir,
+		StandaloneListenAddress: standaloneListenAddress,
+		StandaloneSink:          standaloneSink,
+
+		HTTPProxy: httpProxy,
+		NoProxy:   noProxy,
+
+		TLSCAFile:       tlsCAFile,
+		TLSMinVersion:   tlsMinVersion,
+		TLSCipherPolicy: tlsCipherPolicy,
+		TLSPinnedKeys:   tlsPinnedKeys,
+	}
+}
+
+// firstEnv returns the value of the first of the given environment variables
+// that is set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
 	}
+	return ""
 }
 
 func EC2MetadataConfig() Config {
@@ -227,7 +329,7 @@ func NewConfig() (*Config, error) {
 
 	config.Merge(FileConfig())
 
-	if config.AWSRegion == "" || config.APIEndpoint == "" {
+	if (config.AWSRegion == "" || config.APIEndpoint == "") && !config.Standalone {
 		// Get it from metadata only if we need to (network io)
 		config.Merge(EC2MetadataConfig())
 	}
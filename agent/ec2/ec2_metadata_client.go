@@ -14,6 +14,7 @@
 package ec2
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -68,10 +69,17 @@ type EC2MetadataClient struct {
 }
 
 func NewEC2MetadataClient() *EC2MetadataClient {
+	return NewEC2MetadataClientWithTLS(nil)
+}
+
+// NewEC2MetadataClientWithTLS returns a client which uses tlsConfig for any
+// https connection it makes. A nil tlsConfig is Go's default.
+func NewEC2MetadataClientWithTLS(tlsConfig *tls.Config) *EC2MetadataClient {
 	var lowTimeoutDial http.RoundTripper = &http.Transport{
 		Dial: (&net.Dialer{
 			Timeout: EC2_METADATA_REQUEST_TIMEOUT,
 		}).Dial,
+		TLSClientConfig: tlsConfig,
 	}
 
 	httpClient := http.Client{Transport: lowTimeoutDial}
@@ -80,6 +80,25 @@ func SetSecurityToken(securityToken string) Option {
 }
 
 func (c AwsJson) RoundTrip(r *cod.Request, rw io.ReadWriter) error {
+	request, err := c.EncodeRequest(r)
+	if err != nil {
+		return err
+	}
+	err = request.Write(rw)
+	if err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(rw), request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return c.DecodeResponse(r, resp)
+}
+
+//EncodeRequest returns the signed HTTP request of r. Its URL has no scheme;
+//the connection it is sent over decides it.
+func (c AwsJson) EncodeRequest(r *cod.Request) (*http.Request, error) {
 	path := c.Path
 	if path == "" {
 		path = "/"
@@ -87,13 +106,13 @@ func (c AwsJson) RoundTrip(r *cod.Request, rw io.ReadWriter) error {
 
 	b, err := encoding.Marshal(r.Input)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	body := bytes.NewBuffer(b)
 	request, err := http.NewRequest("POST", path, body)
 	if err != nil {
 		//TODO: Panic here? Suggests malformed input to NewRequest
-		return err
+		return nil, err
 	}
 
 	if c.Host != "" {
@@ -119,16 +138,12 @@ func (c AwsJson) RoundTrip(r *cod.Request, rw io.ReadWriter) error {
 	} else { // v4 signing will add this header
 		request.Header.Set("X-Amz-Date", time.Now().UTC().Format(time.RFC822))
 	}
+	return request, nil
+}
 
-	err = request.Write(rw)
-	if err != nil {
-		return err
-	}
-	resp, err := http.ReadResponse(bufio.NewReader(rw), request)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+//DecodeResponse reads r's Output from resp, or returns the body of a response
+//other than 200 OK as an error
+func (c AwsJson) DecodeResponse(r *cod.Request, resp *http.Response) error {
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
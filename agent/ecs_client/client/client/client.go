@@ -14,13 +14,36 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
 	"github.com/aws/amazon-ecs-agent/agent/ecs_client/client/dialer"
 	"github.com/aws/amazon-ecs-agent/agent/ecs_client/codec/codec"
-	"io"
 )
 
 const DEFAULT_POOL_SIZE = 5
 
+const (
+	// DEFAULT_IDLE_TIMEOUT is how long an idle connection is kept for reuse.
+	// It is shorter than the 60 seconds load balancers commonly close idle
+	// connections after, so that connections are seldom found closed.
+	DEFAULT_IDLE_TIMEOUT = 30 * time.Second
+	// DEFAULT_REQUEST_TIMEOUT bounds each attempt of a call, from getting a
+	// connection to reading the whole response
+	DEFAULT_REQUEST_TIMEOUT = 30 * time.Second
+)
+
+// idempotentPrefixes are the prefixes of the operations which only read, and
+// so may be retried when the connection they were sent on turns out to be
+// closed
+var idempotentPrefixes = []string{"Describe", "Discover", "Get", "List"}
+
 type Client interface {
 	Call(operation string, input interface{}, output interface{}) error
 }
@@ -33,20 +56,91 @@ type ServiceRef struct {
 	ServiceName string
 }
 
+// Option configures a Client made by NewClient
+type Option func(*client)
+
+// SetPoolSize sets how many idle connections are kept for reuse
+func SetPoolSize(size int) Option {
+	return func(c *client) {
+		c.poolSize = size
+	}
+}
+
+// SetIdleTimeout sets how long an idle connection is kept for reuse
+func SetIdleTimeout(timeout time.Duration) Option {
+	return func(c *client) {
+		c.idleTimeout = timeout
+	}
+}
+
+// SetRequestTimeout sets how long each attempt of a call may take
+func SetRequestTimeout(timeout time.Duration) Option {
+	return func(c *client) {
+		c.requestTimeout = timeout
+	}
+}
+
 type client struct {
 	serviceRef codec.ShapeRef
 	connPool   chan io.ReadWriter
 	dialer     dialer.Dialer
 	codec      codec.Codec
+
+	poolSize       int
+	idleTimeout    time.Duration
+	requestTimeout time.Duration
+	// httpClient sends the requests of an HTTPCodec over persistent
+	// connections from dialer. Other codecs use connPool.
+	httpClient *http.Client
+}
+
+func NewClient(serviceName string, dialer dialer.Dialer, c codec.Codec, options ...Option) Client {
+	cl := &client{
+		serviceRef:     codec.ShapeRef{serviceName},
+		dialer:         dialer,
+		codec:          c,
+		poolSize:       DEFAULT_POOL_SIZE,
+		idleTimeout:    DEFAULT_IDLE_TIMEOUT,
+		requestTimeout: DEFAULT_REQUEST_TIMEOUT,
+	}
+	for _, option := range options {
+		option(cl)
+	}
+	cl.connPool = make(chan io.ReadWriter, cl.poolSize)
+	if _, ok := c.(codec.HTTPCodec); ok {
+		cl.httpClient = &http.Client{
+			Transport: &http.Transport{
+				// The dialer connects to the one endpoint of the service, and
+				// handles any TLS and proxy itself
+				DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+					return cl.dial()
+				},
+				MaxIdleConns:        cl.poolSize,
+				MaxIdleConnsPerHost: cl.poolSize,
+				IdleConnTimeout:     cl.idleTimeout,
+			},
+			// Responses are decoded, not followed
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return cl
 }
 
-func NewClient(serviceName string, dialer dialer.Dialer, c codec.Codec) Client {
-	return &client{
-		codec.ShapeRef{serviceName},
-		make(chan io.ReadWriter, DEFAULT_POOL_SIZE),
-		dialer,
-		c,
+func (c *client) dial() (net.Conn, error) {
+	rw, err := c.dialer.Dial()
+	if err != nil {
+		return nil, err
 	}
+	conn, ok := rw.(net.Conn)
+	if !ok {
+		if closer, ok := rw.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, errors.New("Dialer did not return a net.Conn")
+	}
+	return conn, nil
 }
 
 //consume a connection from the pool
@@ -76,17 +170,73 @@ func (c *client) returnConn(rw io.ReadWriter) {
 }
 
 func (c *client) Call(operation string, in interface{}, out interface{}) error {
-	conn, err := c.consumeConn()
-	if err != nil {
-		return err
-	}
-	defer c.returnConn(conn)
 	req := &codec.Request{
 		Service:   c.serviceRef,
 		Operation: codec.ShapeRef{operation},
 		Input:     in,
 		Output:    out,
 	}
+	if httpCodec, ok := c.codec.(codec.HTTPCodec); ok {
+		return c.callHTTP(httpCodec, req)
+	}
+
+	conn, err := c.consumeConn()
+	if err != nil {
+		return err
+	}
+	defer c.returnConn(conn)
 	err = c.codec.RoundTrip(req, conn)
 	return err
 }
+
+// callHTTP sends req with the http client. An idempotent call which fails on
+// a reused connection, before any response, is retried; the connection was
+// most likely closed by the server while idle. The transport discards the
+// connection, so each retry gets another, and at most poolSize retries are
+// needed before a new connection is dialed.
+func (c *client) callHTTP(httpCodec codec.HTTPCodec, req *codec.Request) error {
+	idempotent := isIdempotent(req.Operation.ShapeName)
+	for attempt := 0; ; attempt++ {
+		stale, err := c.attempt(httpCodec, req)
+		if !stale || !idempotent || attempt >= c.poolSize {
+			return err
+		}
+	}
+}
+
+// attempt sends req once. It returns whether the attempt failed, without a
+// response, on a reused connection.
+func (c *client) attempt(httpCodec codec.HTTPCodec, req *codec.Request) (bool, error) {
+	request, err := httpCodec.EncodeRequest(req)
+	if err != nil {
+		return false, err
+	}
+	// The dialer decides whether the connection is TLS
+	request.URL.Scheme = "http"
+
+	reused := false
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+	}
+	ctx, cancel := context.WithTimeout(httptrace.WithClientTrace(context.Background(), trace), c.requestTimeout)
+	defer cancel()
+
+	resp, err := c.httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		// A timeout is not a sign of a stale connection
+		return reused && ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	return false, httpCodec.DecodeResponse(req, resp)
+}
+
+func isIdempotent(operation string) bool {
+	for _, prefix := range idempotentPrefixes {
+		if strings.HasPrefix(operation, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/awsjson/codec"
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/client/dialer"
+)
+
+type output struct {
+	Value *string `awsjson:"value"`
+}
+
+func tcpDialer(t *testing.T, address string) dialer.Dialer {
+	host, port, _ := net.SplitHostPort(address)
+	parsedPort, _ := strconv.Atoi(port)
+	d, err := dialer.TCP(host, uint16(parsedPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestCallReusesConnections(t *testing.T) {
+	var lock sync.Mutex
+	connections := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "Service.DescribeThings" {
+			t.Error("Unexpected target", r.Header.Get("X-Amz-Target"))
+		}
+		io.WriteString(w, `{"value":"ok"}`)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			connections++
+			lock.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	c := NewClient("Service", tcpDialer(t, server.Listener.Addr().String()), codec.AwsJson{Host: "example.com"})
+	for i := 0; i < 3; i++ {
+		var out *output
+		if err := c.Call("DescribeThings", &output{}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out == nil || out.Value == nil || *out.Value != "ok" {
+			t.Error("Unexpected output", out)
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if connections != 1 {
+		t.Error("Expected one connection to be reused, got", connections)
+	}
+}
+
+func TestCallTimesOut(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	c := NewClient("Service", tcpDialer(t, server.Listener.Addr().String()), codec.AwsJson{Host: "example.com"}, SetRequestTimeout(50*time.Millisecond))
+	start := time.Now()
+	if err := c.Call("DescribeThings", &output{}, new(*output)); err == nil {
+		t.Error("Expected a call without a response to time out")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Call took too long to time out")
+	}
+}
+
+// startClosingServer starts a server which answers the first request of each
+// connection and closes the connection after reading the second, as a server
+// closing an idle connection just as a request is sent would
+func startClosingServer(t *testing.T) (net.Listener, *int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answered := new(int)
+	var lock sync.Mutex
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for i := 0; i < 2; i++ {
+					request, err := http.ReadRequest(reader)
+					if err != nil || i == 1 {
+						return
+					}
+					io.Copy(io.Discard, request.Body)
+					lock.Lock()
+					*answered++
+					lock.Unlock()
+					io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}")
+				}
+			}(conn)
+		}
+	}()
+	return listener, answered
+}
+
+func TestCallRetriesIdempotentCallsOnStaleConnections(t *testing.T) {
+	listener, answered := startClosingServer(t)
+	defer listener.Close()
+
+	c := NewClient("Service", tcpDialer(t, listener.Addr().String()), codec.AwsJson{Host: "example.com"})
+	if err := c.Call("ListThings", &output{}, new(*output)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Call("ListThings", &output{}, new(*output)); err != nil {
+		t.Error("Expected an idempotent call to be retried on a new connection", err)
+	}
+	if *answered != 2 {
+		t.Error("Expected two calls to be answered, got", *answered)
+	}
+}
+
+func TestCallDoesNotRetryOtherCalls(t *testing.T) {
+	listener, _ := startClosingServer(t)
+	defer listener.Close()
+
+	c := NewClient("Service", tcpDialer(t, listener.Addr().String()), codec.AwsJson{Host: "example.com"})
+	if err := c.Call("SubmitThing", &output{}, new(*output)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Call("SubmitThing", &output{}, new(*output)); err == nil {
+		t.Error("Expected a call which is not idempotent not to be retried")
+	}
+}
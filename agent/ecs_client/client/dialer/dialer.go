@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 
 	"crypto/tls"
 )
@@ -46,8 +47,15 @@ func TCP(addr string, port uint16) (Dialer, error) {
 
 //TLS creates a Dialer to the address and port using a TLS (SSL) connection
 func TLS(addr string, port uint16, config *tls.Config) (Dialer, error) {
-	address := fmt.Sprintf("%s:%d", addr, port)
+	return ProxiedTLS(addr, port, config, nil)
+}
+
+//ProxiedTLS creates a Dialer to the address and port using a TLS (SSL)
+//connection tunneled through proxy, unless the address bypasses it. A nil
+//proxy connects directly.
+func ProxiedTLS(addr string, port uint16, config *tls.Config, proxy *Proxy) (Dialer, error) {
+	address := net.JoinHostPort(addr, strconv.Itoa(int(port)))
 	return DialerFunc(func() (io.ReadWriter, error) {
-		return tls.Dial("tcp", address, config)
+		return DialTLS(&net.Dialer{}, address, config, proxy)
 	}), nil
 }
@@ -0,0 +1,168 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package dialer
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// startConnectProxy starts a proxy that tunnels CONNECT requests carrying
+// the given Proxy-Authorization, if any, and records the addresses tunneled
+// to
+func startConnectProxy(t *testing.T, authorization string) (net.Listener, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunneled := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				request, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || request.Method != "CONNECT" {
+					return
+				}
+				if authorization != "" && request.Header.Get("Proxy-Authorization") != authorization {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", request.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				tunneled <- request.Host
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}(conn)
+		}
+	}()
+	return listener, tunneled
+}
+
+func getThrough(t *testing.T, server *httptest.Server, proxy *Proxy) (string, error) {
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	parsedPort, _ := strconv.Atoi(port)
+	d, _ := ProxiedTLS(host, uint16(parsedPort), &tls.Config{InsecureSkipVerify: true}, proxy)
+	conn, err := d.Dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.(net.Conn).Close()
+	io.WriteString(conn, "GET / HTTP/1.0\r\nHost: "+host+"\r\n\r\n")
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	return string(body), nil
+}
+
+func TestProxiedTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+	listener, tunneled := startConnectProxy(t, "Basic dXNlcjpwYXNz")
+	defer listener.Close()
+
+	proxy, err := ParseProxy("http://user:pass@"+listener.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := getThrough(t, server, proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "hello" {
+		t.Error("Unexpected response through the proxy", body)
+	}
+	select {
+	case address := <-tunneled:
+		if address != server.Listener.Addr().String() {
+			t.Error("Tunneled to the wrong address", address)
+		}
+	default:
+		t.Error("Expected the connection to be tunneled")
+	}
+
+	// Bypassed, the connection is direct
+	proxy.NoProxy = []string{"127.0.0.1"}
+	if _, err := getThrough(t, server, proxy); err != nil {
+		t.Fatal(err)
+	}
+	if len(tunneled) != 0 {
+		t.Error("Expected a bypassed connection not to be tunneled")
+	}
+
+	wrongAuth, _ := ParseProxy("http://user:wrong@"+listener.Addr().String(), "")
+	if _, err := getThrough(t, server, wrongAuth); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Error("Expected the proxy's refusal to be returned, got", err)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	if proxy, err := ParseProxy("", "host"); proxy != nil || err != nil {
+		t.Error("Expected no proxy", proxy, err)
+	}
+	proxy, err := ParseProxy("proxy.example.com", " a.example.com, .internal ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy.URL.Host != "proxy.example.com:80" || len(proxy.NoProxy) != 2 {
+		t.Error("Unexpected proxy", proxy.URL, proxy.NoProxy)
+	}
+	if _, err := ParseProxy("socks5://proxy.example.com:1080", ""); err == nil {
+		t.Error("Expected an unsupported scheme to be rejected")
+	}
+}
+
+func TestBypass(t *testing.T) {
+	proxy := &Proxy{NoProxy: []string{"169.254.169.254", ".internal", "docker:2375"}}
+	for host, bypass := range map[string]bool{
+		"169.254.169.254":                 true,
+		"169.254.169.254:80":              true,
+		"internal":                        true,
+		"ecs.internal:443":                true,
+		"docker:2376":                     true,
+		"ecs.us-west-2.amazonaws.com:443": false,
+		"notinternal":                     false,
+	} {
+		if proxy.Bypass(host) != bypass {
+			t.Errorf("Expected bypass of %s to be %v", host, bypass)
+		}
+	}
+	if !(*Proxy)(nil).Bypass("anything") {
+		t.Error("Expected no proxy to bypass every host")
+	}
+	if !(&Proxy{NoProxy: []string{"*"}}).Bypass("anything") {
+		t.Error("Expected * to bypass every host")
+	}
+}
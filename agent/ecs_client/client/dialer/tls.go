@@ -0,0 +1,178 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package dialer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// Cipher policies
+const (
+	// CipherPolicyDefault uses Go's default cipher suites
+	CipherPolicyDefault = "default"
+	// CipherPolicyModern allows only forward secret AEAD cipher suites
+	CipherPolicyModern = "modern"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// TLSPolicy is how the certificates and protocol of TLS connections are
+// checked. The zero value is Go's defaults, verifying against the system's
+// CAs.
+type TLSPolicy struct {
+	// CAFile is a PEM bundle of the CAs that are trusted instead of the
+	// system's
+	CAFile string
+	// MinVersion is the minimum TLS version, such as tls.VersionTLS12
+	MinVersion uint16
+	// CipherSuites are the cipher suites allowed for TLS 1.2 and earlier;
+	// TLS 1.3's are not configurable. If it is empty, Go's defaults are used.
+	CipherSuites []uint16
+	// PinnedKeys are SHA-256 hashes of subject public key infos. If any are
+	// set, a connection is refused unless a certificate in its chain has one
+	// of them.
+	PinnedKeys [][]byte
+}
+
+// ParseTLSVersion parses a TLS version such as "1.2". An empty version is
+// returned as 0, Go's default.
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	parsed, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, errors.New("Unsupported TLS version " + version + "; expected one of 1.0, 1.1, 1.2 or 1.3")
+	}
+	return parsed, nil
+}
+
+// ParseCipherPolicy parses a cipher policy: CipherPolicyDefault,
+// CipherPolicyModern, or a comma separated list of cipher suite names such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". An empty or default policy is
+// returned as nil.
+func ParseCipherPolicy(policy string) ([]uint16, error) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", CipherPolicyDefault:
+		return nil, nil
+	case CipherPolicyModern:
+		return modernCipherSuites, nil
+	}
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	var parsed []uint16
+	for _, name := range strings.Split(policy, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id, ok := suites[name]
+		if !ok {
+			return nil, errors.New("Unsupported or insecure cipher suite " + name)
+		}
+		parsed = append(parsed, id)
+	}
+	return parsed, nil
+}
+
+// ParsePinnedKeys parses a comma separated list of base64 SHA-256 hashes of
+// subject public key infos, each optionally prefixed with "sha256//"
+func ParsePinnedKeys(pins string) ([][]byte, error) {
+	var parsed [][]byte
+	for _, pin := range strings.Split(pins, ",") {
+		if pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256//"); pin == "" {
+			continue
+		}
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, errors.New("Invalid pinned key " + pin + "; expected a base64 SHA-256 hash")
+		}
+		parsed = append(parsed, hash)
+	}
+	return parsed, nil
+}
+
+// Config returns a tls.Config which applies the policy. If
+// insecureSkipVerify is set the certificate chain is not verified, but
+// pinned keys are still required.
+func (policy *TLSPolicy) Config(insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+		MinVersion:         policy.MinVersion,
+		CipherSuites:       policy.CipherSuites,
+	}
+	if policy.CAFile != "" {
+		pem, err := ioutil.ReadFile(policy.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in CA file " + policy.CAFile)
+		}
+	}
+	if len(policy.PinnedKeys) > 0 {
+		pinnedKeys := policy.PinnedKeys
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPinnedKeys(state, pinnedKeys)
+		}
+	}
+	return config, nil
+}
+
+// verifyPinnedKeys checks that a certificate of the connection's verified
+// chains, or of the certificates the peer sent if they were not verified,
+// has one of the pinned keys
+func verifyPinnedKeys(state tls.ConnectionState, pinnedKeys [][]byte) error {
+	// Only verified chains count when there are any; the peer may send
+	// extra certificates that are not part of them
+	certificates := state.PeerCertificates
+	if len(state.VerifiedChains) > 0 {
+		certificates = nil
+		for _, chain := range state.VerifiedChains {
+			certificates = append(certificates, chain...)
+		}
+	}
+	for _, certificate := range certificates {
+		hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+		for _, pinnedKey := range pinnedKeys {
+			if bytes.Equal(hash[:], pinnedKey) {
+				return nil
+			}
+		}
+	}
+	return errors.New("No certificate of " + state.ServerName + " matches a pinned key")
+}
@@ -0,0 +1,128 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package dialer
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func dialWithPolicy(server *httptest.Server, policy *TLSPolicy, insecureSkipVerify bool) error {
+	config, err := policy.Config(insecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestTLSPolicyCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if err := dialWithPolicy(server, &TLSPolicy{}, false); err == nil {
+		t.Error("Expected the test server's certificate not to be trusted by default")
+	}
+
+	dir, _ := ioutil.TempDir("", "ecs-agent-tls")
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := dialWithPolicy(server, &TLSPolicy{CAFile: caFile}, false); err != nil {
+		t.Error("Expected the CA file to be trusted", err)
+	}
+
+	ioutil.WriteFile(caFile, []byte("not a certificate"), 0600)
+	if _, err := (&TLSPolicy{CAFile: caFile}).Config(false); err == nil {
+		t.Error("Expected a CA file without certificates to be an error")
+	}
+}
+
+func TestTLSPolicyPinnedKeys(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	hash := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	otherHash := sha256.Sum256([]byte("another key"))
+
+	if err := dialWithPolicy(server, &TLSPolicy{PinnedKeys: [][]byte{otherHash[:], hash[:]}}, true); err != nil {
+		t.Error("Expected a pinned key to be accepted", err)
+	}
+	if err := dialWithPolicy(server, &TLSPolicy{PinnedKeys: [][]byte{otherHash[:]}}, true); err == nil {
+		t.Error("Expected a key which is not pinned to be refused, even when not verifying")
+	}
+}
+
+func TestTLSPolicyMinVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	if err := dialWithPolicy(server, &TLSPolicy{MinVersion: tls.VersionTLS12}, true); err != nil {
+		t.Error("Expected TLS 1.2 to be accepted", err)
+	}
+	if err := dialWithPolicy(server, &TLSPolicy{MinVersion: tls.VersionTLS13}, true); err == nil {
+		t.Error("Expected a server without TLS 1.3 to be refused")
+	}
+}
+
+func TestParseTLSPolicy(t *testing.T) {
+	if version, err := ParseTLSVersion("1.2"); err != nil || version != tls.VersionTLS12 {
+		t.Error("Unexpected version", version, err)
+	}
+	if version, err := ParseTLSVersion(""); err != nil || version != 0 {
+		t.Error("Expected the default version", version, err)
+	}
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Error("Expected an unknown version to be an error")
+	}
+
+	if suites, err := ParseCipherPolicy("default"); err != nil || suites != nil {
+		t.Error("Expected the default cipher suites", suites, err)
+	}
+	if suites, err := ParseCipherPolicy("modern"); err != nil || len(suites) == 0 {
+		t.Error("Expected the modern cipher suites", suites, err)
+	}
+	suites, err := ParseCipherPolicy("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	if err != nil || len(suites) != 2 || suites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Error("Unexpected cipher suites", suites, err)
+	}
+	if _, err := ParseCipherPolicy("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Error("Expected an insecure cipher suite to be an error")
+	}
+
+	hash := sha256.Sum256([]byte("key"))
+	encoded := base64.StdEncoding.EncodeToString(hash[:])
+	pins, err := ParsePinnedKeys(encoded + ", sha256//" + encoded)
+	if err != nil || len(pins) != 2 || string(pins[1]) != string(hash[:]) {
+		t.Error("Unexpected pinned keys", pins, err)
+	}
+	if _, err := ParsePinnedKeys("c2hvcnQ="); err == nil {
+		t.Error("Expected a pin that is not a SHA-256 hash to be an error")
+	}
+}
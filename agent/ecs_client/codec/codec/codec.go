@@ -16,12 +16,25 @@ package codec
 
 import (
 	"io"
+	"net/http"
 )
 
 //A Codec defines how to read and write data from the wire
 type Codec interface {
 	RoundTrip(*Request, io.ReadWriter) error
 }
+
+//An HTTPCodec is a Codec whose requests and responses are HTTP messages, so
+//that they may be sent by an http.Client rather than over a raw connection
+type HTTPCodec interface {
+	Codec
+	//EncodeRequest returns the HTTP request of a Request. It is called again
+	//for each attempt of the Request.
+	EncodeRequest(*Request) (*http.Request, error)
+	//DecodeResponse reads the Request's Output, or its error, from the HTTP
+	//response
+	DecodeResponse(*Request, *http.Response) error
+}
 type ShapeRef struct {
 	ShapeName string
 }
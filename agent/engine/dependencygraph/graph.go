@@ -14,6 +14,8 @@
 package dependencygraph
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/aws/amazon-ecs-agent/agent/api"
@@ -23,14 +25,26 @@ import (
 var log = logger.ForModule("dependencygraph")
 
 // Because a container may depend on another container being created
-// (volumes-from) or running (links) it makes sense to abstract it out
-// to each container having dependencies on another container being in any
-// perticular state set. For now, these are resolved here and support only
-// volume/link (created/run)
+// (volumes-from), running (links), or meeting an explicit dependsOn condition
+// it makes sense to abstract it out to each container having dependencies on
+// another container being in any perticular state set. For now, these are
+// resolved here and support volume/link (created/run) and the dependsOn
+// conditions in the api package.
 
 // ValidDependencies takes a task and verifies that it is possible to allow all
-// containers within it to reach the desired status by proceeding in some order
-func ValidDependencies(task *api.Task) bool {
+// containers within it to reach the desired status by proceeding in some order.
+// If it is not, the returned error describes why.
+func ValidDependencies(task *api.Task) error {
+	nameMap := make(map[string]*api.Container)
+	for _, cont := range task.Containers {
+		nameMap[cont.Name] = cont
+	}
+	for _, cont := range task.Containers {
+		if err := validateReferences(cont, nameMap); err != nil {
+			return err
+		}
+	}
+
 	unresolved := make([]*api.Container, len(task.Containers))
 	resolved := make([]*api.Container, 0, len(task.Containers))
 
@@ -47,11 +61,117 @@ OuterLoop:
 				continue OuterLoop
 			}
 		}
-		log.Warn("Could not resolve some containers", "task", task, "unresolved", unresolved)
-		return false
+		if cycle := findCycle(unresolved); cycle != nil {
+			return errors.New("Container dependency cycle: " + strings.Join(cycle, " -> "))
+		}
+		names := make([]string, len(unresolved))
+		for i, cont := range unresolved {
+			names[i] = cont.Name
+		}
+		return errors.New("Dependencies of containers can not reach the needed status: " + strings.Join(names, ", "))
 	}
 
-	return true
+	return nil
+}
+
+// validateReferences checks that every container the target depends on exists
+// and that every dependsOn condition could be met
+func validateReferences(target *api.Container, nameMap map[string]*api.Container) error {
+	for _, link := range linksToContainerNames(target.Links) {
+		if _, ok := nameMap[link]; !ok {
+			return fmt.Errorf("Container %s links to %s, which is not in the task", target.Name, link)
+		}
+	}
+	for _, volume := range target.VolumesFrom {
+		if _, ok := nameMap[volume.SourceContainer]; !ok {
+			return fmt.Errorf("Container %s uses volumes from %s, which is not in the task", target.Name, volume.SourceContainer)
+		}
+	}
+	for _, dependency := range target.DependsOn {
+		if dependency.ContainerName == target.Name {
+			return fmt.Errorf("Container %s can not depend on itself", target.Name)
+		}
+		cont, ok := nameMap[dependency.ContainerName]
+		if !ok {
+			return fmt.Errorf("Container %s depends on %s, which is not in the task", target.Name, dependency.ContainerName)
+		}
+		switch dependency.Condition {
+		case api.DependencyStart:
+		case api.DependencyComplete, api.DependencySuccess:
+			if cont.Essential {
+				return fmt.Errorf("Container %s waits for %s to exit, but %s is essential; its exit would stop the task", target.Name, cont.Name, cont.Name)
+			}
+		case api.DependencyHealthy:
+			return fmt.Errorf("Container %s waits for %s to be HEALTHY, which is not supported: docker does not report container health to the agent", target.Name, cont.Name)
+		default:
+			return fmt.Errorf("Container %s has an unknown condition %q for %s", target.Name, dependency.Condition, cont.Name)
+		}
+	}
+	return nil
+}
+
+// dependencyNames returns the names of every container the target depends on
+func dependencyNames(target *api.Container) []string {
+	names := linksToContainerNames(target.Links)
+	for _, volume := range target.VolumesFrom {
+		names = append(names, volume.SourceContainer)
+	}
+	for _, dependency := range target.DependsOn {
+		names = append(names, dependency.ContainerName)
+	}
+	return append(names, target.RunDependencies...)
+}
+
+// findCycle returns the names of containers forming a dependency cycle among
+// the given containers, with the first name repeated at the end, or nil if
+// there is no cycle
+func findCycle(containers []*api.Container) []string {
+	nameMap := make(map[string]*api.Container)
+	for _, cont := range containers {
+		nameMap[cont.Name] = cont
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	path := []string{}
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			for i, onPath := range path {
+				if onPath == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dependency := range dependencyNames(nameMap[name]) {
+			if _, ok := nameMap[dependency]; !ok {
+				continue
+			}
+			if cycle := visit(dependency); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, cont := range containers {
+		if cycle := visit(cont.Name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
 }
 
 func linksToContainerNames(links []string) []string {
@@ -82,7 +202,8 @@ func dependenciesCanBeResolved(target *api.Container, by []*api.Container) bool
 	}
 
 	return verifyStatusResolveable(target, nameMap, neededVolumeContainers, volumeCanResolve) &&
-		verifyStatusResolveable(target, nameMap, linksToContainerNames(target.Links), linkCanResolve)
+		verifyStatusResolveable(target, nameMap, linksToContainerNames(target.Links), linkCanResolve) &&
+		verifyDependsOn(target, nameMap, dependsOnCanResolve)
 }
 
 // DependenciesAreResolved validates that the `target` container can be started
@@ -100,7 +221,100 @@ func DependenciesAreResolved(target *api.Container, by []*api.Container) bool {
 
 	return verifyStatusResolveable(target, nameMap, neededVolumeContainers, volumeIsResolved) &&
 		verifyStatusResolveable(target, nameMap, linksToContainerNames(target.Links), linkIsResolved) &&
-		verifyStatusResolveable(target, nameMap, target.RunDependencies, onRunIsResolved)
+		verifyStatusResolveable(target, nameMap, target.RunDependencies, onRunIsResolved) &&
+		verifyDependsOn(target, nameMap, dependsOnIsResolved)
+}
+
+// DependentsAreStopped returns true if every container in `by` that depends on
+// the target and is itself stopping is known to have stopped, such that the
+// target can be stopped without pulling anything out from under them.
+// Dependents that are not stopping are not waited for.
+func DependentsAreStopped(target *api.Container, by []*api.Container) bool {
+	for _, cont := range by {
+		if cont == target || cont.KnownTerminal() || !cont.DesiredTerminal() {
+			continue
+		}
+		for _, dependency := range dependencyNames(cont) {
+			if dependency == target.Name {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FailedDependency returns an error if one of the target's dependsOn
+// conditions can no longer be met given the current known state of the
+// containers in `by`; for example, if a container that had to succeed exited
+// with a non-zero code.
+func FailedDependency(target *api.Container, by []*api.Container) error {
+	nameMap := make(map[string]*api.Container)
+	for _, cont := range by {
+		nameMap[cont.Name] = cont
+	}
+	for _, dependency := range target.DependsOn {
+		cont, ok := nameMap[dependency.ContainerName]
+		if !ok {
+			return fmt.Errorf("Dependency %s of container %s does not exist", dependency.ContainerName, target.Name)
+		}
+		if !cont.KnownStatus.Terminal() {
+			continue
+		}
+		if cont.KnownExitCode == nil {
+			return fmt.Errorf("Dependency %s of container %s stopped without running", cont.Name, target.Name)
+		}
+		if dependency.Condition == api.DependencySuccess && *cont.KnownExitCode != 0 {
+			return fmt.Errorf("Dependency %s of container %s exited with code %d", cont.Name, target.Name, *cont.KnownExitCode)
+		}
+	}
+	return nil
+}
+
+// verifyDependsOn validates that each of the target's dependsOn conditions is
+// met according to `meets`.
+func verifyDependsOn(target *api.Container, existingContainers map[string]*api.Container, meets func(*api.Container, api.DependencyCondition) bool) bool {
+	targetGoal := target.DesiredStatus
+	if targetGoal != api.ContainerRunning && targetGoal != api.ContainerCreated {
+		// Stopping never waits on a dependency
+		return true
+	}
+
+	for _, dependency := range target.DependsOn {
+		cont, exists := existingContainers[dependency.ContainerName]
+		if !exists {
+			return false
+		}
+		if !meets(cont, dependency.Condition) {
+			return false
+		}
+	}
+	return true
+}
+
+// dependsOnCanResolve returns true if a container that is trying to reach its
+// desired status will, in doing so, meet the condition. A container can only
+// start or exit if it is going to run.
+func dependsOnCanResolve(dependency *api.Container, condition api.DependencyCondition) bool {
+	switch condition {
+	case api.DependencyStart, api.DependencyComplete, api.DependencySuccess:
+		return dependency.DesiredStatus == api.ContainerRunning
+	}
+	return false
+}
+
+// dependsOnIsResolved returns true if the container's known state meets the
+// condition
+func dependsOnIsResolved(dependency *api.Container, condition api.DependencyCondition) bool {
+	ran := dependency.KnownStatus.Terminal() && dependency.KnownExitCode != nil
+	switch condition {
+	case api.DependencyStart:
+		return dependency.KnownStatus == api.ContainerRunning || ran
+	case api.DependencyComplete:
+		return ran
+	case api.DependencySuccess:
+		return ran && *dependency.KnownExitCode == 0
+	}
+	return false
 }
 
 // verifyStatusResolveable validates that `target` can be resolved given that
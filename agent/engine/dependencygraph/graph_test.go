@@ -47,8 +47,8 @@ func createdContainer(name string, links, volumes []string) *api.Container {
 func TestValidDependencies(t *testing.T) {
 	// Empty task
 	task := &api.Task{}
-	resolveable := ValidDependencies(task)
-	if !resolveable {
+	err := ValidDependencies(task)
+	if err != nil {
 		t.Error("The zero dependency graph should resolve")
 	}
 
@@ -60,8 +60,8 @@ func TestValidDependencies(t *testing.T) {
 			},
 		},
 	}
-	resolveable = ValidDependencies(task)
-	if !resolveable {
+	err = ValidDependencies(task)
+	if err != nil {
 		t.Error("One container should resolve trivially")
 	}
 
@@ -79,8 +79,8 @@ func TestValidDependencies(t *testing.T) {
 		},
 	}
 
-	resolveable = ValidDependencies(task)
-	if !resolveable {
+	err = ValidDependencies(task)
+	if err != nil {
 		t.Error("The webserver group should resolve just fine")
 	}
 
@@ -91,8 +91,8 @@ func TestValidDependencies(t *testing.T) {
 			runningContainer("b", []string{"a"}, []string{}),
 		},
 	}
-	resolveable = ValidDependencies(task)
-	if resolveable {
+	err = ValidDependencies(task)
+	if err == nil {
 		t.Error("Cycle should not be resolveable")
 	}
 	// Unresolveable, reference doesn't exist
@@ -101,12 +101,141 @@ func TestValidDependencies(t *testing.T) {
 			runningContainer("php", []string{"db"}, []string{}),
 		},
 	}
-	resolveable = ValidDependencies(task)
-	if resolveable {
+	err = ValidDependencies(task)
+	if err == nil {
 		t.Error("Nonexistent reference shouldn't resolve")
 	}
 }
 
+func dependsOnContainer(name string, essential bool, dependencies ...api.DependsOn) *api.Container {
+	return &api.Container{
+		Name:          name,
+		Essential:     essential,
+		DependsOn:     dependencies,
+		DesiredStatus: api.ContainerRunning,
+	}
+}
+
+func dependsOn(name string, condition api.DependencyCondition) api.DependsOn {
+	return api.DependsOn{ContainerName: name, Condition: condition}
+}
+
+func TestValidDependsOn(t *testing.T) {
+	task := &api.Task{
+		Containers: []*api.Container{
+			dependsOnContainer("app", true, dependsOn("migrate", api.DependencySuccess), dependsOn("cache", api.DependencyStart)),
+			dependsOnContainer("migrate", false),
+			dependsOnContainer("cache", false),
+		},
+	}
+	if err := ValidDependencies(task); err != nil {
+		t.Error("Init containers should resolve: ", err)
+	}
+
+	for _, tc := range []struct {
+		containers []*api.Container
+		err        string
+	}{
+		{
+			[]*api.Container{
+				dependsOnContainer("a", true, dependsOn("b", api.DependencyStart)),
+				dependsOnContainer("b", false, dependsOn("c", api.DependencyStart)),
+				runningContainer("c", []string{"a"}, []string{}),
+			},
+			"Container dependency cycle: a -> b -> c -> a",
+		},
+		{
+			[]*api.Container{dependsOnContainer("a", true, dependsOn("a", api.DependencyStart))},
+			"Container a can not depend on itself",
+		},
+		{
+			[]*api.Container{dependsOnContainer("a", true, dependsOn("b", api.DependencyStart))},
+			"Container a depends on b, which is not in the task",
+		},
+		{
+			[]*api.Container{
+				dependsOnContainer("a", true, dependsOn("b", api.DependencyComplete)),
+				dependsOnContainer("b", true),
+			},
+			"Container a waits for b to exit, but b is essential; its exit would stop the task",
+		},
+		{
+			[]*api.Container{
+				dependsOnContainer("a", true, dependsOn("b", api.DependencyHealthy)),
+				dependsOnContainer("b", false),
+			},
+			"Container a waits for b to be HEALTHY, which is not supported: docker does not report container health to the agent",
+		},
+		{
+			[]*api.Container{
+				dependsOnContainer("a", true, dependsOn("b", "READY")),
+				dependsOnContainer("b", false),
+			},
+			`Container a has an unknown condition "READY" for b`,
+		},
+		{
+			[]*api.Container{
+				dependsOnContainer("a", true, dependsOn("b", "EVENTUALLY")),
+				dependsOnContainer("b", false),
+			},
+			`Container a has an unknown condition "EVENTUALLY" for b`,
+		},
+		{
+			[]*api.Container{
+				dependsOnContainer("a", true, dependsOn("b", api.DependencyStart)),
+				createdContainer("b", []string{}, []string{}),
+			},
+			"Dependencies of containers can not reach the needed status: a",
+		},
+	} {
+		err := ValidDependencies(&api.Task{Containers: tc.containers})
+		if err == nil {
+			t.Errorf("Expected error %q", tc.err)
+		} else if err.Error() != tc.err {
+			t.Errorf("Expected error %q, got %q", tc.err, err.Error())
+		}
+	}
+}
+
+func TestDependsOnIsResolved(t *testing.T) {
+	app := dependsOnContainer("app", true, dependsOn("migrate", api.DependencySuccess), dependsOn("cache", api.DependencyStart))
+	migrate := dependsOnContainer("migrate", false)
+	cache := dependsOnContainer("cache", false)
+	containers := []*api.Container{app, migrate, cache}
+
+	if DependenciesAreResolved(app, containers) {
+		t.Error("Nothing has run yet")
+	}
+	cache.KnownStatus = api.ContainerRunning
+	migrate.KnownStatus = api.ContainerRunning
+	if DependenciesAreResolved(app, containers) {
+		t.Error("migrate has not exited")
+	}
+	if err := FailedDependency(app, containers); err != nil {
+		t.Error("Nothing has failed yet: ", err)
+	}
+
+	exitCode := 0
+	migrate.KnownStatus = api.ContainerStopped
+	migrate.KnownExitCode = &exitCode
+	if !DependenciesAreResolved(app, containers) {
+		t.Error("migrate succeeded and cache is running")
+	}
+
+	exitCode = 1
+	if DependenciesAreResolved(app, containers) {
+		t.Error("migrate failed")
+	}
+	if err := FailedDependency(app, containers); err == nil {
+		t.Error("Expected migrate's failure to be reported")
+	}
+
+	migrate.KnownExitCode = nil
+	if err := FailedDependency(app, containers); err == nil {
+		t.Error("Expected a migrate that never ran to be reported")
+	}
+}
+
 func TestDependenciesAreResolved(t *testing.T) {
 	task := &api.Task{
 		Containers: []*api.Container{
@@ -201,3 +330,35 @@ func TestRunningependsOnDependencies(t *testing.T) {
 		t.Error("Dependencies should be resolved")
 	}
 }
+
+func TestDependentsAreStopped(t *testing.T) {
+	db := dependsOnContainer("db", false)
+	app := dependsOnContainer("app", true, dependsOn("db", api.DependencyStart))
+	web := runningContainer("web", []string{"app"}, []string{})
+	containers := []*api.Container{db, app, web}
+
+	for _, cont := range containers {
+		cont.KnownStatus = api.ContainerRunning
+		cont.DesiredStatus = api.ContainerStopped
+	}
+	if DependentsAreStopped(db, containers) {
+		t.Error("Db should wait for app to stop")
+	}
+	if DependentsAreStopped(app, containers) {
+		t.Error("App should wait for web to stop")
+	}
+	if !DependentsAreStopped(web, containers) {
+		t.Error("Nothing depends on web")
+	}
+
+	web.KnownStatus = api.ContainerStopped
+	if !DependentsAreStopped(app, containers) {
+		t.Error("App's dependents have stopped")
+	}
+
+	// Dependents that are not stopping are not waited for
+	app.DesiredStatus = api.ContainerRunning
+	if !DependentsAreStopped(db, containers) {
+		t.Error("Db should not wait for a dependent that is not stopping")
+	}
+}
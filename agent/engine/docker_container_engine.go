@@ -16,16 +16,20 @@ package engine
 import (
 	"archive/tar"
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/aws/amazon-ecs-agent/agent/api"
 	"github.com/aws/amazon-ecs-agent/agent/engine/dockerauth"
 	"github.com/aws/amazon-ecs-agent/agent/engine/emptyvolume"
 	"github.com/aws/amazon-ecs-agent/agent/utils"
+	"github.com/aws/amazon-ecs-agent/agent/utils/ttime"
 
 	dockerparsers "github.com/docker/docker/pkg/parsers"
 	dockerregistry "github.com/docker/docker/registry"
@@ -33,25 +37,48 @@ import (
 	docker "github.com/fsouza/go-dockerclient"
 )
 
+// Timeouts for each docker operation. An operation that does not complete in
+// time returns a DockerTimeoutError.
+const (
+	pullImageTimeout        = 2 * time.Hour
+	createContainerTimeout  = 4 * time.Minute
+	startContainerTimeout   = 1 * time.Minute
+	stopContainerTimeout    = time.Duration(DEFAULT_TIMEOUT_SECONDS)*time.Second + 30*time.Second
+	removeContainerTimeout  = 5 * time.Minute
+	inspectContainerTimeout = 30 * time.Second
+	createVolumeTimeout     = 2 * time.Minute
+	removeVolumeTimeout     = 2 * time.Minute
+)
+
 // Interface to make testing it easier
+// Every call that talks to the daemon takes a context; once the context is
+// done the call returns its error without waiting for docker.
 type DockerClient interface {
 	ContainerEvents() (<-chan DockerContainerChangeEvent, error)
 
-	PullImage(image string) error
-	CreateContainer(*docker.Config, string) (string, error)
-	StartContainer(string, *docker.HostConfig) error
-	StopContainer(string) error
-	RemoveContainer(string) error
-	GetContainerName(string) (string, error)
+	PullImage(context.Context, string) error
+	CreateContainer(context.Context, *docker.Config, string) (string, error)
+	StartContainer(context.Context, string, *docker.HostConfig) error
+	StopContainer(context.Context, string) error
+	RemoveContainer(context.Context, string) error
+	GetContainerName(context.Context, string) (string, error)
+
+	CreateVolume(ctx context.Context, name, driver string, driverOpts map[string]string) error
+	RemoveVolume(context.Context, string) error
 
-	InspectContainer(string) (*docker.Container, error)
-	DescribeContainer(string) (api.ContainerStatus, error)
+	InspectContainer(context.Context, string) (*docker.Container, error)
+	DescribeContainer(context.Context, string) (api.ContainerStatus, error)
 
 	client() (*docker.Client, error)
 }
 
 // Implements DockerClient
-type DockerGoClient struct{}
+type DockerGoClient struct {
+	// volumeClient makes the volume requests the docker client cannot, to the
+	// same endpoint and with the same TLS settings
+	volumeClient  *http.Client
+	volumeBaseURL string
+}
 
 // dockerClient is a singleton
 var dockerclient *docker.Client
@@ -75,6 +102,12 @@ func NewDockerGoClient() (*DockerGoClient, error) {
 		return dg, err
 	}
 
+	dg.volumeClient, dg.volumeBaseURL, err = newDockerVolumeClient(dockerEndpoint(), client.TLSConfig)
+	if err != nil {
+		log.Error("Unable to create a docker volume client!", "err", err)
+		return dg, err
+	}
+
 	// Even if we have a dockerclient, the daemon might not be running. Ping it
 	// to ensure it's up.
 	err = client.Ping()
@@ -82,7 +115,42 @@ func NewDockerGoClient() (*DockerGoClient, error) {
 	return dg, err
 }
 
-func (dg *DockerGoClient) PullImage(image string) error {
+// callWithTimeout calls f and waits for it to return, ctx to be done, or the
+// timeout to pass, whichever is first. If ctx is already done, f is not
+// called.
+//
+// The docker client cannot abandon a request that is in flight, so f keeps
+// running after callWithTimeout has returned, and what it asked of docker may
+// still happen. Creates, starts, stops and removes that time out therefore
+// inspect the container afterwards to settle what became of it; see
+// createContainerWithTimeout and reconcileTimeout.
+func callWithTimeout(ctx context.Context, operation string, timeout time.Duration, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ttime.After(timeout):
+		log.Warn("Docker call timed out", "operation", operation, "timeout", timeout)
+		return &DockerTimeoutError{Operation: operation, Duration: timeout}
+	}
+}
+
+func (dg *DockerGoClient) PullImage(ctx context.Context, image string) error {
+	return callWithTimeout(ctx, "pull", pullImageTimeout, func() error {
+		return dg.pullImage(image)
+	})
+}
+
+func (dg *DockerGoClient) pullImage(image string) error {
 	log.Info("Pulling image", "image", image)
 	client, err := dg.client()
 	if err != nil {
@@ -175,7 +243,76 @@ func (dg *DockerGoClient) createScratchImageIfNotExists() error {
 	return err
 }
 
-func (dg *DockerGoClient) CreateContainer(config *docker.Config, name string) (string, error) {
+func (dg *DockerGoClient) CreateContainer(ctx context.Context, config *docker.Config, name string) (string, error) {
+	return dg.createContainerWithTimeout(ctx, config, name, createContainerTimeout)
+}
+
+// createContainerWithTimeout creates a container, settling what happens to
+// it if the create outlives the call. After a timeout, a container docker
+// already created under the name is adopted; after a cancellation it is
+// removed. Either way, a container the abandoned create makes later is
+// removed, so that it is never left unrecorded.
+func (dg *DockerGoClient) createContainerWithTimeout(ctx context.Context, config *docker.Config, name string, timeout time.Duration) (string, error) {
+	// lock orders the abandoned create's cleanup after the decision below
+	var lock sync.Mutex
+	abandoned := false
+
+	var id string
+	err := callWithTimeout(ctx, "create", timeout, func() error {
+		createdId, err := dg.createContainer(config, name)
+		lock.Lock()
+		defer lock.Unlock()
+		if abandoned {
+			// Nothing waits on this call any more, so it needs no timeout
+			if removeErr := dg.removeContainerByName(name); removeErr != nil {
+				log.Warn("Unable to remove container of an abandoned create", "name", name, "err", removeErr)
+			}
+			return err
+		}
+		id = createdId
+		return err
+	})
+	if err == nil {
+		return id, nil
+	}
+	if _, timedOut := err.(*DockerTimeoutError); !timedOut && err != ctx.Err() {
+		return "", err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if _, timedOut := err.(*DockerTimeoutError); timedOut {
+		if existing, inspectErr := dg.InspectContainer(context.Background(), name); inspectErr == nil {
+			log.Info("Adopting container created after its create timed out", "name", name, "id", existing.ID)
+			return existing.ID, nil
+		}
+	} else {
+		removeErr := callWithTimeout(context.Background(), "remove", removeContainerTimeout, func() error {
+			return dg.removeContainerByName(name)
+		})
+		if removeErr != nil {
+			log.Warn("Unable to remove container of a cancelled create", "name", name, "err", removeErr)
+		}
+	}
+	abandoned = true
+	return "", err
+}
+
+// removeContainerByName removes the named container that an abandoned create
+// may have made. A container that does not exist is not an error.
+func (dg *DockerGoClient) removeContainerByName(name string) error {
+	client, err := dg.client()
+	if err != nil {
+		return err
+	}
+	err = client.RemoveContainer(docker.RemoveContainerOptions{ID: name, RemoveVolumes: true, Force: true})
+	if _, ok := err.(*docker.NoSuchContainer); ok {
+		return nil
+	}
+	return err
+}
+
+func (dg *DockerGoClient) createContainer(config *docker.Config, name string) (string, error) {
 	client, err := dg.client()
 	if err != nil {
 		return "", err
@@ -199,18 +336,18 @@ func (dg *DockerGoClient) CreateContainer(config *docker.Config, name string) (s
 	return dockerContainer.ID, nil
 }
 
-func (dg *DockerGoClient) StartContainer(id string, hostConfig *docker.HostConfig) error {
+func (dg *DockerGoClient) StartContainer(ctx context.Context, id string, hostConfig *docker.HostConfig) error {
 	client, err := dg.client()
 	if err != nil {
 		return err
 	}
 
-	err = client.StartContainer(id, hostConfig)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	err = callWithTimeout(ctx, "start", startContainerTimeout, func() error {
+		return client.StartContainer(id, hostConfig)
+	})
+	return dg.reconcileTimeout(err, id, func(container *docker.Container, err error) bool {
+		return err == nil && container.State.Running
+	})
 }
 
 func dockerStateToState(state docker.State) api.ContainerStatus {
@@ -220,29 +357,34 @@ func dockerStateToState(state docker.State) api.ContainerStatus {
 	return api.ContainerStopped
 }
 
-func (dg *DockerGoClient) DescribeContainer(dockerId string) (api.ContainerStatus, error) {
-	client, err := dg.client()
-	if err != nil {
-		return api.ContainerStatusUnknown, err
-	}
-
+func (dg *DockerGoClient) DescribeContainer(ctx context.Context, dockerId string) (api.ContainerStatus, error) {
 	if len(dockerId) == 0 {
 		return api.ContainerStatusUnknown, errors.New("Invalid container id: ''")
 	}
 
-	dockerContainer, err := client.InspectContainer(dockerId)
+	dockerContainer, err := dg.InspectContainer(ctx, dockerId)
 	if err != nil {
 		return api.ContainerStatusUnknown, err
 	}
 	return dockerStateToState(dockerContainer.State), nil
 }
 
-func (dg *DockerGoClient) InspectContainer(dockerId string) (*docker.Container, error) {
+func (dg *DockerGoClient) InspectContainer(ctx context.Context, dockerId string) (*docker.Container, error) {
 	client, err := dg.client()
 	if err != nil {
 		return nil, err
 	}
-	return client.InspectContainer(dockerId)
+
+	var container *docker.Container
+	err = callWithTimeout(ctx, "inspect", inspectContainerTimeout, func() error {
+		var err error
+		container, err = client.InspectContainer(dockerId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return container, nil
 }
 
 // DescribeDockerImages takes no arguments, and returns a JSON-encoded string of all of the images located on the host
@@ -265,42 +407,69 @@ func (dg *DockerGoClient) DescribeDockerImages() (string, error) {
 	return string(output), nil
 }
 
-func (dg *DockerGoClient) StopContainer(dockerId string) error {
+func (dg *DockerGoClient) StopContainer(ctx context.Context, dockerId string) error {
 	client, err := dg.client()
 	if err != nil {
 		return err
 	}
-	return client.StopContainer(dockerId, DEFAULT_TIMEOUT_SECONDS)
+	err = callWithTimeout(ctx, "stop", stopContainerTimeout, func() error {
+		return client.StopContainer(dockerId, DEFAULT_TIMEOUT_SECONDS)
+	})
+	return dg.reconcileTimeout(err, dockerId, func(container *docker.Container, err error) bool {
+		return err == nil && !container.State.Running
+	})
 }
 
-func (dg *DockerGoClient) RemoveContainer(dockerId string) error {
+func (dg *DockerGoClient) RemoveContainer(ctx context.Context, dockerId string) error {
 	client, err := dg.client()
 	if err != nil {
 		return err
 	}
-	return client.RemoveContainer(docker.RemoveContainerOptions{ID: dockerId, RemoveVolumes: true, Force: false})
+	err = callWithTimeout(ctx, "remove", removeContainerTimeout, func() error {
+		return client.RemoveContainer(docker.RemoveContainerOptions{ID: dockerId, RemoveVolumes: true, Force: false})
+	})
+	return dg.reconcileTimeout(err, dockerId, func(container *docker.Container, err error) bool {
+		_, removed := err.(*docker.NoSuchContainer)
+		return removed
+	})
 }
 
-func (dg *DockerGoClient) StopContainerById(id string) error {
-	client, err := dg.client()
-	if err != nil {
+// reconcileTimeout settles a start, stop or remove of a container whose call
+// timed out, since docker may have carried it out anyway. The container is
+// inspected, and if settled reports it is already as the call would leave
+// it, the call succeeded. Otherwise the timeout is returned; if the abandoned
+// call takes effect later, the event stream reports the change like any other.
+func (dg *DockerGoClient) reconcileTimeout(err error, dockerId string, settled func(*docker.Container, error) bool) error {
+	timeout, timedOut := err.(*DockerTimeoutError)
+	if !timedOut {
+		return err
+	}
+	container, inspectErr := dg.InspectContainer(context.Background(), dockerId)
+	if !settled(container, inspectErr) {
 		return err
 	}
-	return client.StopContainer(id, DEFAULT_TIMEOUT_SECONDS)
+	log.Info("Docker call timed out, but took effect", "operation", timeout.Operation, "id", dockerId)
+	return nil
 }
 
-func (dg *DockerGoClient) GetContainerName(id string) (string, error) {
-	client, err := dg.client()
-	if err != nil {
-		return "", err
-	}
-	container, err := client.InspectContainer(id)
+func (dg *DockerGoClient) StopContainerById(id string) error {
+	return dg.StopContainer(context.Background(), id)
+}
+
+func (dg *DockerGoClient) GetContainerName(ctx context.Context, id string) (string, error) {
+	container, err := dg.InspectContainer(ctx, id)
 	if err != nil {
 		return "", err
 	}
 	return container.Name, nil
 }
 
+// dockerEndpoint returns the docker daemon's endpoint, read from the
+// environment
+func dockerEndpoint() string {
+	return utils.DefaultIfBlank(os.Getenv(DOCKER_ENDPOINT_ENV_VARIABLE), DOCKER_DEFAULT_ENDPOINT)
+}
+
 // client returns the last used client if one has worked in the past, or a newly
 // created one if one has not been created yet
 func (dg *DockerGoClient) client() (*docker.Client, error) {
@@ -309,13 +478,16 @@ func (dg *DockerGoClient) client() (*docker.Client, error) {
 	}
 
 	// Re-read the env in case they corrected it
-	endpoint := utils.DefaultIfBlank(os.Getenv(DOCKER_ENDPOINT_ENV_VARIABLE), DOCKER_DEFAULT_ENDPOINT)
+	endpoint := dockerEndpoint()
 
 	client, err := docker.NewVersionedClient(endpoint, "1.15")
 	if err != nil {
 		log.Error("Unable to conect to docker client. Ensure daemon is running", "endpoint", endpoint, "err", err)
 		return nil, err
 	}
+	// Docker is never reached through a proxy, even one set in the
+	// environment
+	client.HTTPClient = &http.Client{Transport: &http.Transport{}}
 	dockerclient = client
 
 	return dockerclient, err
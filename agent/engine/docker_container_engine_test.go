@@ -0,0 +1,268 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+	"github.com/aws/amazon-ecs-agent/agent/utils/ttime"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestCallWithTimeout(t *testing.T) {
+	expected := errors.New("test error")
+	err := callWithTimeout(context.Background(), "start", time.Minute, func() error {
+		return expected
+	})
+	if err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+}
+
+func TestCallWithTimeoutTimesOut(t *testing.T) {
+	test_time.LudicrousSpeed(true)
+	defer test_time.LudicrousSpeed(false)
+
+	block := make(chan struct{})
+	defer close(block)
+	err := callWithTimeout(context.Background(), "start", time.Minute, func() error {
+		<-block
+		return nil
+	})
+	timeoutErr, ok := err.(*DockerTimeoutError)
+	if !ok {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	if timeoutErr.Operation != "start" || timeoutErr.Duration != time.Minute {
+		t.Errorf("Unexpected timeout error: %v", timeoutErr)
+	}
+	var retriable utils.Retriable = timeoutErr
+	if !retriable.Retry() {
+		t.Error("Timeouts should be retriable")
+	}
+}
+
+func TestCallWithTimeoutCancelled(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := callWithTimeout(ctx, "stop", time.Minute, func() error {
+		<-block
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected the call to be cancelled, got %v", err)
+	}
+}
+
+func TestCallWithTimeoutAlreadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	err := callWithTimeout(ctx, "create", time.Minute, func() error {
+		called = true
+		return nil
+	})
+	if err != context.Canceled || called {
+		t.Errorf("Expected the call not to be made, got %v, called %v", err, called)
+	}
+}
+
+// fakeDocker is a docker daemon whose container creates wait until release
+// is closed
+type fakeDocker struct {
+	lock        sync.Mutex
+	created     map[string]bool
+	running     map[string]bool
+	removed     []string
+	release     chan struct{}
+	releaseOnce sync.Once
+}
+
+func (d *fakeDocker) releaseCreates() {
+	d.releaseOnce.Do(func() {
+		close(d.release)
+	})
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:]
+	switch {
+	case r.Method == "GET" && strings.HasPrefix(path, "/images/"):
+		io.WriteString(w, `{"Id":"image"}`)
+	case r.Method == "POST" && path == "/containers/create":
+		<-d.release
+		d.lock.Lock()
+		d.created[r.URL.Query().Get("name")] = true
+		d.lock.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"Id":"id-`+r.URL.Query().Get("name")+`"}`)
+	case r.Method == "GET" && strings.HasPrefix(path, "/containers/"):
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if !d.created[name] {
+			http.Error(w, "no such container", http.StatusNotFound)
+			return
+		}
+		running := "false"
+		if d.running[name] {
+			running = "true"
+		}
+		io.WriteString(w, `{"Id":"id-`+name+`","Name":"/`+name+`","State":{"Running":`+running+`}}`)
+	case r.Method == "DELETE" && strings.HasPrefix(path, "/containers/"):
+		name := strings.TrimPrefix(path, "/containers/")
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if !d.created[name] {
+			http.Error(w, "no such container", http.StatusNotFound)
+			return
+		}
+		delete(d.created, name)
+		d.removed = append(d.removed, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}
+}
+
+func (d *fakeDocker) wasRemoved(name string) bool {
+	for i := 0; i < 100; i++ {
+		d.lock.Lock()
+		removed := len(d.removed) == 1 && d.removed[0] == name
+		d.lock.Unlock()
+		if removed {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func withFakeDocker(t *testing.T) (*fakeDocker, func()) {
+	fake := &fakeDocker{created: make(map[string]bool), running: make(map[string]bool), release: make(chan struct{})}
+	server := httptest.NewServer(fake)
+	client, err := docker.NewVersionedClient(server.URL, "1.15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SkipServerVersionCheck = true
+	previous := dockerclient
+	dockerclient = client
+	// The timeouts must elapse in real time for the daemon to outlive them
+	ttime.SetTime(&ttime.DefaultTime{})
+	return fake, func() {
+		ttime.SetTime(test_time)
+		fake.releaseCreates()
+		dockerclient = previous
+		server.Close()
+	}
+}
+
+func TestCreateContainerTimeoutRemovesLateContainer(t *testing.T) {
+	fake, done := withFakeDocker(t)
+	defer done()
+
+	// The container is created, but not in time for the create to return
+	dg := &DockerGoClient{}
+	_, err := dg.createContainerWithTimeout(context.Background(), &docker.Config{Image: "image"}, "late", 50*time.Millisecond)
+	fake.releaseCreates()
+	if _, ok := err.(*DockerTimeoutError); !ok {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if !fake.wasRemoved("late") {
+		t.Error("Expected the container created after the timeout to be removed")
+	}
+
+	id, err := dg.createContainerWithTimeout(context.Background(), &docker.Config{Image: "image"}, "next", time.Minute)
+	if err != nil || id != "id-next" {
+		t.Errorf("Expected the container to be created, got %v %v", id, err)
+	}
+}
+
+func TestCreateContainerTimeoutAdoptsExisting(t *testing.T) {
+	fake, done := withFakeDocker(t)
+	defer done()
+
+	// The daemon created the container, but has not responded
+	fake.created["existing"] = true
+	dg := &DockerGoClient{}
+	id, err := dg.createContainerWithTimeout(context.Background(), &docker.Config{Image: "image"}, "existing", 50*time.Millisecond)
+	fake.releaseCreates()
+	if err != nil || id != "id-existing" {
+		t.Errorf("Expected the existing container to be adopted, got %v %v", id, err)
+	}
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if len(fake.removed) != 0 {
+		t.Error("Expected the adopted container not to be removed", fake.removed)
+	}
+}
+
+func TestReconcileTimeout(t *testing.T) {
+	fake, done := withFakeDocker(t)
+	defer done()
+
+	// The daemon carried out the stop and the remove, but not the start,
+	// before their calls timed out
+	fake.created["stopped"] = true
+	dg := &DockerGoClient{}
+	timeout := func(operation string) error {
+		return &DockerTimeoutError{Operation: operation, Duration: time.Minute}
+	}
+	running := func(container *docker.Container, err error) bool {
+		return err == nil && container.State.Running
+	}
+	stopped := func(container *docker.Container, err error) bool {
+		return err == nil && !container.State.Running
+	}
+	removed := func(container *docker.Container, err error) bool {
+		_, ok := err.(*docker.NoSuchContainer)
+		return ok
+	}
+
+	if err := dg.reconcileTimeout(timeout("start"), "stopped", running); err == nil {
+		t.Error("Expected a start that did not take effect to time out")
+	}
+	if err := dg.reconcileTimeout(timeout("stop"), "stopped", stopped); err != nil {
+		t.Errorf("Expected a stop that took effect to succeed, got %v", err)
+	}
+	if err := dg.reconcileTimeout(timeout("remove"), "removed", removed); err != nil {
+		t.Errorf("Expected a remove that took effect to succeed, got %v", err)
+	}
+	if err := dg.reconcileTimeout(timeout("remove"), "stopped", removed); err == nil {
+		t.Error("Expected a remove that did not take effect to time out")
+	}
+
+	fake.running["stopped"] = true
+	if err := dg.reconcileTimeout(timeout("start"), "stopped", running); err != nil {
+		t.Errorf("Expected a start that took effect to succeed, got %v", err)
+	}
+	expected := errors.New("test error")
+	if err := dg.reconcileTimeout(expected, "stopped", running); err != expected {
+		t.Errorf("Expected errors other than timeouts to be returned, got %v", err)
+	}
+}
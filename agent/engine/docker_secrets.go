@@ -0,0 +1,45 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"errors"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/secrets"
+)
+
+// resolveSecrets looks up the values of a container's secrets and returns
+// them as docker environment entries. The values are only ever passed on to
+// docker; they must not be logged or stored on the task or container.
+// Errors name the secret, never its value. A secret the provider does not
+// have fails creation permanently; any other failure may be retried.
+// containerDesc describes the container in logs.
+func (engine *DockerTaskEngine) resolveSecrets(containerDesc string, containerSecrets []api.Secret) ([]string, error) {
+	if len(containerSecrets) == 0 {
+		return nil, nil
+	}
+	env := make([]string, 0, len(containerSecrets))
+	for _, secret := range containerSecrets {
+		value, err := engine.secrets.GetSecret(secret.ValueFrom)
+		if err != nil {
+			_, notFound := err.(secrets.NotFoundError)
+			err = errors.New("unable to resolve secret " + secret.ValueFrom + " for " + secret.Name + ": " + err.Error())
+			log.Warn("Unable to resolve container secret", "container", containerDesc, "err", err)
+			return nil, &DockerOperationError{Operation: "create", Err: err, Retriable: !notFound}
+		}
+		env = append(env, secret.Name+"="+value)
+	}
+	return env, nil
+}
@@ -16,19 +16,21 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
 
 	"github.com/aws/amazon-ecs-agent/agent/api"
 	"github.com/aws/amazon-ecs-agent/agent/config"
-	"github.com/aws/amazon-ecs-agent/agent/engine/dependencygraph"
 	"github.com/aws/amazon-ecs-agent/agent/engine/dockerauth"
 	"github.com/aws/amazon-ecs-agent/agent/engine/dockerstate"
+	"github.com/aws/amazon-ecs-agent/agent/engine/pause"
+	"github.com/aws/amazon-ecs-agent/agent/secrets"
 	"github.com/aws/amazon-ecs-agent/agent/statemanager"
 	"github.com/aws/amazon-ecs-agent/agent/utils"
-	"github.com/aws/amazon-ecs-agent/agent/utils/ttime"
-	"github.com/fsouza/go-dockerclient"
+
+	docker "github.com/fsouza/go-dockerclient"
 )
 
 const (
@@ -39,7 +41,8 @@ const (
 )
 
 const (
-	sweepInterval       = 5 * time.Minute
+	// taskStoppedDuration is how long a stopped task is kept around before its
+	// containers are removed
 	taskStoppedDuration = 3 * time.Hour
 )
 
@@ -50,11 +53,27 @@ type DockerTaskEngine struct {
 
 	state *dockerstate.DockerTaskEngineState
 
+	// managedTasks holds the goroutine-owning wrapper of every task the
+	// engine is actively managing. processTasks guards it.
+	managedTasks map[string]*managedTask
+	processTasks sync.Mutex
+
 	events           <-chan DockerContainerChangeEvent
 	container_events chan api.ContainerStateChange
 	saver            statemanager.Saver
 
+	cfg    *config.Config
 	client DockerClient
+
+	// retryPolicies holds the RetryPolicy for each container transition
+	retryPolicies map[api.ContainerStatus]RetryPolicy
+
+	// secrets looks up the values of container secrets at creation time
+	secrets secrets.Provider
+
+	// volumeLock serializes creating docker volumes with recording, or
+	// releasing, references to them
+	volumeLock sync.Mutex
 }
 
 // NewDockerTaskEngine returns a created, but uninitialized, DockerTaskEngine.
@@ -63,10 +82,14 @@ type DockerTaskEngine struct {
 // is also initialized.
 func NewDockerTaskEngine(cfg *config.Config) *DockerTaskEngine {
 	dockerTaskEngine := &DockerTaskEngine{
+		cfg:    cfg,
 		client: nil,
 		saver:  statemanager.NewNoopStateManager(),
 
-		state: dockerstate.NewDockerTaskEngineState(),
+		state:         dockerstate.NewDockerTaskEngineState(),
+		managedTasks:  make(map[string]*managedTask),
+		retryPolicies: defaultRetryPolicies(),
+		secrets:       secrets.NewProvider(cfg),
 
 		container_events: make(chan api.ContainerStateChange),
 	}
@@ -80,6 +103,12 @@ func (engine *DockerTaskEngine) UnmarshalJSON(data []byte) error {
 	return engine.state.UnmarshalJSON(data)
 }
 
+// UnmarshalJSONLenient restores what decodes of a previously marshaled
+// task-engine state, describing what it skipped
+func (engine *DockerTaskEngine) UnmarshalJSONLenient(data []byte) ([]string, error) {
+	return engine.state.UnmarshalJSONLenient(data)
+}
+
 // MarshalJSON marshals into state directly
 func (engine *DockerTaskEngine) MarshalJSON() ([]byte, error) {
 	return engine.state.MarshalJSON()
@@ -94,7 +123,7 @@ func (engine *DockerTaskEngine) Init() error {
 		if err != nil {
 			return err
 		}
-		engine.client = client
+		engine.client = newDockerWorkQueue(client, engine.cfg.DockerConcurrency, engine.cfg.DockerPullConcurrency)
 	}
 
 	// Open the event stream before we sync state so that e.g. if a container
@@ -108,8 +137,6 @@ func (engine *DockerTaskEngine) Init() error {
 	// Now catch up and start processing new events per normal
 	go engine.handleDockerEvents()
 
-	go engine.sweepTasks()
-
 	return nil
 }
 
@@ -137,68 +164,92 @@ func (engine *DockerTaskEngine) SetSaver(saver statemanager.Saver) {
 }
 
 // synchronizeState explicitly goes through each docker container stored in
-// "state" and updates its KnownStatus appropriately, as well as queueing up
-// events to push upstream.
+// "state" and updates its KnownStatus appropriately, and then begins managing
+// every task. Each managed task resends its current status upstream.
 func (engine *DockerTaskEngine) synchronizeState() {
+	engine.processTasks.Lock()
+	defer engine.processTasks.Unlock()
+
 	tasks := engine.state.AllTasks()
 	for _, task := range tasks {
 		conts, ok := engine.state.ContainerMapByArn(task.Arn)
-		if !ok {
-			continue
-		}
-		for _, cont := range conts {
-			var reason string
-			dockerId := cont.DockerId
-			currentState, err := engine.client.DescribeContainer(dockerId)
-			if err != nil {
-				currentState = api.ContainerDead
-				if !cont.Container.KnownTerminal() {
-					log.Warn("Could not describe previously known container; assuming dead", "err", err)
-					reason = "Docker did not recognize container id after an ECS Agent restart."
-				}
-			}
-			if currentState > cont.Container.KnownStatus {
-				cont.Container.KnownStatus = currentState
+		if ok {
+			for _, cont := range conts {
+				engine.synchronizeContainer(cont)
 			}
-			// Over-aggressively resend everything. The task handler will
-			// discard items that have already been sent.
-			// We cannot actually emit an event yet because nothing is handling
-			// events; just throw it in a goroutine so this doesn't block
-			// forever.
-			go engine.emitEvent(task, cont, reason)
 		}
+		engine.startTask(task)
 	}
 	engine.saver.Save()
 }
 
-// sweepTasks periodically sweeps through all tasks looking for tasks that have
-// been in the 'stopped' state for a sufficiently long time. At that time it
-// deletes them and removes them from its "state".
-func (engine *DockerTaskEngine) sweepTasks() {
-	for {
-		tasks := engine.state.AllTasks()
-
-		for _, task := range tasks {
-			if task.KnownStatus.Terminal() {
-				if ttime.Since(task.KnownTime) > taskStoppedDuration {
-					engine.sweepTask(task)
-					engine.state.RemoveTask(task)
-				}
+// synchronizeContainer updates a single container's KnownStatus from docker
+func (engine *DockerTaskEngine) synchronizeContainer(cont *api.DockerContainer) {
+	currentState, err := engine.client.DescribeContainer(context.Background(), cont.DockerId)
+	if err != nil {
+		currentState = api.ContainerDead
+		if !cont.Container.KnownTerminal() {
+			log.Warn("Could not describe previously known container; assuming dead", "err", err)
+			if cont.Container.ApplyingError == nil {
+				cont.Container.ApplyingError = api.NewApplyingError(errors.New("Docker did not recognize container id after an ECS Agent restart."))
 			}
 		}
-
-		ttime.Sleep(sweepInterval)
 	}
+	if currentState > cont.Container.KnownStatus {
+		cont.Container.KnownStatus = currentState
+	}
+}
+
+// startTask begins managing a task in its own goroutine. The caller must hold
+// processTasks.
+func (engine *DockerTaskEngine) startTask(task *api.Task) {
+	mtask := newManagedTask(engine, task)
+	engine.managedTasks[task.Arn] = mtask
+	go mtask.overseeTask()
+}
+
+// managedTaskByArn returns the managed task for the given arn, if any
+func (engine *DockerTaskEngine) managedTaskByArn(arn string) (*managedTask, bool) {
+	engine.processTasks.Lock()
+	defer engine.processTasks.Unlock()
+
+	mtask, ok := engine.managedTasks[arn]
+	return mtask, ok
+}
+
+// removeManagedTask stops tracking a task that has been cleaned up and
+// removes it from the engine's state.
+func (engine *DockerTaskEngine) removeManagedTask(mtask *managedTask) {
+	engine.processTasks.Lock()
+	defer engine.processTasks.Unlock()
+
+	delete(engine.managedTasks, mtask.Arn)
+	engine.state.RemoveTask(mtask.Task)
+	engine.saver.Save()
 }
 
-// sweepTask deletes all the containers associated with a task
+// sweepTask deletes all the containers associated with a task, and the docker
+// volumes no other task is using
 func (engine *DockerTaskEngine) sweepTask(task *api.Task) {
 	for _, cont := range task.Containers {
-		err := engine.RemoveContainer(task, cont)
+		err := engine.RemoveContainer(context.Background(), task, cont)
 		if err != nil {
 			log.Debug("Unable to remove old container", "err", err, "task", task, "cont", cont)
 		}
 	}
+	engine.releaseVolumes(task)
+}
+
+// dockerContainerFor returns the DockerContainer for the given container of
+// a task. If docker has never created it, the returned DockerContainer has no
+// id.
+func (engine *DockerTaskEngine) dockerContainerFor(task *api.Task, container *api.Container) *api.DockerContainer {
+	if containerMap, ok := engine.state.ContainerMapByArn(task.Arn); ok {
+		if dockerContainer, ok := containerMap[container.Name]; ok {
+			return dockerContainer
+		}
+	}
+	return &api.DockerContainer{Container: container}
 }
 
 // emitEvent passes a given event up through the container_event channel.
@@ -207,10 +258,6 @@ func (engine *DockerTaskEngine) sweepTask(task *api.Task) {
 func (engine *DockerTaskEngine) emitEvent(task *api.Task, container *api.DockerContainer, reason string) {
 	err := engine.updateContainerMetadata(task, container)
 
-	// Every time something changes, make sure the state for the thing that
-	// changed is known about and move forwards if this change allows us to
-	defer engine.ApplyTaskState(task)
-
 	// Collect additional info we need for our StateChanges
 	if err != nil {
 		log.Crit("Error updating container metadata", "err", err)
@@ -220,6 +267,9 @@ func (engine *DockerTaskEngine) emitEvent(task *api.Task, container *api.DockerC
 	if reason == "" && cont.ApplyingError != nil {
 		reason = cont.ApplyingError.Error()
 	}
+	if reason == "" && cont.KnownTerminal() {
+		reason = task.StoppedReason
+	}
 	event := api.ContainerStateChange{
 		TaskArn:       task.Arn,
 		ContainerName: cont.Name,
@@ -252,7 +302,8 @@ func (engine *DockerTaskEngine) openEventstream() error {
 }
 
 // handleDockerEvents must be called after openEventstream; it processes each
-// event that it reads from the docker eventstream
+// event that it reads from the docker eventstream by passing it to the task
+// the container belongs to
 func (engine *DockerTaskEngine) handleDockerEvents() {
 	for event := range engine.events {
 		log.Info("Handling an event", "event", event)
@@ -263,17 +314,12 @@ func (engine *DockerTaskEngine) handleDockerEvents() {
 			log.Debug("Event for container not managed", "dockerId", event.DockerId)
 			continue
 		}
-		// Update the status to what we now know to be the true status
-		if cont.Container.KnownStatus < event.Status {
-			cont.Container.KnownStatus = event.Status
-			engine.emitEvent(task, cont, "")
-		} else if cont.Container.KnownStatus == event.Status {
-			log.Warn("Redundant docker event; unusual but not critical", "event", event, "cont", cont)
-		} else {
-			if !cont.Container.KnownTerminal() {
-				log.Crit("Docker container went backwards in state! This container will no longer be managed", "cont", cont, "event", event)
-			}
+		mtask, ok := engine.managedTaskByArn(task.Arn)
+		if !ok {
+			log.Debug("Event for task not being managed", "task", task, "dockerId", event.DockerId)
+			continue
 		}
+		mtask.handleDockerEvent(cont, event)
 	}
 	log.Crit("Docker event stream closed unexpectedly")
 }
@@ -289,14 +335,17 @@ func (engine *DockerTaskEngine) updateContainerMetadata(task *api.Task, containe
 	llog := log.New("task", task, "container", container)
 	switch container.Container.KnownStatus {
 	case api.ContainerRunning:
-		containerInfo, err := engine.client.InspectContainer(container.DockerId)
+		containerInfo, err := engine.client.InspectContainer(context.Background(), container.DockerId)
 		if err != nil {
 			llog.Error("Error inspecting container", "err", err)
 			return err
 		}
 
 		// Port bindings
-		if containerInfo.NetworkSettings != nil {
+		if bindings, ok := task.SharedPortBindings(container.Container); ok {
+			// Published by the pause container on this container's behalf
+			container.Container.KnownPortBindings = bindings
+		} else if containerInfo.NetworkSettings != nil {
 			// Convert port bindings into the format our container expects
 			bindings, err := api.PortBindingFromDockerPortBinding(containerInfo.NetworkSettings.Ports)
 			if err != nil {
@@ -304,12 +353,15 @@ func (engine *DockerTaskEngine) updateContainerMetadata(task *api.Task, containe
 			}
 			container.Container.KnownPortBindings = bindings
 		}
+		if containerInfo.NetworkSettings != nil {
+			container.Container.KnownIPAddress = containerInfo.NetworkSettings.IPAddress
+		}
 
 		task.UpdateMountPoints(container.Container, containerInfo.Volumes)
 	case api.ContainerStopped:
 		fallthrough
 	case api.ContainerDead:
-		containerInfo, err := engine.client.InspectContainer(container.DockerId)
+		containerInfo, err := engine.client.InspectContainer(context.Background(), container.DockerId)
 		if err != nil {
 			llog.Error("Error inspecting container", "err", err)
 			return err
@@ -344,238 +396,307 @@ func TaskCompleted(task *api.Task) bool {
 	return true
 }
 
+// AddTask starts tracking a task. If the task is already known, its desired
+// status is passed along to the goroutine managing it instead.
+// Tasks added before the engine is initialized are only recorded; they will
+// be managed once Init is called.
 func (engine *DockerTaskEngine) AddTask(task *api.Task) {
-	task.PostUnmarshalTask()
-
-	task = engine.state.AddOrUpdateTask(task)
+	engine.processTasks.Lock()
+	_, exists := engine.state.TaskByArn(task.Arn)
+	if !exists {
+		engine.assignEmptyVolumeHostDirs(task)
+		task.PostUnmarshalTask()
+		engine.assignPauseImage(task)
+		engine.state.AddOrUpdateTask(task)
+		statemanager.Record(engine.saver, JournalTaskAdded, &TaskAddedEntry{Task: task})
+		if engine.client != nil {
+			engine.startTask(task)
+		}
+		engine.processTasks.Unlock()
+		return
+	}
+	mtask, managed := engine.managedTasks[task.Arn]
+	engine.processTasks.Unlock()
 
-	engine.ApplyTaskState(task)
+	if !managed {
+		engine.state.AddOrUpdateTask(task)
+		return
+	}
+	mtask.updateDesiredStatus(task.DesiredStatus)
 }
 
-type transitionApplyFunc (func(*api.Task, *api.Container) error)
-
-func tryApplyTransition(task *api.Task, container *api.Container, to api.ContainerStatus, f transitionApplyFunc) error {
-	err := utils.RetryNWithBackoff(utils.NewSimpleBackoff(5*time.Second, 30*time.Second, 0.25, 2), 3, func() error {
-		return f(task, container)
-	})
+// StopTask stops the task with the given Arn, recording why; the reason is
+// reported with its stopped state changes. A reason already recorded is kept.
+func (engine *DockerTaskEngine) StopTask(arn, reason string) bool {
+	engine.processTasks.Lock()
+	task, exists := engine.state.TaskByArn(arn)
+	if !exists {
+		engine.processTasks.Unlock()
+		return false
+	}
+	if task.StoppedReason == "" {
+		task.StoppedReason = reason
+	}
+	mtask, managed := engine.managedTasks[arn]
+	engine.processTasks.Unlock()
 
-	if err == nil {
-		container.AppliedStatus = to
+	if !managed {
+		if task.DesiredStatus < api.TaskStopped {
+			task.DesiredStatus = api.TaskStopped
+		}
+		return true
 	}
-	return err
+	mtask.updateDesiredStatus(api.TaskStopped)
+	return true
 }
 
-func (engine *DockerTaskEngine) ApplyContainerState(task *api.Task, container *api.Container) {
-	container.StatusLock.Lock()
-	defer container.StatusLock.Unlock()
+type transitionApplyFunc (func(context.Context, *transitionRequest) error)
+
+// transitionRequest holds everything applying a container transition needs.
+// It is built on the task's goroutine, which alone reads and writes the task
+// and its containers. The goroutine making the docker calls reads only the
+// request, so it never races with the task's statuses changing.
+type transitionRequest struct {
+	// task and container are not read while the transition is applied; they
+	// are only recorded in the engine's state once the container is created
+	task      *api.Task
+	container *api.Container
+
+	taskArn       string
+	containerName string
+	nextState     api.ContainerStatus
+	// taskDesc and containerDesc describe the task and container in logs, as
+	// they were when the transition began
+	taskDesc      string
+	containerDesc string
+
+	// image is what a container is pulled from
+	image string
+	// config, secrets and volumes are what a container is created with;
+	// resourcePrefix begins the names of the docker resources created for it
+	config         *docker.Config
+	secrets        []api.Secret
+	volumes        []api.TaskVolume
+	resourcePrefix string
+	// dockerId is the container to start or stop; hostConfig is what it is
+	// started with
+	dockerId   string
+	hostConfig *docker.HostConfig
+}
 
-	clog := log.New("task", task, "container", container)
-	if container.KnownStatus == container.DesiredStatus {
-		clog.Debug("Container at desired status", "desired", container.DesiredStatus)
-		return
+// taskStopTimeout returns how long a task's containers are stopped in
+// dependency order before the rest are stopped at once
+func (engine *DockerTaskEngine) taskStopTimeout() time.Duration {
+	if engine.cfg.TaskStopTimeout <= 0 {
+		return config.DEFAULT_TASK_STOP_TIMEOUT
 	}
-	if container.AppliedStatus >= container.DesiredStatus {
-		clog.Debug("Container already working towards desired status", "desired", container.DesiredStatus)
+	return engine.cfg.TaskStopTimeout
+}
+
+// assignPauseImage runs the pause container of a new task from the
+// configured image
+func (engine *DockerTaskEngine) assignPauseImage(task *api.Task) {
+	pauseContainer, ok := task.PauseContainer()
+	if !ok {
 		return
 	}
-	if container.KnownStatus > container.DesiredStatus {
-		clog.Debug("Container past desired status")
+	if engine.cfg.PauseContainerImage == "" {
+		pauseContainer.Image = pause.Image + ":" + pause.Tag
 		return
 	}
-	if !dependencygraph.DependenciesAreResolved(container, task.Containers) {
-		clog.Info("Can't apply state to container yet; dependencies unresolved", "state", container.DesiredStatus)
-		return
+	pauseContainer.Image = engine.cfg.PauseContainerImage
+}
+
+// SetRetryPolicy changes how failed transitions of containers to the given
+// status are retried. It must be called before the engine is initialized.
+func (engine *DockerTaskEngine) SetRetryPolicy(status api.ContainerStatus, policy RetryPolicy) {
+	engine.retryPolicies[status] = policy
+}
+
+// tryApplyTransition calls f until it succeeds or returns an error that is not
+// retriable, trying at most as many times as the retry policy for the
+// request's status allows. It gives up as soon as ctx is done.
+func (engine *DockerTaskEngine) tryApplyTransition(ctx context.Context, req *transitionRequest, f transitionApplyFunc) error {
+	policy, ok := engine.retryPolicies[req.nextState]
+	if !ok || policy.Attempts < 1 {
+		policy.Attempts = 1
 	}
-	// If we got here, the KnownStatus < DesiredStatus and we haven't applied
-	// DesiredStatus yet; appliy a step towards it now
-
-	var err error
-	// Terminal cases are special. If our desired status is terminal, then
-	// immediately go there with no regard to creating or starting the container
-	if container.DesiredTerminal() {
-		// Terminal cases are also special in that we do not record any error
-		// in applying this state; this is because it could overwrite an error
-		// that caused us to stop it and the previous error is more useful to
-		// show. This is also the only state where an error results in a
-		// state-change submission anyways.
-		if container.AppliedStatus < api.ContainerStopped {
-			err = tryApplyTransition(task, container, api.ContainerStopped, engine.StopContainer)
-			if err != nil {
-				clog.Info("Unable to stop container", "err", err)
-				// If there was an error, assume we won't get an event in the
-				// eventstream and emit it ourselves.
-				container.KnownStatus = api.ContainerStopped
-				engine.emitEvent(task, &api.DockerContainer{Container: container}, "")
-				if _, ok := err.(*docker.NoSuchContainer); ok {
-					engine.state.RemoveTask(task)
-				}
-				err = nil
-			}
+
+	// A cancelled transition is not tried again, nor waited on in a backoff
+	return utils.RetryNWithBackoffCtx(ctx, policy.backoff(), policy.Attempts, func() error {
+		err := f(ctx, req)
+		if err != nil && ctx.Err() == nil {
+			log.Info("Error applying transition", "task", req.taskDesc, "container", req.containerDesc, "to", req.nextState.String(), "err", err)
 		}
-	} else if container.AppliedStatus < api.ContainerPulled {
-		err = tryApplyTransition(task, container, api.ContainerPulled, engine.PullContainer)
+		return err
+	})
+}
+
+// newTransitionRequest prepares the transition of a task's container to
+// nextState. It must be called on the task's goroutine. The error it returns
+// is one that retrying cannot fix.
+func (engine *DockerTaskEngine) newTransitionRequest(task *api.Task, container *api.Container, nextState api.ContainerStatus) (*transitionRequest, error) {
+	req := &transitionRequest{
+		task:          task,
+		container:     container,
+		taskArn:       task.Arn,
+		containerName: container.Name,
+		nextState:     nextState,
+		taskDesc:      task.String(),
+		containerDesc: container.String(),
+	}
+	switch nextState {
+	case api.ContainerPulled:
+		req.image = container.Image
+	case api.ContainerCreated:
+		config, err := task.DockerConfig(container)
 		if err != nil {
-			clog.Warn("Unable to pull container image", "err", err)
-		} else {
-			// PullImage is a special case; update KnownStatus because there is
-			// no corresponding event from the docker eventstream to update
-			// this with.
-			container.KnownStatus = api.ContainerPulled
+			return nil, permanentError("create", err)
 		}
-	}
-
-	if !container.DesiredTerminal() {
-		if container.AppliedStatus < api.ContainerCreated {
-			err = tryApplyTransition(task, container, api.ContainerCreated, engine.CreateContainer)
-			if err != nil {
-				clog.Warn("Unable to create container", "err", err)
+		req.config = config
+		req.secrets = append([]api.Secret(nil), container.Secrets...)
+		for _, mountPoint := range container.MountPoints {
+			if volume, ok := task.HostVolumeByName(mountPoint.SourceVolume); ok {
+				req.volumes = append(req.volumes, api.TaskVolume{Name: mountPoint.SourceVolume, Volume: volume})
 			}
-		} else if container.AppliedStatus < api.ContainerRunning {
-			err = tryApplyTransition(task, container, api.ContainerRunning, engine.StartContainer)
+		}
+		req.resourcePrefix = dockerResourcePrefix(task)
+	case api.ContainerRunning, api.ContainerStopped:
+		operation := "start"
+		if nextState == api.ContainerStopped {
+			operation = "stop"
+		}
+		containerMap, ok := engine.state.ContainerMapByArn(task.Arn)
+		if !ok {
+			return nil, permanentError(operation, errors.New("No such task: "+task.Arn))
+		}
+		dockerContainer, ok := containerMap[container.Name]
+		if !ok {
+			return nil, permanentError(operation, errors.New("No container named '"+container.Name+"' created in "+task.Arn))
+		}
+		req.dockerId = dockerContainer.DockerId
+		if nextState == api.ContainerRunning {
+			hostConfig, err := task.DockerHostConfig(container, containerMap)
 			if err != nil {
-				clog.Warn("Unable to start container", "err", err)
+				return nil, permanentError("start", err)
 			}
+			req.hostConfig = hostConfig
 		}
 	}
-
-	if err != nil {
-		// If we were unable to successfully accomplish a state transition,
-		// we should move that container to 'stopped'
-		container.ApplyingError = api.NewApplyingError(err)
-		container.DesiredStatus = api.ContainerStopped
-		// Because our desired status is now stopped, we should call this
-		// function again to actually stop it
-		go engine.ApplyContainerState(task, container)
-	} else {
-		clog.Debug("Successfully applied transition")
-	}
-
-	engine.saver.Save()
+	return req, nil
 }
 
-// ApplyTaskState checks if there is any work to be done on a given task or any
-// of the containers belonging to it, and if there is work to be done that can
-// be done, it does it. This function can be called frequently (and should be
-// called anytime a container changes) and will do nothing if the task is at a
-// steady state
-func (engine *DockerTaskEngine) ApplyTaskState(task *api.Task) {
-	llog := log.New("task", task)
-	llog.Info("Top of ApplyTaskState")
-
-	task.InferContainerDesiredStatus()
-
-	if !dependencygraph.ValidDependencies(task) {
-		llog.Error("Invalid task dependency graph")
-		return
-	}
-	if TaskCompleted(task) {
-		llog.Info("Task completed, not acting upon it")
-		return
-	}
-
-	for _, container := range task.Containers {
-		go engine.ApplyContainerState(task, container)
+// applyContainerTransition makes the docker calls needed to move a container
+// to the request's status. It reads only the request, never the task or
+// container; the task's goroutine records the result.
+func (engine *DockerTaskEngine) applyContainerTransition(ctx context.Context, req *transitionRequest) error {
+	switch req.nextState {
+	case api.ContainerPulled:
+		return engine.tryApplyTransition(ctx, req, engine.pullContainer)
+	case api.ContainerCreated:
+		return engine.tryApplyTransition(ctx, req, engine.createContainer)
+	case api.ContainerRunning:
+		return engine.tryApplyTransition(ctx, req, engine.startContainer)
+	case api.ContainerStopped:
+		return engine.tryApplyTransition(ctx, req, engine.stopContainer)
 	}
+	return errors.New("Unable to transition container to " + req.nextState.String())
 }
 
 func (engine *DockerTaskEngine) ListTasks() ([]*api.Task, error) {
 	return engine.state.AllTasks(), nil
 }
 
-func (engine *DockerTaskEngine) PullContainer(task *api.Task, container *api.Container) error {
-	log.Info("Pulling container", "task", task, "container", container)
+func (engine *DockerTaskEngine) pullContainer(ctx context.Context, req *transitionRequest) error {
+	log.Info("Pulling container", "task", req.taskDesc, "container", req.containerDesc)
 
-	err := engine.client.PullImage(container.Image)
+	err := engine.client.PullImage(ctx, req.image)
 	if err != nil {
-		return err
+		return classifyDockerError("pull", err)
 	}
 	return nil
 }
 
-func (engine *DockerTaskEngine) CreateContainer(task *api.Task, container *api.Container) error {
-	log.Info("Creating container", "task", task, "container", container)
-	config, err := task.DockerConfig(container)
+func (engine *DockerTaskEngine) createContainer(ctx context.Context, req *transitionRequest) error {
+	log.Info("Creating container", "task", req.taskDesc, "container", req.containerDesc)
+	secretEnv, err := engine.resolveSecrets(req.containerDesc, req.secrets)
 	if err != nil {
 		return err
 	}
+	// Each attempt adds the secrets to its own copy of the config
+	config := *req.config
+	config.Env = append(append([]string(nil), req.config.Env...), secretEnv...)
 
+	if err := engine.acquireVolumes(ctx, req); err != nil {
+		return err
+	}
+
+	containerName := dockerResourceName(req.resourcePrefix, req.containerName)
+	var containerId string
 	err = func() error {
 		// Lock state for writing so that handleDockerEvents will block on
 		// resolving the 'create' event's dockerid until it is actually in the
-		// added to state.
+		// added to state. The create call is bounded by its timeout, so a hung
+		// daemon cannot hold this lock forever.
 		engine.state.Lock()
 		defer engine.state.Unlock()
 
-		name := ""
-		for i := 0; i < len(container.Name); i++ {
-			c := container.Name[i]
-			if !((c <= '9' && c >= '0') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c == '-')) {
-				continue
-			}
-			name += string(c)
-		}
-
-		containerName := "ecs-" + task.Family + "-" + task.Version + "-" + name + "-" + utils.RandHex()
-		containerId, err := engine.client.CreateContainer(config, containerName)
+		var err error
+		containerId, err = engine.client.CreateContainer(ctx, &config, containerName)
 		if err != nil {
-			return err
+			return classifyDockerError("create", err)
 		}
-		engine.state.AddContainer(&api.DockerContainer{DockerId: containerId, DockerName: containerName, Container: container}, task)
-		log.Info("Created container successfully", "task", task, "container", container)
+		engine.state.AddContainer(&api.DockerContainer{DockerId: containerId, DockerName: containerName, Container: req.container}, req.task)
+		log.Info("Created container successfully", "task", req.taskDesc, "container", req.containerDesc)
 		return nil
 	}()
-	return err
-}
-
-func (engine *DockerTaskEngine) StartContainer(task *api.Task, container *api.Container) error {
-	log.Info("Starting container", "task", task, "container", container)
-	containerMap, ok := engine.state.ContainerMapByArn(task.Arn)
-	if !ok {
-		return errors.New("No such task: " + task.Arn)
-	}
-
-	dockerContainer, ok := containerMap[container.Name]
-	if !ok {
-		return errors.New("No container named '" + container.Name + "' created in " + task.Arn)
-	}
-
-	hostConfig, err := task.DockerHostConfig(container, containerMap)
 	if err != nil {
 		return err
 	}
-
-	return engine.client.StartContainer(dockerContainer.DockerId, hostConfig)
+	statemanager.Record(engine.saver, JournalContainerCreated, &ContainerCreatedEntry{
+		TaskArn:       req.taskArn,
+		ContainerName: req.containerName,
+		DockerId:      containerId,
+		DockerName:    containerName,
+	})
+	return nil
 }
 
-func (engine *DockerTaskEngine) StopContainer(task *api.Task, container *api.Container) error {
-	log.Info("Stopping container", "task", task, "container", container)
-	containerMap, ok := engine.state.ContainerMapByArn(task.Arn)
-	if !ok {
-		return errors.New("No such task: " + task.Arn)
-	}
-
-	dockerContainer, ok := containerMap[container.Name]
-	if !ok {
-		return errors.New("No container named '" + container.Name + "' created in " + task.Arn)
-	}
+func (engine *DockerTaskEngine) startContainer(ctx context.Context, req *transitionRequest) error {
+	log.Info("Starting container", "task", req.taskDesc, "container", req.containerDesc)
+	return classifyDockerError("start", engine.client.StartContainer(ctx, req.dockerId, req.hostConfig))
+}
 
-	return engine.client.StopContainer(dockerContainer.DockerId)
+func (engine *DockerTaskEngine) stopContainer(ctx context.Context, req *transitionRequest) error {
+	log.Info("Stopping container", "task", req.taskDesc, "container", req.containerDesc)
+	return classifyDockerError("stop", engine.client.StopContainer(ctx, req.dockerId))
 }
 
-func (engine *DockerTaskEngine) RemoveContainer(task *api.Task, container *api.Container) error {
+func (engine *DockerTaskEngine) RemoveContainer(ctx context.Context, task *api.Task, container *api.Container) error {
 	log.Info("Removing container", "task", task, "container", container)
 	containerMap, ok := engine.state.ContainerMapByArn(task.Arn)
 
 	if !ok {
-		return errors.New("No such task: " + task.Arn)
+		return permanentError("remove", errors.New("No such task: "+task.Arn))
 	}
 
 	dockerContainer, ok := containerMap[container.Name]
 	if !ok {
-		return errors.New("No container named '" + container.Name + "' created in " + task.Arn)
+		return permanentError("remove", errors.New("No container named '"+container.Name+"' created in "+task.Arn))
 	}
 
-	return engine.client.RemoveContainer(dockerContainer.DockerId)
+	return classifyDockerError("remove", engine.client.RemoveContainer(ctx, dockerContainer.DockerId))
+}
+
+// DockerQueueStats returns the current depth of the queue of docker
+// operations. The second return value is false if the engine is not
+// initialized.
+func (engine *DockerTaskEngine) DockerQueueStats() (DockerQueueStats, bool) {
+	queue, ok := engine.client.(*dockerWorkQueue)
+	if !ok {
+		return DockerQueueStats{}, false
+	}
+	return queue.Stats(), true
 }
 
 // State is a function primarily meant for testing usage; it is explicitly not
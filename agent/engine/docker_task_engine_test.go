@@ -0,0 +1,735 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/engine/emptyvolume"
+	"github.com/aws/amazon-ecs-agent/agent/engine/pause"
+	"github.com/aws/amazon-ecs-agent/agent/secrets"
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// mockDockerClient is a DockerClient that never talks to docker. Each call
+// succeeds unless the corresponding function is set.
+type mockDockerClient struct {
+	events chan DockerContainerChangeEvent
+
+	pullImage       func(string) error
+	createContainer func(*docker.Config, string) (string, error)
+	startContainer  func(string, *docker.HostConfig) error
+	stopContainer   func(string) error
+	removeContainer func(string) error
+	createVolume    func(string, string, map[string]string) error
+
+	lock           sync.Mutex
+	removed        []string
+	removedVolumes []string
+}
+
+func newMockDockerClient() *mockDockerClient {
+	return &mockDockerClient{events: make(chan DockerContainerChangeEvent)}
+}
+
+func (c *mockDockerClient) ContainerEvents() (<-chan DockerContainerChangeEvent, error) {
+	return c.events, nil
+}
+
+func (c *mockDockerClient) PullImage(ctx context.Context, image string) error {
+	if c.pullImage != nil {
+		return c.pullImage(image)
+	}
+	return nil
+}
+
+func (c *mockDockerClient) CreateContainer(ctx context.Context, config *docker.Config, name string) (string, error) {
+	if c.createContainer != nil {
+		return c.createContainer(config, name)
+	}
+	return name, nil
+}
+
+func (c *mockDockerClient) StartContainer(ctx context.Context, id string, hostConfig *docker.HostConfig) error {
+	if c.startContainer != nil {
+		return c.startContainer(id, hostConfig)
+	}
+	return nil
+}
+
+func (c *mockDockerClient) StopContainer(ctx context.Context, id string) error {
+	if c.stopContainer != nil {
+		return c.stopContainer(id)
+	}
+	return nil
+}
+
+func (c *mockDockerClient) RemoveContainer(ctx context.Context, id string) error {
+	c.lock.Lock()
+	c.removed = append(c.removed, id)
+	c.lock.Unlock()
+	if c.removeContainer != nil {
+		return c.removeContainer(id)
+	}
+	return nil
+}
+
+func (c *mockDockerClient) removedContainers() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.removed...)
+}
+
+func (c *mockDockerClient) CreateVolume(ctx context.Context, name, driver string, driverOpts map[string]string) error {
+	if c.createVolume != nil {
+		return c.createVolume(name, driver, driverOpts)
+	}
+	return nil
+}
+
+func (c *mockDockerClient) RemoveVolume(ctx context.Context, name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removedVolumes = append(c.removedVolumes, name)
+	return nil
+}
+
+func (c *mockDockerClient) volumesRemoved() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.removedVolumes...)
+}
+
+func (c *mockDockerClient) GetContainerName(ctx context.Context, id string) (string, error) {
+	return id, nil
+}
+
+func (c *mockDockerClient) InspectContainer(ctx context.Context, id string) (*docker.Container, error) {
+	return &docker.Container{
+		ID:              id,
+		State:           docker.State{},
+		NetworkSettings: &docker.NetworkSettings{},
+	}, nil
+}
+
+func (c *mockDockerClient) DescribeContainer(ctx context.Context, id string) (api.ContainerStatus, error) {
+	return api.ContainerStatusNone, nil
+}
+
+func (c *mockDockerClient) client() (*docker.Client, error) {
+	return nil, nil
+}
+
+func mockedTaskEngine(t *testing.T, client *mockDockerClient) *DockerTaskEngine {
+	engine := NewDockerTaskEngine(&config.Config{})
+	engine.client = client
+	if err := engine.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func unitTestTask(arn string) *api.Task {
+	return &api.Task{
+		Arn:           arn,
+		Family:        "unit",
+		Version:       "1",
+		DesiredStatus: api.TaskRunning,
+		Containers: []*api.Container{
+			&api.Container{
+				Name:          "c1",
+				Image:         "busybox",
+				Essential:     true,
+				DesiredStatus: api.ContainerRunning,
+			},
+		},
+	}
+}
+
+func expectEvent(t *testing.T, events <-chan api.ContainerStateChange, container api.ContainerStatus, task api.TaskStatus) {
+	select {
+	case event := <-events:
+		if event.Status != container {
+			t.Fatalf("Expected container status %v, got %v", container, event.Status)
+		}
+		if event.TaskStatus != task {
+			t.Fatalf("Expected task status %v, got %v", task, event.TaskStatus)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for container %v event", container)
+	}
+}
+
+func TestManagedTaskLifecycle(t *testing.T) {
+	test_time.LudicrousSpeed(true)
+	defer test_time.LudicrousSpeed(false)
+
+	client := newMockDockerClient()
+	created := make(chan string, 1)
+	started := make(chan string, 1)
+	client.createContainer = func(config *docker.Config, name string) (string, error) {
+		created <- name
+		return name, nil
+	}
+	client.startContainer = func(id string, hostConfig *docker.HostConfig) error {
+		started <- id
+		return nil
+	}
+
+	engine := mockedTaskEngine(t, client)
+	events := engine.TaskEvents()
+
+	task := unitTestTask("lifecycle")
+	engine.AddTask(task)
+
+	id := <-created
+	client.events <- DockerContainerChangeEvent{DockerId: id, Status: api.ContainerCreated}
+	expectEvent(t, events, api.ContainerCreated, api.TaskCreated)
+
+	<-started
+	client.events <- DockerContainerChangeEvent{DockerId: id, Status: api.ContainerRunning}
+	expectEvent(t, events, api.ContainerRunning, api.TaskRunning)
+
+	client.events <- DockerContainerChangeEvent{DockerId: id, Status: api.ContainerStopped}
+	expectEvent(t, events, api.ContainerStopped, api.TaskStopped)
+
+	for i := 0; i < 100; i++ {
+		if _, ok := engine.State().TaskByArn(task.Arn); !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := engine.State().TaskByArn(task.Arn); ok {
+		t.Fatal("Expected task to be removed after cleanup")
+	}
+	removed := client.removedContainers()
+	if len(removed) != 1 || removed[0] != id {
+		t.Errorf("Expected container %v to be removed, got %v", id, removed)
+	}
+}
+
+func TestDesiredStoppedBeforeCreate(t *testing.T) {
+	client := newMockDockerClient()
+	pulling := make(chan struct{})
+	pullDone := make(chan struct{})
+	client.pullImage = func(image string) error {
+		close(pulling)
+		<-pullDone
+		return nil
+	}
+	client.createContainer = func(config *docker.Config, name string) (string, error) {
+		t.Error("Container should not be created after the task was stopped")
+		return name, nil
+	}
+
+	engine := mockedTaskEngine(t, client)
+	events := engine.TaskEvents()
+
+	task := unitTestTask("stopbeforecreate")
+	engine.AddTask(task)
+	<-pulling
+
+	stopped := unitTestTask("stopbeforecreate")
+	stopped.DesiredStatus = api.TaskStopped
+	go engine.AddTask(stopped)
+	// Give the update time to reach the task before the pull completes
+	time.Sleep(50 * time.Millisecond)
+	close(pullDone)
+
+	expectEvent(t, events, api.ContainerStopped, api.TaskStopped)
+}
+
+func TestStopTaskRecordsReason(t *testing.T) {
+	client := newMockDockerClient()
+	pulling := make(chan struct{})
+	pullDone := make(chan struct{})
+	client.pullImage = func(image string) error {
+		close(pulling)
+		<-pullDone
+		return nil
+	}
+
+	engine := mockedTaskEngine(t, client)
+	events := engine.TaskEvents()
+
+	if engine.StopTask("unknown", "reason") {
+		t.Error("Expected stopping an unknown task to fail")
+	}
+
+	task := unitTestTask("stopwithreason")
+	engine.AddTask(task)
+	<-pulling
+
+	stopped := make(chan bool)
+	go func() { stopped <- engine.StopTask(task.Arn, "not wanted") }()
+	time.Sleep(50 * time.Millisecond)
+	close(pullDone)
+	if !<-stopped {
+		t.Error("Expected the task to be stopped")
+	}
+
+	select {
+	case event := <-events:
+		if event.Status != api.ContainerStopped || event.TaskStatus != api.TaskStopped {
+			t.Fatal("Expected the task to stop, got", event)
+		}
+		if event.Reason != "not wanted" || event.Task.StoppedReason != "not wanted" {
+			t.Error("Expected the stop reason to be reported, got", event.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the task to stop")
+	}
+}
+
+func TestPermanentErrorFailsFast(t *testing.T) {
+	test_time.LudicrousSpeed(true)
+	defer test_time.LudicrousSpeed(false)
+
+	client := newMockDockerClient()
+	var lock sync.Mutex
+	pulls := 0
+	client.pullImage = func(image string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		pulls++
+		return docker.ErrNoSuchImage
+	}
+
+	engine := mockedTaskEngine(t, client)
+	events := engine.TaskEvents()
+
+	task := unitTestTask("permanenterror")
+	engine.AddTask(task)
+
+	select {
+	case event := <-events:
+		if event.Status != api.ContainerStopped {
+			t.Fatalf("Expected the container to stop, got %v", event.Status)
+		}
+		if event.Reason != "Docker pull failed: no such image" {
+			t.Errorf("Unexpected reason: %v", event.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the container to stop")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if pulls != 1 {
+		t.Errorf("Expected a single pull attempt, got %v", pulls)
+	}
+}
+
+// dependentTask is a task whose app container depends on a proxy container
+func dependentTask(arn string) *api.Task {
+	task := unitTestTask(arn)
+	task.Containers[0].Name = "app"
+	task.Containers[0].DependsOn = []api.DependsOn{{ContainerName: "proxy", Condition: api.DependencyStart}}
+	task.Containers = append(task.Containers, &api.Container{
+		Name:          "proxy",
+		Image:         "busybox",
+		Essential:     true,
+		DesiredStatus: api.ContainerRunning,
+	})
+	return task
+}
+
+// runDependentTask adds a dependentTask and waits for both of its containers
+// to run
+func runDependentTask(t *testing.T, engine *DockerTaskEngine, client *mockDockerClient, arn string, started <-chan string) *api.Task {
+	events := engine.TaskEvents()
+	task := dependentTask(arn)
+	engine.AddTask(task)
+
+	for {
+		select {
+		case id := <-started:
+			go func() {
+				client.events <- DockerContainerChangeEvent{DockerId: id, Status: api.ContainerRunning}
+			}()
+		case event := <-events:
+			if event.TaskStatus == api.TaskRunning {
+				return task
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the task to run")
+		}
+	}
+}
+
+func TestShutdownInReverseDependencyOrder(t *testing.T) {
+	client := newMockDockerClient()
+	started := make(chan string, 2)
+	stopped := make(chan string, 2)
+	client.startContainer = func(id string, hostConfig *docker.HostConfig) error {
+		started <- id
+		return nil
+	}
+	client.stopContainer = func(id string) error {
+		stopped <- id
+		return nil
+	}
+
+	engine := mockedTaskEngine(t, client)
+	events := engine.TaskEvents()
+	task := runDependentTask(t, engine, client, "reverseorder", started)
+
+	stopTask := dependentTask("reverseorder")
+	stopTask.DesiredStatus = api.TaskStopped
+	engine.AddTask(stopTask)
+
+	containers, _ := engine.State().ContainerMapByArn(task.Arn)
+	first := <-stopped
+	if first != containers["app"].DockerId {
+		t.Fatalf("Expected app to be stopped first, got %v", first)
+	}
+	select {
+	case id := <-stopped:
+		t.Fatalf("Stopped %v before app was known to have stopped", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// App is essential, so the task is reported stopped as soon as it stops
+	client.events <- DockerContainerChangeEvent{DockerId: first, Status: api.ContainerStopped}
+	expectEvent(t, events, api.ContainerStopped, api.TaskStopped)
+	second := <-stopped
+	client.events <- DockerContainerChangeEvent{DockerId: second, Status: api.ContainerStopped}
+	expectEvent(t, events, api.ContainerStopped, api.TaskStatusNone)
+
+	shutdown := task.Shutdown
+	if shutdown == nil || len(shutdown.Containers) != 2 {
+		t.Fatalf("Expected the shutdown of both containers to be recorded, got %+v", shutdown)
+	}
+	if shutdown.Containers[0].Name != "app" || shutdown.Containers[1].Name != "proxy" {
+		t.Errorf("Unexpected shutdown order: %v, %v", shutdown.Containers[0].Name, shutdown.Containers[1].Name)
+	}
+	if shutdown.Containers[1].StopRequestedAt.Before(shutdown.Containers[0].StoppedAt) {
+		t.Error("Proxy was asked to stop before app had stopped")
+	}
+	if shutdown.DeadlineExceeded {
+		t.Error("Deadline should not have been exceeded")
+	}
+}
+
+func TestShutdownDeadlineStopsRemainingContainers(t *testing.T) {
+	client := newMockDockerClient()
+	started := make(chan string, 2)
+	stopped := make(chan string, 2)
+	client.startContainer = func(id string, hostConfig *docker.HostConfig) error {
+		started <- id
+		return nil
+	}
+	client.stopContainer = func(id string) error {
+		stopped <- id
+		return nil
+	}
+
+	engine := mockedTaskEngine(t, client)
+	engine.cfg.TaskStopTimeout = time.Minute
+	task := runDependentTask(t, engine, client, "deadline", started)
+
+	stopTask := dependentTask("deadline")
+	stopTask.DesiredStatus = api.TaskStopped
+	engine.AddTask(stopTask)
+
+	// App never reports that it stopped; proxy is stopped once the deadline
+	// passes regardless
+	<-stopped
+	select {
+	case id := <-stopped:
+		t.Fatalf("Stopped %v before the deadline passed", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	test_time.LudicrousSpeed(true)
+	defer test_time.LudicrousSpeed(false)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the deadline to stop proxy")
+	}
+	if !task.Shutdown.DeadlineExceeded {
+		t.Error("Expected the deadline to be recorded as exceeded")
+	}
+}
+
+func TestTaskScopedDockerVolume(t *testing.T) {
+	test_time.LudicrousSpeed(true)
+	defer test_time.LudicrousSpeed(false)
+
+	client := newMockDockerClient()
+	started := make(chan string, 1)
+	volumes := make(chan string, 1)
+	client.startContainer = func(id string, hostConfig *docker.HostConfig) error {
+		if len(hostConfig.Binds) != 1 || !strings.HasSuffix(hostConfig.Binds[0], ":/data") {
+			t.Errorf("Expected the volume to be bound, got %v", hostConfig.Binds)
+		}
+		started <- id
+		return nil
+	}
+	client.createVolume = func(name, driver string, driverOpts map[string]string) error {
+		if driver != "local" {
+			t.Errorf("Unexpected driver: %v", driver)
+		}
+		volumes <- name
+		return nil
+	}
+
+	engine := mockedTaskEngine(t, client)
+	events := engine.TaskEvents()
+
+	task := unitTestTask("taskvolume")
+	task.Volumes = []api.TaskVolume{{Name: "data", Volume: &api.DockerVolume{Scope: api.DockerVolumeScopeTask, Driver: "local"}}}
+	task.Containers[0].MountPoints = []api.MountPoint{{SourceVolume: "data", ContainerPath: "/data"}}
+	engine.AddTask(task)
+
+	volume := <-volumes
+	if !strings.HasPrefix(volume, "ecs-unit-1-data-") {
+		t.Errorf("Unexpected volume name: %v", volume)
+	}
+	if refs := engine.State().VolumeReferences(volume); len(refs) != 1 || refs[0] != task.Arn {
+		t.Errorf("Expected the task to reference the volume, got %v", refs)
+	}
+
+	id := <-started
+	client.events <- DockerContainerChangeEvent{DockerId: id, Status: api.ContainerRunning}
+	expectEvent(t, events, api.ContainerRunning, api.TaskRunning)
+	client.events <- DockerContainerChangeEvent{DockerId: id, Status: api.ContainerStopped}
+	expectEvent(t, events, api.ContainerStopped, api.TaskStopped)
+
+	for i := 0; i < 100 && len(client.volumesRemoved()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if removed := client.volumesRemoved(); len(removed) != 1 || removed[0] != volume {
+		t.Errorf("Expected volume %v to be removed with the task, got %v", volume, removed)
+	}
+}
+
+func TestSharedDockerVolumeName(t *testing.T) {
+	client := newMockDockerClient()
+	var created []string
+	client.createVolume = func(name, driver string, driverOpts map[string]string) error {
+		created = append(created, name)
+		return nil
+	}
+	engine := mockedTaskEngine(t, client)
+
+	req := &transitionRequest{taskArn: "shared", resourcePrefix: dockerResourcePrefix(unitTestTask("shared"))}
+	volume := &api.DockerVolume{Scope: api.DockerVolumeScopeShared}
+	if err := engine.acquireVolume(context.Background(), req, "my data!", volume); err != nil {
+		t.Fatal(err)
+	}
+	if volume.DockerName != "ecs-shared-mydata" || len(created) != 1 || created[0] != volume.DockerName {
+		t.Errorf("Unexpected shared volume %v, created %v", volume.DockerName, created)
+	}
+
+	err := engine.acquireVolume(context.Background(), req, "!!!", &api.DockerVolume{Scope: api.DockerVolumeScopeShared})
+	if retriable, ok := err.(utils.Retriable); !ok || retriable.Retry() {
+		t.Errorf("Expected a name without safe characters to be a permanent error, got %v", err)
+	}
+	if len(created) != 1 {
+		t.Errorf("Expected no volume to be created, got %v", created)
+	}
+}
+
+func TestEmptyVolumeHostDir(t *testing.T) {
+	testEmptyVolumeHostDir(t, "")
+}
+
+func TestEmptyVolumeHostDirFromHostDataDir(t *testing.T) {
+	// The agent's data directory is mounted from elsewhere on the host
+	testEmptyVolumeHostDir(t, "/var/lib/ecs/data")
+}
+
+// testEmptyVolumeHostDir runs a task with an empty volume provided by a
+// directory, which the agent creates under its data directory and containers
+// bind from under hostDataDir, or the data directory if it is empty
+func testEmptyVolumeHostDir(t *testing.T, hostDataDir string) {
+	test_time.LudicrousSpeed(true)
+	defer test_time.LudicrousSpeed(false)
+
+	dataDir, err := ioutil.TempDir("", "ecs_engine_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	client := newMockDockerClient()
+	started := make(chan *docker.HostConfig, 1)
+	client.startContainer = func(id string, hostConfig *docker.HostConfig) error {
+		started <- hostConfig
+		return nil
+	}
+	client.pullImage = func(image string) error {
+		if image == emptyvolume.Image+":"+emptyvolume.Tag {
+			t.Error("The emptyvolume container should not be used")
+		}
+		return nil
+	}
+
+	engine := mockedTaskEngine(t, client)
+	engine.cfg.EmptyVolumeMode = config.EmptyVolumeModeHostDir
+	engine.cfg.DataDir = dataDir
+	engine.cfg.HostDataDir = hostDataDir
+	events := engine.TaskEvents()
+
+	task := unitTestTask("arn:aws:ecs:us-west-2:123456789012:task/emptyvolume")
+	task.Volumes = []api.TaskVolume{{Name: "scratch", Volume: &api.EmptyHostVolume{}}}
+	task.Containers[0].MountPoints = []api.MountPoint{{SourceVolume: "scratch", ContainerPath: "/scratch"}}
+	engine.AddTask(task)
+
+	if len(task.Containers) != 1 {
+		t.Fatalf("Expected no internal containers, got %v", task.Containers)
+	}
+	hostDir := filepath.Join(dataDir, "emptyvolumes", "emptyvolume", "scratch")
+	bindSource := hostDir
+	if hostDataDir != "" {
+		bindSource = filepath.Join(hostDataDir, "emptyvolumes", "emptyvolume", "scratch")
+	}
+	hostConfig := <-started
+	if len(hostConfig.Binds) != 1 || hostConfig.Binds[0] != bindSource+":/scratch" {
+		t.Errorf("Expected the volume's directory to be bound, got %v", hostConfig.Binds)
+	}
+	if info, err := os.Stat(hostDir); err != nil || !info.IsDir() {
+		t.Errorf("Expected the volume's directory to be created: %v", err)
+	}
+
+	containers, _ := engine.State().ContainerMapByArn(task.Arn)
+	id := containers["c1"].DockerId
+	client.events <- DockerContainerChangeEvent{DockerId: id, Status: api.ContainerRunning}
+	expectEvent(t, events, api.ContainerRunning, api.TaskRunning)
+	client.events <- DockerContainerChangeEvent{DockerId: id, Status: api.ContainerStopped}
+	expectEvent(t, events, api.ContainerStopped, api.TaskStopped)
+
+	for i := 0; i < 100; i++ {
+		if _, ok := engine.State().TaskByArn(task.Arn); !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Dir(hostDir)); !os.IsNotExist(err) {
+		t.Error("Expected the task's empty volume directories to be removed")
+	}
+}
+
+type mapSecretsProvider map[string]string
+
+func (p mapSecretsProvider) GetSecret(name string) (string, error) {
+	value, ok := p[name]
+	if !ok {
+		return "", secrets.NotFoundError{Name: name}
+	}
+	return value, nil
+}
+
+func TestContainerSecrets(t *testing.T) {
+	client := newMockDockerClient()
+	created := make(chan []string, 1)
+	client.createContainer = func(config *docker.Config, name string) (string, error) {
+		created <- config.Env
+		return name, nil
+	}
+	// The task waits on the start, so its state holds still while it is
+	// marshaled
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	client.startContainer = func(id string, hostConfig *docker.HostConfig) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	engine := mockedTaskEngine(t, client)
+	engine.secrets = mapSecretsProvider{"db-password": "hunter2"}
+
+	task := unitTestTask("secrets")
+	task.Containers[0].Secrets = []api.Secret{{Name: "DB_PASSWORD", ValueFrom: "db-password"}}
+	engine.AddTask(task)
+
+	env := <-created
+	found := false
+	for _, entry := range env {
+		found = found || entry == "DB_PASSWORD=hunter2"
+	}
+	if !found {
+		t.Errorf("Expected the secret in the container's environment, got %v", env)
+	}
+
+	<-started
+	saved, err := json.Marshal(engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "hunter2") {
+		t.Error("The secret's value must not be saved")
+	}
+	if !strings.Contains(string(saved), "db-password") {
+		t.Error("Expected the secret's name to be saved")
+	}
+}
+
+func TestAssignPauseImage(t *testing.T) {
+	for _, test := range []struct{ configured, expected string }{
+		{"", pause.Image + ":" + pause.Tag},
+		{"registry.example.com/pause:1.0", "registry.example.com/pause:1.0"},
+	} {
+		engine := NewDockerTaskEngine(&config.Config{PauseContainerImage: test.configured})
+		task := &api.Task{Arn: "arn", NetworkMode: api.NetworkModeShared, Containers: []*api.Container{{Name: "c1"}}}
+		task.PostUnmarshalTask()
+		engine.assignPauseImage(task)
+
+		pauseContainer, ok := task.PauseContainer()
+		if !ok || pauseContainer.Image != test.expected {
+			t.Errorf("Expected the pause container to run %s, got %v", test.expected, pauseContainer)
+		}
+	}
+}
+
+func TestTransitionRetriesStopWhenCancelled(t *testing.T) {
+	engine := NewDockerTaskEngine(&config.Config{})
+	engine.SetRetryPolicy(api.ContainerRunning, RetryPolicy{Attempts: 5, MinBackoff: time.Hour, MaxBackoff: time.Hour, Multiplier: 1})
+	req := &transitionRequest{taskArn: "cancelled", containerName: "c1", nextState: api.ContainerRunning}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	done := make(chan error)
+	go func() {
+		done <- engine.tryApplyTransition(ctx, req, func(context.Context, *transitionRequest) error {
+			attempts++
+			return errors.New("start failed")
+		})
+	}()
+	// The task is stopped while the transition backs off
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled || attempts > 1 {
+			t.Errorf("Expected the retries to stop, got %v after %v attempts", err, attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the transition to stop backing off once cancelled")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerVolumeAPIVersion is the first version of the docker remote API with
+// named volumes. The docker client library in use predates them, so volume
+// requests are made directly.
+const dockerVolumeAPIVersion = "1.21"
+
+// dockerVolumeIdleTimeout is how long an idle connection for volume requests
+// is kept open
+const dockerVolumeIdleTimeout = 30 * time.Second
+
+type createVolumeRequest struct {
+	Name       string
+	Driver     string            `json:",omitempty"`
+	DriverOpts map[string]string `json:",omitempty"`
+}
+
+// CreateVolume creates a docker named volume. Creating a volume that already
+// exists with the same driver succeeds.
+func (dg *DockerGoClient) CreateVolume(ctx context.Context, name, driver string, driverOpts map[string]string) error {
+	return callWithTimeout(ctx, "create volume", createVolumeTimeout, func() error {
+		return dg.volumeRequest("POST", "/volumes/create", &createVolumeRequest{
+			Name:       name,
+			Driver:     driver,
+			DriverOpts: driverOpts,
+		})
+	})
+}
+
+// RemoveVolume removes a docker named volume. Removing a volume that does not
+// exist succeeds.
+func (dg *DockerGoClient) RemoveVolume(ctx context.Context, name string) error {
+	return callWithTimeout(ctx, "remove volume", removeVolumeTimeout, func() error {
+		err := dg.volumeRequest("DELETE", "/volumes/"+url.PathEscape(name), nil)
+		if dockerErr, ok := err.(*docker.Error); ok && dockerErr.Status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// newDockerVolumeClient returns the HTTP client and base URL of the volume
+// endpoints of the docker daemon at endpoint. The endpoint is interpreted as
+// the docker client interprets it, and tlsConfig is the docker client's.
+// Docker is never reached through a proxy, even one set in the environment.
+func newDockerVolumeClient(endpoint string, tlsConfig *tls.Config) (*http.Client, string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		IdleConnTimeout: dockerVolumeIdleTimeout,
+	}
+	var baseURL string
+	switch endpointURL.Scheme {
+	case "unix":
+		socket := endpointURL.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://docker"
+	case "tcp":
+		// As the docker client does, the TLS port means https
+		if endpointURL.Port() == "2376" {
+			baseURL = "https://" + endpointURL.Host
+		} else {
+			baseURL = "http://" + endpointURL.Host
+		}
+	case "http", "https":
+		baseURL = endpointURL.Scheme + "://" + endpointURL.Host
+	default:
+		return nil, "", errors.New("Unsupported docker endpoint: " + endpoint)
+	}
+	return &http.Client{Transport: transport}, baseURL, nil
+}
+
+// volumeRequest makes a request to the volume endpoints of the docker remote
+// API. Failed requests return a *docker.Error so that they are classified
+// like any other docker error.
+func (dg *DockerGoClient) volumeRequest(method, path string, body interface{}) error {
+	if dg.volumeClient == nil {
+		return errors.New("Docker volume client is not initialized")
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, dg.volumeBaseURL+"/v"+dockerVolumeAPIVersion+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := dg.volumeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return &docker.Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	return nil
+}
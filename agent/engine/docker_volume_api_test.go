@@ -0,0 +1,85 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestDockerVolumeRequests(t *testing.T) {
+	var created createVolumeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1.21/volumes/create":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "DELETE" && r.URL.EscapedPath() == "/v1.21/volumes/missing%20volume":
+			http.Error(w, "no such volume", http.StatusNotFound)
+		case r.Method == "DELETE" && r.URL.Path == "/v1.21/volumes/inuse":
+			http.Error(w, "volume is in use", http.StatusConflict)
+		default:
+			t.Errorf("Unexpected request: %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	client := &DockerGoClient{}
+	var err error
+	client.volumeClient, client.volumeBaseURL, err = newDockerVolumeClient("tcp://"+strings.TrimPrefix(server.URL, "http://"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.CreateVolume(context.Background(), "data", "local", map[string]string{"type": "tmpfs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "data" || created.Driver != "local" || created.DriverOpts["type"] != "tmpfs" {
+		t.Errorf("Unexpected create request: %+v", created)
+	}
+
+	if err = client.RemoveVolume(context.Background(), "missing volume"); err != nil {
+		t.Errorf("Removing a missing volume should succeed, got %v", err)
+	}
+
+	err = client.RemoveVolume(context.Background(), "inuse")
+	dockerErr, ok := err.(*docker.Error)
+	if !ok || dockerErr.Status != http.StatusConflict {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+}
+
+func TestDockerVolumeBaseURL(t *testing.T) {
+	for endpoint, expected := range map[string]string{
+		"unix:///var/run/docker.sock": "http://docker",
+		"tcp://10.0.0.1:2375":         "http://10.0.0.1:2375",
+		"tcp://10.0.0.1:2376":         "https://10.0.0.1:2376",
+		"https://docker:443":          "https://docker:443",
+	} {
+		_, baseURL, err := newDockerVolumeClient(endpoint, nil)
+		if err != nil || baseURL != expected {
+			t.Errorf("Expected %v to be reached at %v, got %v (%v)", endpoint, expected, baseURL, err)
+		}
+	}
+	if _, _, err := newDockerVolumeClient("ftp://docker", nil); err == nil {
+		t.Error("Expected an unsupported endpoint to be an error")
+	}
+}
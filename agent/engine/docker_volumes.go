@@ -0,0 +1,149 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/engine/emptyvolume"
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+)
+
+// dockerResourcePrefix begins the names of the docker resources, such as
+// containers and volumes, belonging to the given task
+func dockerResourcePrefix(task *api.Task) string {
+	return "ecs-" + task.Family + "-" + task.Version + "-"
+}
+
+// dockerResourceName returns a unique name for a docker resource whose name
+// begins with prefix
+func dockerResourceName(prefix, name string) string {
+	return prefix + dockerSafeName(name) + "-" + utils.RandHex()
+}
+
+// sharedVolumePrefix begins the names of shared docker volumes, so that the
+// agent only ever creates, and removes, volumes it owns
+const sharedVolumePrefix = "ecs-shared-"
+
+// dockerSafeName strips every character docker does not allow in names
+func dockerSafeName(name string) string {
+	safe := ""
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !((c <= '9' && c >= '0') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c == '-')) {
+			continue
+		}
+		safe += string(c)
+	}
+	return safe
+}
+
+// acquireVolumes makes sure the docker volumes a container being created
+// mounts exist, and records that its task uses them
+func (engine *DockerTaskEngine) acquireVolumes(ctx context.Context, req *transitionRequest) error {
+	for _, taskVolume := range req.volumes {
+		switch volume := taskVolume.Volume.(type) {
+		case *api.DockerVolume:
+			if err := engine.acquireVolume(ctx, req, taskVolume.Name, volume); err != nil {
+				return err
+			}
+		case *api.EmptyHostVolume:
+			if volume.ManagedPath == "" {
+				// Provided by the emptyvolume container
+				continue
+			}
+			sizeLimit := engine.cfg.EmptyVolumeSizeLimit * 1024 * 1024
+			err := emptyvolume.CreateHostDir(volume.ManagedPath, sizeLimit)
+			if err == emptyvolume.ErrQuotaUnsupported {
+				log.Warn("Empty volume size limit not applied; the filesystem does not support quotas", "task", req.taskDesc, "path", volume.ManagedPath)
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// assignEmptyVolumeHostDirs chooses the agent-managed directories that back
+// the empty volumes of a new task, if empty volumes are configured to be
+// provided that way. It must be called before the task's internal containers
+// are added. The agent creates and removes them under DataDir; containers
+// bind them from under HostDataDir, where the host has DataDir.
+func (engine *DockerTaskEngine) assignEmptyVolumeHostDirs(task *api.Task) {
+	if engine.cfg.EmptyVolumeMode != config.EmptyVolumeModeHostDir {
+		return
+	}
+	hostDataDir := utils.DefaultIfBlank(engine.cfg.HostDataDir, engine.cfg.DataDir)
+	for _, taskVolume := range task.Volumes {
+		if empty, ok := taskVolume.Volume.(*api.EmptyHostVolume); ok && empty.ManagedPath == "" {
+			empty.ManagedPath = emptyvolume.HostDir(engine.cfg.DataDir, task.Arn, taskVolume.Name)
+			empty.ManagedHostPath = emptyvolume.HostDir(hostDataDir, task.Arn, taskVolume.Name)
+		}
+	}
+}
+
+func (engine *DockerTaskEngine) acquireVolume(ctx context.Context, req *transitionRequest, name string, volume *api.DockerVolume) error {
+	engine.volumeLock.Lock()
+	defer engine.volumeLock.Unlock()
+
+	if volume.DockerName == "" {
+		if volume.Scope == api.DockerVolumeScopeShared {
+			safeName := dockerSafeName(name)
+			if safeName == "" {
+				return permanentError("create volume", errors.New("Shared volume name "+strconv.Quote(name)+" has no characters docker allows"))
+			}
+			volume.DockerName = sharedVolumePrefix + safeName
+		} else {
+			volume.DockerName = dockerResourceName(req.resourcePrefix, name)
+		}
+	}
+
+	if len(engine.state.VolumeReferences(volume.DockerName)) == 0 {
+		log.Info("Creating docker volume", "task", req.taskDesc, "volume", volume.DockerName, "driver", volume.Driver)
+		err := engine.client.CreateVolume(ctx, volume.DockerName, volume.Driver, volume.DriverOpts)
+		if err != nil {
+			return classifyDockerError("create volume", err)
+		}
+	}
+	engine.state.AddVolumeReference(volume.DockerName, req.taskArn)
+	return nil
+}
+
+// releaseVolumes removes the task's empty volume directories, drops its
+// references to docker volumes, and removes the docker volumes that are no
+// longer used by any task
+func (engine *DockerTaskEngine) releaseVolumes(task *api.Task) {
+	for _, taskVolume := range task.Volumes {
+		if empty, ok := taskVolume.Volume.(*api.EmptyHostVolume); ok && empty.ManagedPath != "" {
+			if err := emptyvolume.RemoveHostDir(empty.ManagedPath); err != nil {
+				log.Warn("Unable to remove empty volume directory", "path", empty.ManagedPath, "err", err)
+			}
+		}
+	}
+
+	engine.volumeLock.Lock()
+	defer engine.volumeLock.Unlock()
+
+	for _, name := range engine.state.RemoveVolumeReferences(task.Arn) {
+		log.Info("Removing docker volume", "task", task, "volume", name)
+		err := engine.client.RemoveVolume(context.Background(), name)
+		if err != nil {
+			log.Warn("Unable to remove docker volume", "volume", name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerPriority orders queued docker operations; higher priorities are
+// always dispatched first.
+type dockerPriority int
+
+const (
+	priorityLow dockerPriority = iota
+	priorityNormal
+	priorityHigh
+
+	numPriorities
+)
+
+// dockerOperation is a single call to docker waiting in an operationQueue
+type dockerOperation struct {
+	name     string
+	priority dockerPriority
+	ctx      context.Context
+	run      func() error
+	result   chan error
+}
+
+// DockerQueueStats is a snapshot of the depth of a dockerWorkQueue
+type DockerQueueStats struct {
+	// Concurrency is the maximum number of operations, other than pulls, that
+	// may run at once
+	Concurrency int
+	// Running is the number of operations, other than pulls, currently running
+	Running int
+	// Queued is the number of operations waiting to run, keyed by operation
+	Queued map[string]int
+
+	// PullConcurrency is the maximum number of pulls that may run at once
+	PullConcurrency int
+	// PullsRunning is the number of pulls currently running
+	PullsRunning int
+	// PullsQueued is the number of pulls waiting to run
+	PullsQueued int
+}
+
+// operationQueue runs queued operations, highest priority first, with at most
+// a fixed number running at once
+type operationQueue struct {
+	concurrency int
+	workers     utils.Semaphore
+
+	lock    sync.Mutex // guards the fields below
+	pending [numPriorities][]*dockerOperation
+	running int
+
+	// ready is signaled whenever an operation is added to pending
+	ready chan struct{}
+}
+
+func newOperationQueue(concurrency int) *operationQueue {
+	queue := &operationQueue{
+		concurrency: concurrency,
+		workers:     utils.NewSemaphore(concurrency),
+		ready:       make(chan struct{}, 1),
+	}
+	go queue.dispatch()
+	return queue
+}
+
+// dispatch runs queued operations whenever a worker is available. It never
+// returns.
+func (queue *operationQueue) dispatch() {
+	for {
+		queue.workers.Wait()
+		op := queue.next()
+		go func() {
+			defer func() {
+				queue.lock.Lock()
+				queue.running--
+				queue.lock.Unlock()
+				queue.workers.Post()
+			}()
+			if err := op.ctx.Err(); err != nil {
+				// Cancelled while it was queued
+				op.result <- err
+				return
+			}
+			op.result <- op.run()
+		}()
+	}
+}
+
+// next blocks until an operation is pending and removes the highest priority
+// one from the queue
+func (queue *operationQueue) next() *dockerOperation {
+	for {
+		queue.lock.Lock()
+		for priority := numPriorities - 1; priority >= 0; priority-- {
+			if len(queue.pending[priority]) > 0 {
+				op := queue.pending[priority][0]
+				queue.pending[priority] = queue.pending[priority][1:]
+				queue.running++
+				queue.lock.Unlock()
+				return op
+			}
+		}
+		queue.lock.Unlock()
+		<-queue.ready
+	}
+}
+
+// do queues an operation and waits for its result. If ctx is done first, its
+// error is returned; an operation that has not started yet will then be
+// skipped.
+func (queue *operationQueue) do(ctx context.Context, name string, priority dockerPriority, run func() error) error {
+	op := &dockerOperation{
+		name:     name,
+		priority: priority,
+		ctx:      ctx,
+		run:      run,
+		result:   make(chan error, 1),
+	}
+
+	queue.lock.Lock()
+	queue.pending[priority] = append(queue.pending[priority], op)
+	queued := 0
+	for _, ops := range queue.pending {
+		queued += len(ops)
+	}
+	running := queue.running
+	queue.lock.Unlock()
+
+	if running >= queue.concurrency {
+		log.Debug("Docker operation queued", "operation", name, "queued", queued, "running", running)
+	}
+
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+
+	select {
+	case err := <-op.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stats returns the number of operations running and, by name, waiting to run
+func (queue *operationQueue) stats() (int, map[string]int) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	queued := make(map[string]int)
+	for _, ops := range queue.pending {
+		for _, op := range ops {
+			queued[op.name]++
+		}
+	}
+	return queue.running, queued
+}
+
+// dockerWorkQueue is a DockerClient that bounds the number of concurrent
+// calls made to the DockerClient it wraps. Calls are queued and dispatched in
+// priority order: stops go before anything else and removes go last. Pulls
+// are throttled separately so that long pulls do not hold up the other
+// operations. Timeouts are left to the wrapped client; a call holds its slot
+// until the wrapped client returns.
+type dockerWorkQueue struct {
+	DockerClient
+
+	operations *operationQueue
+	pulls      *operationQueue
+}
+
+func newDockerWorkQueue(client DockerClient, concurrency, pullConcurrency int) *dockerWorkQueue {
+	if concurrency <= 0 {
+		concurrency = config.DEFAULT_DOCKER_CONCURRENCY
+	}
+	if pullConcurrency <= 0 {
+		pullConcurrency = config.DEFAULT_DOCKER_PULL_CONCURRENCY
+	}
+	return &dockerWorkQueue{
+		DockerClient: client,
+		operations:   newOperationQueue(concurrency),
+		pulls:        newOperationQueue(pullConcurrency),
+	}
+}
+
+// Stats returns the current depth of the queue
+func (queue *dockerWorkQueue) Stats() DockerQueueStats {
+	running, queued := queue.operations.stats()
+	pullsRunning, pullsQueued := queue.pulls.stats()
+	return DockerQueueStats{
+		Concurrency:     queue.operations.concurrency,
+		Running:         running,
+		Queued:          queued,
+		PullConcurrency: queue.pulls.concurrency,
+		PullsRunning:    pullsRunning,
+		PullsQueued:     pullsQueued["pull"],
+	}
+}
+
+func (queue *dockerWorkQueue) PullImage(ctx context.Context, image string) error {
+	return queue.pulls.do(ctx, "pull", priorityNormal, func() error {
+		return queue.DockerClient.PullImage(ctx, image)
+	})
+}
+
+func (queue *dockerWorkQueue) CreateContainer(ctx context.Context, config *docker.Config, name string) (string, error) {
+	var id string
+	err := queue.operations.do(ctx, "create", priorityNormal, func() error {
+		var err error
+		id, err = queue.DockerClient.CreateContainer(ctx, config, name)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (queue *dockerWorkQueue) StartContainer(ctx context.Context, id string, hostConfig *docker.HostConfig) error {
+	return queue.operations.do(ctx, "start", priorityNormal, func() error {
+		return queue.DockerClient.StartContainer(ctx, id, hostConfig)
+	})
+}
+
+func (queue *dockerWorkQueue) StopContainer(ctx context.Context, id string) error {
+	return queue.operations.do(ctx, "stop", priorityHigh, func() error {
+		return queue.DockerClient.StopContainer(ctx, id)
+	})
+}
+
+func (queue *dockerWorkQueue) RemoveContainer(ctx context.Context, id string) error {
+	return queue.operations.do(ctx, "remove", priorityLow, func() error {
+		return queue.DockerClient.RemoveContainer(ctx, id)
+	})
+}
+
+func (queue *dockerWorkQueue) CreateVolume(ctx context.Context, name, driver string, driverOpts map[string]string) error {
+	return queue.operations.do(ctx, "create volume", priorityNormal, func() error {
+		return queue.DockerClient.CreateVolume(ctx, name, driver, driverOpts)
+	})
+}
+
+func (queue *dockerWorkQueue) RemoveVolume(ctx context.Context, name string) error {
+	return queue.operations.do(ctx, "remove volume", priorityLow, func() error {
+		return queue.DockerClient.RemoveVolume(ctx, name)
+	})
+}
+
+func (queue *dockerWorkQueue) GetContainerName(ctx context.Context, id string) (string, error) {
+	var name string
+	err := queue.operations.do(ctx, "inspect", priorityNormal, func() error {
+		var err error
+		name, err = queue.DockerClient.GetContainerName(ctx, id)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (queue *dockerWorkQueue) InspectContainer(ctx context.Context, id string) (*docker.Container, error) {
+	var container *docker.Container
+	err := queue.operations.do(ctx, "inspect", priorityNormal, func() error {
+		var err error
+		container, err = queue.DockerClient.InspectContainer(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+func (queue *dockerWorkQueue) DescribeContainer(ctx context.Context, id string) (api.ContainerStatus, error) {
+	status := api.ContainerStatusUnknown
+	err := queue.operations.do(ctx, "inspect", priorityNormal, func() error {
+		var err error
+		status, err = queue.DockerClient.DescribeContainer(ctx, id)
+		return err
+	})
+	if err != nil {
+		return api.ContainerStatusUnknown, err
+	}
+	return status, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// waitForStats waits until the queue's stats satisfy the given condition
+func waitForStats(t *testing.T, queue *dockerWorkQueue, condition func(DockerQueueStats) bool) {
+	for i := 0; i < 500; i++ {
+		if condition(queue.Stats()) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for queue stats; stats: %+v", queue.Stats())
+}
+
+func queuedCount(n int) func(DockerQueueStats) bool {
+	return func(stats DockerQueueStats) bool {
+		queued := 0
+		for _, count := range stats.Queued {
+			queued += count
+		}
+		return queued == n
+	}
+}
+
+func TestWorkQueueStopsBeforeStarts(t *testing.T) {
+	client := newMockDockerClient()
+	queue := newDockerWorkQueue(client, 1, 1)
+
+	block := make(chan struct{})
+	var lock sync.Mutex
+	order := []string{}
+	record := func(op string) {
+		lock.Lock()
+		order = append(order, op)
+		lock.Unlock()
+	}
+	client.createContainer = func(config *docker.Config, name string) (string, error) {
+		<-block
+		record("create")
+		return name, nil
+	}
+	client.startContainer = func(id string, hostConfig *docker.HostConfig) error {
+		record("start")
+		return nil
+	}
+	client.stopContainer = func(id string) error {
+		record("stop")
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+
+	// Occupy the only worker, then queue a start followed by a stop
+	run(func() { queue.CreateContainer(context.Background(), &docker.Config{}, "c1") })
+	waitForStats(t, queue, func(stats DockerQueueStats) bool { return stats.Running == 1 })
+	run(func() { queue.StartContainer(context.Background(), "c2", nil) })
+	waitForStats(t, queue, queuedCount(1))
+	run(func() { queue.StopContainer(context.Background(), "c3") })
+	waitForStats(t, queue, queuedCount(2))
+
+	stats := queue.Stats()
+	if stats.Running != 1 || stats.Queued["start"] != 1 || stats.Queued["stop"] != 1 {
+		t.Errorf("Unexpected queue stats: %+v", stats)
+	}
+
+	close(block)
+	wg.Wait()
+
+	expected := []string{"create", "stop", "start"}
+	if len(order) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestWorkQueuePullsThrottledSeparately(t *testing.T) {
+	client := newMockDockerClient()
+	queue := newDockerWorkQueue(client, 1, 1)
+
+	block := make(chan struct{})
+	client.pullImage = func(image string) error {
+		<-block
+		return nil
+	}
+
+	pulled := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			queue.PullImage(context.Background(), "busybox")
+			pulled <- struct{}{}
+		}()
+	}
+
+	waitForStats(t, queue, func(stats DockerQueueStats) bool {
+		return stats.PullsRunning == 1 && stats.PullsQueued == 1
+	})
+
+	// Pulls do not hold up other operations
+	if err := queue.StopContainer(context.Background(), "c1"); err != nil {
+		t.Error(err)
+	}
+
+	close(block)
+	<-pulled
+	<-pulled
+}
+
+func TestWorkQueueCancelledWhileQueued(t *testing.T) {
+	client := newMockDockerClient()
+	queue := newDockerWorkQueue(client, 1, 1)
+
+	block := make(chan struct{})
+	client.createContainer = func(config *docker.Config, name string) (string, error) {
+		<-block
+		return name, nil
+	}
+	client.startContainer = func(id string, hostConfig *docker.HostConfig) error {
+		t.Error("Cancelled start should not have been made")
+		return nil
+	}
+
+	created := make(chan struct{})
+	go func() {
+		queue.CreateContainer(context.Background(), &docker.Config{}, "c1")
+		close(created)
+	}()
+	waitForStats(t, queue, func(stats DockerQueueStats) bool { return stats.Running == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan error)
+	go func() {
+		started <- queue.StartContainer(ctx, "c2", nil)
+	}()
+	waitForStats(t, queue, queuedCount(1))
+
+	cancel()
+	if err := <-started; err != context.Canceled {
+		t.Errorf("Expected the start to be cancelled, got %v", err)
+	}
+
+	close(block)
+	<-created
+	waitForStats(t, queue, func(stats DockerQueueStats) bool { return stats.Running == 0 })
+}
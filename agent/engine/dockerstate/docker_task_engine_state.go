@@ -42,6 +42,7 @@ type DockerTaskEngineState struct {
 	idToTask      map[string]string                          // DockerId -> taskarn
 	taskToId      map[string]map[string]*api.DockerContainer // taskarn -> (containername -> api.DockerContainer)
 	idToContainer map[string]*api.DockerContainer            // DockerId -> api.DockerContainer
+	volumeToTasks map[string][]string                        // docker volume name -> taskarns
 }
 
 func NewDockerTaskEngineState() *DockerTaskEngineState {
@@ -50,6 +51,7 @@ func NewDockerTaskEngineState() *DockerTaskEngineState {
 		idToTask:      make(map[string]string),
 		taskToId:      make(map[string]map[string]*api.DockerContainer),
 		idToContainer: make(map[string]*api.DockerContainer),
+		volumeToTasks: make(map[string][]string),
 	}
 }
 
@@ -179,3 +181,53 @@ func (state *DockerTaskEngineState) AllTasks() []*api.Task {
 	}
 	return ret
 }
+
+// VolumeReferences returns the arns of the tasks using the given docker volume
+func (state *DockerTaskEngineState) VolumeReferences(name string) []string {
+	state.lock.RLock()
+	defer state.lock.RUnlock()
+
+	return append([]string{}, state.volumeToTasks[name]...)
+}
+
+// AddVolumeReference records that a task uses the given docker volume. It
+// does aquire the write lock.
+func (state *DockerTaskEngineState) AddVolumeReference(name string, taskArn string) {
+	state.Lock()
+	defer state.Unlock()
+
+	for _, arn := range state.volumeToTasks[name] {
+		if arn == taskArn {
+			return
+		}
+	}
+	state.volumeToTasks[name] = append(state.volumeToTasks[name], taskArn)
+}
+
+// RemoveVolumeReferences records that a task no longer uses any docker
+// volumes. It returns the names of the volumes that are no longer used by any
+// task; they are no longer tracked. It does aquire the write lock.
+func (state *DockerTaskEngineState) RemoveVolumeReferences(taskArn string) []string {
+	state.Lock()
+	defer state.Unlock()
+
+	unused := []string{}
+	for name, arns := range state.volumeToTasks {
+		remaining := make([]string, 0, len(arns))
+		for _, arn := range arns {
+			if arn != taskArn {
+				remaining = append(remaining, arn)
+			}
+		}
+		if len(remaining) == len(arns) {
+			continue
+		}
+		if len(remaining) == 0 {
+			delete(state.volumeToTasks, name)
+			unused = append(unused, name)
+		} else {
+			state.volumeToTasks[name] = remaining
+		}
+	}
+	return unused
+}
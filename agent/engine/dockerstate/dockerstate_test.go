@@ -14,6 +14,8 @@
 package dockerstate
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 
 	"github.com/aws/amazon-ecs-agent/agent/api"
@@ -57,3 +59,34 @@ func TestAddTask(t *testing.T) {
 		t.Error("Wrong task retrieved")
 	}
 }
+
+func TestVolumeReferences(t *testing.T) {
+	state := NewDockerTaskEngineState()
+	state.AddVolumeReference("shared", "task1")
+	state.AddVolumeReference("shared", "task2")
+	state.AddVolumeReference("shared", "task2")
+	state.AddVolumeReference("scratch", "task1")
+
+	if refs := state.VolumeReferences("shared"); !reflect.DeepEqual(refs, []string{"task1", "task2"}) {
+		t.Error("Wrong references to shared volume: ", refs)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewDockerTaskEngineState()
+	if err = json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	if unused := restored.RemoveVolumeReferences("task1"); !reflect.DeepEqual(unused, []string{"scratch"}) {
+		t.Error("Expected only the scratch volume to be unused, got: ", unused)
+	}
+	if unused := restored.RemoveVolumeReferences("task2"); !reflect.DeepEqual(unused, []string{"shared"}) {
+		t.Error("Expected the shared volume to be unused, got: ", unused)
+	}
+	if refs := restored.VolumeReferences("shared"); len(refs) != 0 {
+		t.Error("Unused volume should not be tracked: ", refs)
+	}
+}
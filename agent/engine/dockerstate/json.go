@@ -16,6 +16,8 @@ package dockerstate
 import (
 	"encoding/json"
 	"errors"
+	"sort"
+	"strconv"
 
 	"github.com/aws/amazon-ecs-agent/agent/api"
 )
@@ -26,6 +28,7 @@ type savedState struct {
 	Tasks         []*api.Task
 	IdToContainer map[string]*api.DockerContainer // DockerId -> api.DockerContainer
 	IdToTask      map[string]string               // DockerId -> taskarn
+	VolumeToTasks map[string][]string             `json:",omitempty"` // docker volume name -> taskarns
 }
 
 func (state *DockerTaskEngineState) MarshalJSON() ([]byte, error) {
@@ -38,6 +41,7 @@ func (state *DockerTaskEngineState) MarshalJSON() ([]byte, error) {
 			Tasks:         state.AllTasks(),
 			IdToContainer: state.idToContainer,
 			IdToTask:      state.idToTask,
+			VolumeToTasks: state.volumeToTasks,
 		}
 	}()
 	return json.Marshal(toSave)
@@ -59,26 +63,120 @@ func (state *DockerTaskEngineState) UnmarshalJSON(data []byte) error {
 		clean.AddOrUpdateTask(task)
 	}
 	for id, container := range saved.IdToContainer {
-		taskArn, ok := saved.IdToTask[id]
-		if !ok {
-			return errors.New("Could not unmarshal state; incomplete save. There was no task for docker id " + id)
+		if err := clean.addSavedContainer(id, container, saved.IdToTask); err != nil {
+			return err
 		}
-		task, ok := clean.TaskByArn(taskArn)
-		if !ok {
-			return errors.New("Could not unmarshal state; incomplete save. There was no task for arn " + taskArn)
+	}
+
+	for name, arns := range saved.VolumeToTasks {
+		clean.volumeToTasks[name] = arns
+	}
+
+	state.replace(clean)
+	return nil
+}
+
+// lenientSavedState is savedState with each task and container left raw, so
+// that they can be decoded one at a time
+type lenientSavedState struct {
+	Tasks         []json.RawMessage
+	IdToContainer map[string]json.RawMessage
+	IdToTask      map[string]string
+	VolumeToTasks map[string][]string
+}
+
+// UnmarshalJSONLenient restores what decodes of previously marshaled state.
+// Each task or container that does not decode, or does not resolve, is
+// skipped and described in the returned list.
+func (state *DockerTaskEngineState) UnmarshalJSONLenient(data []byte) ([]string, error) {
+	var saved lenientSavedState
+	err := json.Unmarshal(data, &saved)
+	if err != nil {
+		return nil, err
+	}
+	clean := NewDockerTaskEngineState()
+
+	var skipped []string
+	for i, rawTask := range saved.Tasks {
+		var task api.Task
+		if err := json.Unmarshal(rawTask, &task); err != nil {
+			skipped = append(skipped, "task "+savedTaskName(i, rawTask)+": "+err.Error())
+			continue
 		}
+		if task.Arn == "" {
+			skipped = append(skipped, "task "+savedTaskName(i, rawTask)+": has no Arn")
+			continue
+		}
+		clean.AddOrUpdateTask(&task)
+	}
 
-		// The container.Container pointers *must* match the task's container
-		// pointers for things to operate correctly; update them here
-		taskContainer, ok := task.ContainerByName(container.Container.Name)
-		if !ok {
-			return errors.New("Could not resolve a container into a task based on name: " + task.String() + " -- " + container.String())
+	ids := make([]string, 0, len(saved.IdToContainer))
+	for id := range saved.IdToContainer {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		var container api.DockerContainer
+		err := json.Unmarshal(saved.IdToContainer[id], &container)
+		if err == nil {
+			err = clean.addSavedContainer(id, &container, saved.IdToTask)
+		}
+		if err != nil {
+			skipped = append(skipped, "docker container "+id+": "+err.Error())
 		}
-		container.Container = taskContainer
-		//pointer matching now; everyone happy
-		clean.AddContainer(container, task)
 	}
 
-	*state = *clean
+	for name, arns := range saved.VolumeToTasks {
+		clean.volumeToTasks[name] = arns
+	}
+
+	state.replace(clean)
+	return skipped, nil
+}
+
+// addSavedContainer adds a saved container to the task it was saved with
+func (state *DockerTaskEngineState) addSavedContainer(id string, container *api.DockerContainer, idToTask map[string]string) error {
+	taskArn, ok := idToTask[id]
+	if !ok {
+		return errors.New("Could not unmarshal state; incomplete save. There was no task for docker id " + id)
+	}
+	task, ok := state.TaskByArn(taskArn)
+	if !ok {
+		return errors.New("Could not unmarshal state; incomplete save. There was no task for arn " + taskArn)
+	}
+	if container.Container == nil {
+		return errors.New("Could not unmarshal state; incomplete save. There was no container for docker id " + id)
+	}
+
+	// The container.Container pointers *must* match the task's container
+	// pointers for things to operate correctly; update them here
+	taskContainer, ok := task.ContainerByName(container.Container.Name)
+	if !ok {
+		return errors.New("Could not resolve a container into a task based on name: " + task.String() + " -- " + container.String())
+	}
+	container.Container = taskContainer
+	//pointer matching now; everyone happy
+	state.AddContainer(container, task)
 	return nil
 }
+
+// savedTaskName names a saved task by its Arn, if that much of it decodes, or
+// else by its position
+func savedTaskName(i int, rawTask json.RawMessage) string {
+	var arn struct{ Arn string }
+	if json.Unmarshal(rawTask, &arn) == nil && arn.Arn != "" {
+		return arn.Arn
+	}
+	return "#" + strconv.Itoa(i+1)
+}
+
+// replace swaps in the maps of clean, which no other goroutine may reference
+func (state *DockerTaskEngineState) replace(clean *DockerTaskEngineState) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.tasks = clean.tasks
+	state.idToTask = clean.idToTask
+	state.taskToId = clean.taskToId
+	state.idToContainer = clean.idToContainer
+	state.volumeToTasks = clean.volumeToTasks
+}
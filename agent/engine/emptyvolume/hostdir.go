@@ -0,0 +1,76 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package emptyvolume
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hostDirRoot is the directory, under the agent's data directory, holding the
+// directories that back empty volumes
+const hostDirRoot = "emptyvolumes"
+
+// ErrQuotaUnsupported is returned when a size limit cannot be applied to an
+// empty volume's directory because its filesystem does not support quotas
+var ErrQuotaUnsupported = errors.New("emptyvolume: the filesystem does not support directory quotas")
+
+// HostDir returns the directory that backs the named empty volume of a task
+func HostDir(dataDir, taskArn, volume string) string {
+	taskId := taskArn[strings.LastIndex(taskArn, "/")+1:]
+	return filepath.Join(dataDir, hostDirRoot, safeName(taskId), safeName(volume))
+}
+
+// CreateHostDir creates the directory backing an empty volume. If sizeLimit is
+// positive, the directory is limited to that many bytes; if its filesystem
+// does not support that, the directory is still created and
+// ErrQuotaUnsupported is returned.
+func CreateHostDir(path string, sizeLimit int64) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	if sizeLimit <= 0 {
+		return nil
+	}
+	return setQuota(path, sizeLimit)
+}
+
+// RemoveHostDir removes the directory backing an empty volume, and the task's
+// directory once it holds no more volumes
+func RemoveHostDir(path string) error {
+	clearQuota(path)
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	// Fails harmlessly while other volumes of the task remain
+	os.Remove(filepath.Dir(path))
+	return nil
+}
+
+// safeName replaces every character other than letters, numbers, hyphens, and
+// underscores so that the name is a single path element
+func safeName(name string) string {
+	safe := []byte(name)
+	for i, c := range safe {
+		if !((c <= '9' && c >= '0') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '-' || c == '_') {
+			safe[i] = '_'
+		}
+	}
+	if len(safe) == 0 {
+		return "_"
+	}
+	return string(safe)
+}
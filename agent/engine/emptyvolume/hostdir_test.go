@@ -0,0 +1,69 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package emptyvolume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostDir(t *testing.T) {
+	dir := HostDir("/data", "arn:aws:ecs:us-west-2:123456789012:task/6d2b5a5e-7a4d", "cache")
+	if dir != "/data/emptyvolumes/6d2b5a5e-7a4d/cache" {
+		t.Error("Unexpected host dir: ", dir)
+	}
+
+	dir = HostDir("/data", "task/../..", "../etc")
+	if dir != "/data/emptyvolumes/__/___etc" {
+		t.Error("Names should not be able to escape the data directory: ", dir)
+	}
+}
+
+func TestCreateAndRemoveHostDir(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ecs_emptyvolume_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	first := HostDir(dataDir, "task/1", "first")
+	second := HostDir(dataDir, "task/1", "second")
+	for _, dir := range []string{first, second} {
+		if err := CreateHostDir(dir, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(first, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RemoveHostDir(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Error("Expected the volume's directory to be removed")
+	}
+	if _, err := os.Stat(filepath.Dir(first)); err != nil {
+		t.Error("The task's directory should remain while it has volumes")
+	}
+
+	if err := RemoveHostDir(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Dir(second)); !os.IsNotExist(err) {
+		t.Error("Expected the task's directory to be removed with its last volume")
+	}
+}
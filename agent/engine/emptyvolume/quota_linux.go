@@ -0,0 +1,295 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package emptyvolume
+
+import (
+	"bufio"
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Directory size limits are implemented with XFS project quotas: the
+// directory is given its own project id, inherited by everything created in
+// it, and the project is given a block limit.
+const (
+	fsIocFsGetXattr    = 0x801c581f // FS_IOC_FSGETXATTR
+	fsIocFsSetXattr    = 0x401c5820 // FS_IOC_FSSETXATTR
+	fsXflagProjInherit = 0x200      // FS_XFLAG_PROJINHERIT
+
+	qXGetQuota     = 'X'<<8 + 3 // Q_XGETQUOTA
+	qXSetQLim      = 'X'<<8 + 4 // Q_XSETQLIM
+	prjQuota       = 2          // PRJQUOTA
+	fsDquotVersion = 1          // FS_DQUOT_VERSION
+	fsProjQuota    = 2          // FS_PROJ_QUOTA
+	fsDqBSoft      = 1 << 2     // FS_DQ_BSOFT
+	fsDqBHard      = 1 << 3     // FS_DQ_BHARD
+
+	quotaBlockSize = 512
+
+	// projectIdAttempts is how many project ids are tried before giving up
+	// on finding one that is not in use
+	projectIdAttempts = 1000
+)
+
+// projectLock serializes choosing a project id and giving it a limit, so
+// that two directories are never given the same free id
+var projectLock sync.Mutex
+
+// fsxattr is struct fsxattr from linux/fs.h
+type fsxattr struct {
+	XFlags     uint32
+	ExtSize    uint32
+	NExtents   uint32
+	ProjID     uint32
+	CowExtSize uint32
+	Pad        [8]byte
+}
+
+// fsDiskQuota is struct fs_disk_quota from linux/dqblk_xfs.h
+type fsDiskQuota struct {
+	Version      int8
+	Flags        int8
+	FieldMask    uint16
+	ID           uint32
+	BlkHardLimit uint64
+	BlkSoftLimit uint64
+	InoHardLimit uint64
+	InoSoftLimit uint64
+	BCount       uint64
+	ICount       uint64
+	ITimer       int32
+	BTimer       int32
+	IWarns       uint16
+	BWarns       uint16
+	Padding2     int32
+	RtbHardLimit uint64
+	RtbSoftLimit uint64
+	RtbCount     uint64
+	RtbTimer     int32
+	RtbWarns     uint16
+	Padding3     int16
+	Padding4     [8]byte
+}
+
+func setQuota(path string, sizeLimit int64) error {
+	device, err := xfsDevice(path)
+	if err != nil {
+		return err
+	}
+
+	projectLock.Lock()
+	defer projectLock.Unlock()
+
+	attr, err := projectAttr(path)
+	if err != nil {
+		return quotaError(err)
+	}
+	// A directory keeps its project across agent restarts, unless the
+	// project was inherited from its parent
+	projectId := attr.ProjID
+	if parentAttr, err := projectAttr(filepath.Dir(path)); err == nil && parentAttr.ProjID == projectId {
+		projectId = 0
+	}
+	if projectId == 0 {
+		projectId, err = allocateProjectId(path, func(id uint32) (bool, error) {
+			return projectInUse(device, id)
+		})
+		if err != nil {
+			return quotaError(err)
+		}
+		if err := setProjectId(path, projectId); err != nil {
+			return quotaError(err)
+		}
+	}
+	blocks := uint64(sizeLimit+quotaBlockSize-1) / quotaBlockSize
+	return quotaError(setProjectLimit(device, projectId, blocks))
+}
+
+// allocateProjectId returns a project id that is not in use. Ids are tried
+// starting from one derived from the path, so that a directory usually gets
+// the same id, and then in order.
+func allocateProjectId(path string, inUse func(uint32) (bool, error)) (uint32, error) {
+	hash := fnv.New32a()
+	hash.Write([]byte(path))
+	projectId := hash.Sum32() & 0x7fffffff
+	for i := 0; i < projectIdAttempts; i++ {
+		if projectId == 0 {
+			projectId = 1
+		}
+		used, err := inUse(projectId)
+		if err != nil {
+			return 0, err
+		}
+		if !used {
+			return projectId, nil
+		}
+		projectId = (projectId + 1) & 0x7fffffff
+	}
+	return 0, errors.New("emptyvolume: no free project id found for " + path)
+}
+
+// quotaError returns ErrQuotaUnsupported for the errors the kernel returns
+// when the filesystem, or the way it is mounted, does not support project
+// quotas
+func quotaError(err error) error {
+	switch err {
+	case syscall.ENOTTY, syscall.EOPNOTSUPP, syscall.ESRCH, syscall.ENOSYS, syscall.EINVAL:
+		return ErrQuotaUnsupported
+	}
+	return err
+}
+
+func clearQuota(path string) {
+	device, err := xfsDevice(path)
+	if err != nil {
+		return
+	}
+	projectLock.Lock()
+	defer projectLock.Unlock()
+
+	attr, err := projectAttr(path)
+	if err != nil || attr.ProjID == 0 {
+		return
+	}
+	setProjectLimit(device, attr.ProjID, 0)
+}
+
+func projectAttr(path string) (*fsxattr, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	var attr fsxattr
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dir.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &attr, nil
+}
+
+func setProjectId(path string, projectId uint32) error {
+	attr, err := projectAttr(path)
+	if err != nil {
+		return err
+	}
+	attr.ProjID = projectId
+	attr.XFlags |= fsXflagProjInherit
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dir.Fd(), fsIocFsSetXattr, uintptr(unsafe.Pointer(attr)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// projectInUse returns whether a project has a limit or uses any space
+func projectInUse(device string, projectId uint32) (bool, error) {
+	var quota fsDiskQuota
+	devicePtr, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return false, err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, uintptr(qXGetQuota<<8|prjQuota), uintptr(unsafe.Pointer(devicePtr)), uintptr(projectId), uintptr(unsafe.Pointer(&quota)), 0, 0)
+	if errno == syscall.ENOENT {
+		return false, nil
+	}
+	if errno != 0 {
+		return false, errno
+	}
+	return quota.BlkHardLimit != 0 || quota.BlkSoftLimit != 0 || quota.BCount != 0 || quota.ICount != 0, nil
+}
+
+// setProjectLimit sets the block limit of a project; a limit of 0 removes it
+func setProjectLimit(device string, projectId uint32, blocks uint64) error {
+	quota := fsDiskQuota{
+		Version:      fsDquotVersion,
+		Flags:        fsProjQuota,
+		FieldMask:    fsDqBSoft | fsDqBHard,
+		ID:           projectId,
+		BlkHardLimit: blocks,
+		BlkSoftLimit: blocks,
+	}
+	devicePtr, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, uintptr(qXSetQLim<<8|prjQuota), uintptr(unsafe.Pointer(devicePtr)), uintptr(projectId), uintptr(unsafe.Pointer(&quota)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// xfsDevice returns the block device of the XFS filesystem holding path, or
+// ErrQuotaUnsupported if path is on any other filesystem
+func xfsDevice(path string) (string, error) {
+	mountinfo, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer mountinfo.Close()
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	fsType, device := mountFor(mountinfo, absPath)
+	if fsType != "xfs" {
+		return "", ErrQuotaUnsupported
+	}
+	return device, nil
+}
+
+// mountFor returns the filesystem type and source of the mount, described in
+// the mountinfo format, that holds the given absolute path
+func mountFor(mountinfo io.Reader, path string) (fsType, source string) {
+	longest := -1
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		// id parent major:minor root mountpoint options [optional...] - type source superoptions
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		mountPoint := strings.Replace(fields[4], "\\040", " ", -1)
+		if mountPoint != "/" && path != mountPoint && !strings.HasPrefix(path, mountPoint+"/") {
+			continue
+		}
+		if len(mountPoint) <= longest {
+			continue
+		}
+		for i := 6; i+2 < len(fields); i++ {
+			if fields[i] == "-" {
+				longest = len(mountPoint)
+				fsType, source = fields[i+1], fields[i+2]
+				break
+			}
+		}
+	}
+	return fsType, source
+}
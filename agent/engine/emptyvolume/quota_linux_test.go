@@ -0,0 +1,87 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package emptyvolume
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+const testMountinfo = `17 1 202:1 / / rw,relatime shared:1 - ext4 /dev/xvda1 rw,data=ordered
+25 17 202:80 / /data rw,relatime shared:8 - xfs /dev/xvdf rw,prjquota
+26 17 0:21 / /data\040backup rw,relatime - xfs /dev/xvdg rw
+27 25 0:22 / /data/tmp rw,relatime - tmpfs tmpfs rw
+`
+
+func TestMountFor(t *testing.T) {
+	testCases := []struct {
+		path   string
+		fsType string
+		source string
+	}{
+		{"/data/emptyvolumes/task/cache", "xfs", "/dev/xvdf"},
+		{"/data", "xfs", "/dev/xvdf"},
+		{"/database", "ext4", "/dev/xvda1"},
+		{"/data backup/x", "xfs", "/dev/xvdg"},
+		{"/data/tmp/x", "tmpfs", "tmpfs"},
+	}
+	for _, testCase := range testCases {
+		fsType, source := mountFor(strings.NewReader(testMountinfo), testCase.path)
+		if fsType != testCase.fsType || source != testCase.source {
+			t.Errorf("Expected %v to be on %v %v, got %v %v", testCase.path, testCase.fsType, testCase.source, fsType, source)
+		}
+	}
+}
+
+func TestAllocateProjectId(t *testing.T) {
+	used := make(map[uint32]bool)
+	inUse := func(id uint32) (bool, error) {
+		return used[id], nil
+	}
+	first, err := allocateProjectId("/data/emptyvolumes/task/a", inUse)
+	if err != nil || first == 0 {
+		t.Fatal("Unexpected project id", first, err)
+	}
+
+	// The same path collides with the id it was given
+	used[first] = true
+	second, err := allocateProjectId("/data/emptyvolumes/task/a", inUse)
+	if err != nil || second == first || second == 0 {
+		t.Error("Expected a different free project id, got", second, err)
+	}
+
+	queryErr := errors.New("query failed")
+	if _, err := allocateProjectId("/data/x", func(uint32) (bool, error) { return false, queryErr }); err != queryErr {
+		t.Error("Expected the query's error, got", err)
+	}
+	if _, err := allocateProjectId("/data/x", func(uint32) (bool, error) { return true, nil }); err == nil {
+		t.Error("Expected an error when every project id is in use")
+	}
+}
+
+func TestQuotaError(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.ENOTTY, syscall.EOPNOTSUPP, syscall.ESRCH, syscall.ENOSYS, syscall.EINVAL} {
+		if quotaError(errno) != ErrQuotaUnsupported {
+			t.Errorf("Expected %v to mean quotas are unsupported", errno)
+		}
+	}
+	if quotaError(syscall.EPERM) != syscall.EPERM {
+		t.Error("Expected other errors to be returned as they are")
+	}
+	if quotaError(nil) != nil {
+		t.Error("Expected no error")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// DockerOperationError is an error from an operation the engine made on a
+// container. Errors that are not retriable are permanent; making the same
+// call again would fail in the same way.
+// Implements Error & Retriable
+type DockerOperationError struct {
+	Operation string
+	Err       error
+	Retriable bool
+}
+
+func (err *DockerOperationError) Error() string {
+	return "Docker " + err.Operation + " failed: " + err.Err.Error()
+}
+
+func (err *DockerOperationError) Retry() bool {
+	return err.Retriable
+}
+
+// permanentError marks an error from the given operation as one that will
+// not go away if the operation is retried
+func permanentError(operation string, err error) *DockerOperationError {
+	return &DockerOperationError{Operation: operation, Err: err, Retriable: false}
+}
+
+// classifyDockerError wraps an error returned by docker for the given
+// operation in a DockerOperationError that records whether retrying could
+// help. Errors that already know whether they are retriable, and
+// cancellations, are returned as they are.
+func classifyDockerError(operation string, err error) error {
+	if err == nil || err == context.Canceled {
+		return err
+	}
+	if _, ok := err.(utils.Retriable); ok {
+		return err
+	}
+
+	retriable := true
+	switch dockerErr := err.(type) {
+	case *docker.NoSuchContainer:
+		retriable = false
+	case *docker.ContainerAlreadyRunning:
+		retriable = false
+	case *docker.ContainerNotRunning:
+		retriable = false
+	case *docker.Error:
+		// Client errors, such as an invalid image name or a missing container,
+		// will not be fixed by asking again. Server errors may be transient.
+		retriable = dockerErr.Status < 400 || dockerErr.Status >= 500
+	default:
+		if err == docker.ErrNoSuchImage {
+			retriable = false
+		}
+	}
+	return &DockerOperationError{Operation: operation, Err: err, Retriable: retriable}
+}
+
+// RetryPolicy describes how an operation that failed with a retriable error
+// is tried again
+type RetryPolicy struct {
+	// Attempts is the maximum number of times the operation is made,
+	// including the first
+	Attempts int
+
+	// MinBackoff and MaxBackoff bound the time waited between attempts. The
+	// wait starts at MinBackoff and is multiplied by Multiplier after each
+	// attempt, with up to Jitter percent added.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+	Multiplier float64
+}
+
+func (policy RetryPolicy) backoff() utils.Backoff {
+	return utils.NewSimpleBackoff(policy.MinBackoff, policy.MaxBackoff, policy.Jitter, policy.Multiplier)
+}
+
+// defaultRetryPolicies are the policies used for each container transition
+// unless they are overridden with SetRetryPolicy
+func defaultRetryPolicies() map[api.ContainerStatus]RetryPolicy {
+	transition := RetryPolicy{
+		Attempts:   3,
+		MinBackoff: 5 * time.Second,
+		MaxBackoff: 30 * time.Second,
+		Jitter:     0.25,
+		Multiplier: 2,
+	}
+	return map[api.ContainerStatus]RetryPolicy{
+		api.ContainerPulled:  transition,
+		api.ContainerCreated: transition,
+		api.ContainerRunning: transition,
+		// Stopping matters more than anything else; try harder and sooner
+		api.ContainerStopped: RetryPolicy{
+			Attempts:   5,
+			MinBackoff: 1 * time.Second,
+			MaxBackoff: 10 * time.Second,
+			Jitter:     0.25,
+			Multiplier: 2,
+		},
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestClassifyDockerError(t *testing.T) {
+	for _, err := range []error{
+		&docker.NoSuchContainer{ID: "id"},
+		&docker.ContainerNotRunning{ID: "id"},
+		docker.ErrNoSuchImage,
+		&docker.Error{Status: 404, Message: "not found"},
+		&docker.Error{Status: 400, Message: "invalid name"},
+	} {
+		classified, ok := classifyDockerError("start", err).(utils.RetriableError)
+		if !ok {
+			t.Fatalf("Expected a retriable error for %v", err)
+		}
+		if classified.Retry() {
+			t.Errorf("Expected %v to be permanent", err)
+		}
+	}
+
+	for _, err := range []error{
+		errors.New("connection reset"),
+		docker.ErrConnectionRefused,
+		&docker.Error{Status: 500, Message: "server error"},
+	} {
+		classified, ok := classifyDockerError("start", err).(utils.RetriableError)
+		if !ok {
+			t.Fatalf("Expected a retriable error for %v", err)
+		}
+		if !classified.Retry() {
+			t.Errorf("Expected %v to be retriable", err)
+		}
+	}
+
+	timeout := &DockerTimeoutError{Operation: "start", Duration: time.Minute}
+	if classifyDockerError("start", timeout) != timeout {
+		t.Error("Timeouts should be returned unchanged")
+	}
+	if classifyDockerError("start", context.Canceled) != context.Canceled {
+		t.Error("Cancellations should be returned unchanged")
+	}
+	if classifyDockerError("start", nil) != nil {
+		t.Error("No error should remain no error")
+	}
+}
+
+func TestDockerOperationErrorMessage(t *testing.T) {
+	err := permanentError("create", errors.New("Link target not available: db"))
+	if err.Error() != "Docker create failed: Link target not available: db" {
+		t.Errorf("Unexpected error message: %v", err.Error())
+	}
+}
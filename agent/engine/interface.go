@@ -26,6 +26,9 @@ type TaskEngine interface {
 	SetSaver(statemanager.Saver)
 
 	AddTask(*api.Task)
+	// StopTask stops the task with the given Arn, recording why, and returns
+	// whether the engine knows the task
+	StopTask(arn, reason string) bool
 
 	ListTasks() ([]*api.Task, error)
 
@@ -0,0 +1,118 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+)
+
+// The types of the state changes the engine journals between saves. They are
+// the changes which, if lost in a crash, lead to duplicate containers or
+// duplicate submissions.
+const (
+	JournalTaskAdded        = "taskAdded"
+	JournalContainerCreated = "containerCreated"
+	JournalStatusSent       = "statusSent"
+)
+
+// TaskAddedEntry records a task new to the engine
+type TaskAddedEntry struct {
+	Task *api.Task
+}
+
+// ContainerCreatedEntry records the docker container created for a container
+type ContainerCreatedEntry struct {
+	TaskArn       string
+	ContainerName string
+	DockerId      string
+	DockerName    string
+}
+
+// StatusSentEntry records the statuses last submitted for a container and
+// its task
+type StatusSentEntry struct {
+	TaskArn             string
+	ContainerName       string
+	ContainerSentStatus api.ContainerStatus
+	TaskSentStatus      api.TaskStatus
+}
+
+// ReplayJournalEntry applies a journaled state change to the engine's loaded
+// state. It must be called before the engine is initialized.
+func (engine *DockerTaskEngine) ReplayJournalEntry(entryType string, data json.RawMessage) error {
+	switch entryType {
+	case JournalTaskAdded:
+		var entry TaskAddedEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		if entry.Task == nil {
+			return errors.New("Journaled task is missing")
+		}
+		if _, ok := engine.state.TaskByArn(entry.Task.Arn); !ok {
+			engine.state.AddOrUpdateTask(entry.Task)
+		}
+	case JournalContainerCreated:
+		var entry ContainerCreatedEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		task, container, ok := engine.journaledContainer(entry.TaskArn, entry.ContainerName)
+		if !ok {
+			return nil
+		}
+		engine.state.Lock()
+		defer engine.state.Unlock()
+		engine.state.AddContainer(&api.DockerContainer{DockerId: entry.DockerId, DockerName: entry.DockerName, Container: container}, task)
+	case JournalStatusSent:
+		var entry StatusSentEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		task, container, ok := engine.journaledContainer(entry.TaskArn, entry.ContainerName)
+		if !ok {
+			return nil
+		}
+		if entry.ContainerSentStatus > container.SentStatus {
+			container.SentStatus = entry.ContainerSentStatus
+		}
+		if entry.TaskSentStatus > task.SentStatus {
+			task.SentStatus = entry.TaskSentStatus
+		}
+	default:
+		log.Warn("Unknown journal entry", "type", entryType)
+	}
+	return nil
+}
+
+// journaledContainer finds the container a journal entry refers to. Entries
+// for tasks that are no longer known, such as ones removed before the last
+// save, are skipped.
+func (engine *DockerTaskEngine) journaledContainer(taskArn, containerName string) (*api.Task, *api.Container, bool) {
+	task, ok := engine.state.TaskByArn(taskArn)
+	if !ok {
+		log.Debug("Skipping journal entry for unknown task", "task", taskArn)
+		return nil, nil, false
+	}
+	for _, container := range task.Containers {
+		if container.Name == containerName {
+			return task, container, true
+		}
+	}
+	log.Warn("Skipping journal entry for unknown container", "task", taskArn, "container", containerName)
+	return nil, nil, false
+}
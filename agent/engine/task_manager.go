@@ -0,0 +1,463 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/engine/dependencygraph"
+	"github.com/aws/amazon-ecs-agent/agent/utils/ttime"
+)
+
+// acsTransition is a message to a managedTask that the backend would like the
+// task to move to a new desired status
+type acsTransition struct {
+	desiredStatus api.TaskStatus
+}
+
+// dockerContainerChange is a message to a managedTask that docker has reported
+// a change for one of its containers
+type dockerContainerChange struct {
+	container *api.DockerContainer
+	event     DockerContainerChangeEvent
+}
+
+// containerTransition is the result of attempting to move a container to the
+// next status
+type containerTransition struct {
+	container *api.Container
+	nextState api.ContainerStatus
+	err       error
+}
+
+// managedTask is a task with a single goroutine, started by overseeTask, that
+// is responsible for every change made to it and its containers. All input
+// arrives through its inbox channels so that transitions are applied one
+// event at a time and in a deterministic order.
+type managedTask struct {
+	*api.Task
+	engine *DockerTaskEngine
+
+	acsMessages    chan acsTransition
+	dockerMessages chan dockerContainerChange
+
+	// done is closed once the task has been cleaned up and its goroutine has
+	// exited; senders select on it so they never block on a dead inbox
+	done chan struct{}
+
+	// shutdownStarted is true once the task has started stopping, and
+	// shutdownDeadline fires when it should stop waiting on dependency order
+	shutdownStarted  bool
+	shutdownDeadline <-chan time.Time
+}
+
+func newManagedTask(engine *DockerTaskEngine, task *api.Task) *managedTask {
+	return &managedTask{
+		Task:           task,
+		engine:         engine,
+		acsMessages:    make(chan acsTransition),
+		dockerMessages: make(chan dockerContainerChange),
+		done:           make(chan struct{}),
+	}
+}
+
+// updateDesiredStatus delivers a new desired status to the task's goroutine.
+// It does nothing if that goroutine has already finished.
+func (mtask *managedTask) updateDesiredStatus(status api.TaskStatus) {
+	select {
+	case mtask.acsMessages <- acsTransition{desiredStatus: status}:
+	case <-mtask.done:
+	}
+}
+
+// handleDockerEvent delivers a docker event to the task's goroutine. It does
+// nothing if that goroutine has already finished.
+func (mtask *managedTask) handleDockerEvent(container *api.DockerContainer, event DockerContainerChangeEvent) {
+	select {
+	case mtask.dockerMessages <- dockerContainerChange{container: container, event: event}:
+	case <-mtask.done:
+	}
+}
+
+// overseeTask is the task's goroutine. It progresses the containers of the
+// task until the task has stopped, waits until it is old enough to be swept,
+// and then removes it.
+func (mtask *managedTask) overseeTask() {
+	defer close(mtask.done)
+	llog := log.New("task", mtask.Task)
+
+	mtask.InferContainerDesiredStatus()
+	if err := dependencygraph.ValidDependencies(mtask.Task); err != nil {
+		llog.Error("Invalid task dependency graph", "err", err)
+		mtask.stopAllContainers(err)
+	}
+
+	// If this was a state restore, resend everything we know. The event
+	// handler discards anything that was already sent.
+	mtask.emitCurrentStatus()
+	mtask.beginShutdown()
+
+	for {
+		if TaskCompleted(mtask.Task) {
+			if mtask.KnownStatus.Terminal() {
+				break
+			}
+			llog.Debug("Task at steady state", "state", mtask.KnownStatus.String())
+			mtask.waitEvent()
+			continue
+		}
+		mtask.progressContainers()
+	}
+
+	llog.Info("Task stopped; waiting to clean it up")
+	mtask.cleanupTask()
+}
+
+// waitEvent blocks until a single message has been read from the inbox and
+// handled.
+func (mtask *managedTask) waitEvent() {
+	select {
+	case acsMessage := <-mtask.acsMessages:
+		mtask.handleDesiredStatusChange(acsMessage.desiredStatus)
+	case dockerChange := <-mtask.dockerMessages:
+		mtask.handleContainerChange(dockerChange)
+	case <-mtask.shutdownDeadline:
+		mtask.handleShutdownDeadline()
+	}
+}
+
+// handleDesiredStatusChange updates the desired status of the task and its
+// containers. Desired status only ever moves forwards.
+func (mtask *managedTask) handleDesiredStatusChange(desiredStatus api.TaskStatus) {
+	if desiredStatus <= mtask.DesiredStatus {
+		log.Debug("Redundant task desired status change", "task", mtask.Task, "desired", desiredStatus.String())
+		return
+	}
+	log.Info("New desired status for task", "task", mtask.Task, "desired", desiredStatus.String())
+	mtask.DesiredStatus = desiredStatus
+	mtask.InferContainerDesiredStatus()
+	mtask.beginShutdown()
+}
+
+// beginShutdown starts recording the shutdown of the task, and arms its
+// deadline, the first time the task is found to be stopping. A shutdown
+// restored from saved state keeps its original deadline.
+func (mtask *managedTask) beginShutdown() {
+	if mtask.shutdownStarted {
+		return
+	}
+	if !mtask.DesiredStatus.Terminal() && !mtask.KnownStatus.Terminal() {
+		return
+	}
+	mtask.shutdownStarted = true
+
+	now := ttime.Now()
+	if mtask.Shutdown == nil {
+		mtask.Shutdown = &api.TaskShutdown{
+			StartedAt: now,
+			Deadline:  now.Add(mtask.engine.taskStopTimeout()),
+		}
+	}
+	if !mtask.Shutdown.DeadlineExceeded {
+		mtask.shutdownDeadline = ttime.After(mtask.Shutdown.Deadline.Sub(now))
+	}
+}
+
+// handleShutdownDeadline stops waiting on dependency order if any container
+// is still running once the task's stop deadline has passed.
+func (mtask *managedTask) handleShutdownDeadline() {
+	mtask.shutdownDeadline = nil
+	for _, cont := range mtask.Containers {
+		if !cont.KnownTerminal() {
+			log.Warn("Task stop deadline passed; stopping remaining containers without regard to dependencies", "task", mtask.Task)
+			mtask.Shutdown.DeadlineExceeded = true
+			return
+		}
+	}
+}
+
+// handleContainerChange updates a container's known status to match what
+// docker reported and emits the change.
+func (mtask *managedTask) handleContainerChange(change dockerContainerChange) {
+	cont := change.container
+	event := change.event
+	if cont.Container.KnownStatus < event.Status {
+		cont.Container.KnownStatus = event.Status
+		mtask.emitEvent(cont, "")
+	} else if cont.Container.KnownStatus == event.Status {
+		log.Warn("Redundant docker event; unusual but not critical", "event", event, "cont", cont)
+	} else {
+		if !cont.Container.KnownTerminal() {
+			log.Crit("Docker container went backwards in state! This container will no longer be managed", "cont", cont, "event", event)
+		}
+	}
+}
+
+// emitEvent emits a change for the container and, if the task stopped as a
+// result, tells every other container to stop as well.
+func (mtask *managedTask) emitEvent(cont *api.DockerContainer, reason string) {
+	wasTerminal := mtask.KnownStatus.Terminal()
+	mtask.engine.emitEvent(mtask.Task, cont, reason)
+	if !wasTerminal && mtask.KnownStatus.Terminal() {
+		mtask.InferContainerDesiredStatus()
+	}
+	mtask.beginShutdown()
+	if mtask.Shutdown != nil && cont.Container.KnownTerminal() {
+		mtask.Shutdown.Stopped(cont.Container.Name, ttime.Now())
+	}
+}
+
+// emitCurrentStatus emits a change for every container that docker knows
+// about.
+func (mtask *managedTask) emitCurrentStatus() {
+	containerMap, ok := mtask.engine.state.ContainerMapByArn(mtask.Arn)
+	if !ok {
+		return
+	}
+	for _, cont := range mtask.Containers {
+		if dockerContainer, ok := containerMap[cont.Name]; ok {
+			mtask.emitEvent(dockerContainer, "")
+		}
+	}
+}
+
+// stopAllContainers records err on every container and moves them all
+// towards being stopped.
+func (mtask *managedTask) stopAllContainers(err error) {
+	for _, cont := range mtask.Containers {
+		if cont.ApplyingError == nil {
+			cont.ApplyingError = api.NewApplyingError(err)
+		}
+		cont.DesiredStatus = api.ContainerStopped
+	}
+}
+
+// containerNextState determines the next status, if any, that a container
+// should be moved to. The second return value is false if the container
+// cannot or need not be moved right now.
+func (mtask *managedTask) containerNextState(container *api.Container) (api.ContainerStatus, bool) {
+	if container.KnownStatus >= container.DesiredStatus {
+		// At or past desired status
+		return api.ContainerStatusNone, false
+	}
+	if container.AppliedStatus >= container.DesiredStatus {
+		// Already working towards desired status; waiting on docker
+		return api.ContainerStatusNone, false
+	}
+	if !dependencygraph.DependenciesAreResolved(container, mtask.Containers) {
+		return api.ContainerStatusNone, false
+	}
+	if container.DesiredTerminal() {
+		// Terminal cases are special. If our desired status is terminal,
+		// then go there with no regard to creating or starting the container,
+		// but only once the containers that depend on this one have stopped.
+		shutdownDeadlineExceeded := mtask.Shutdown != nil && mtask.Shutdown.DeadlineExceeded
+		if !shutdownDeadlineExceeded && !dependencygraph.DependentsAreStopped(container, mtask.Containers) {
+			return api.ContainerStatusNone, false
+		}
+		return api.ContainerStopped, true
+	}
+
+	current := container.KnownStatus
+	if container.AppliedStatus > current {
+		current = container.AppliedStatus
+	}
+	switch {
+	case current < api.ContainerPulled:
+		return api.ContainerPulled, true
+	case current < api.ContainerCreated:
+		return api.ContainerCreated, true
+	case current < api.ContainerRunning:
+		return api.ContainerRunning, true
+	}
+	return api.ContainerStatusNone, false
+}
+
+// progressContainers starts every container transition that can currently be
+// made and waits for all of them to finish. Inbox messages continue to be
+// handled while the transitions run. If no transition can be made, it waits
+// for a single message instead.
+func (mtask *managedTask) progressContainers() {
+	transitions := make(map[string]api.ContainerStatus)
+	cancels := make(map[string]context.CancelFunc)
+	results := make(chan containerTransition, len(mtask.Containers))
+	changed := false
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	for _, cont := range mtask.Containers {
+		if !cont.DesiredTerminal() {
+			if err := dependencygraph.FailedDependency(cont, mtask.Containers); err != nil {
+				log.Warn("Container can not start; a dependency failed", "task", mtask.Task, "container", cont, "err", err)
+				cont.ApplyingError = api.NewApplyingError(err)
+				cont.DesiredStatus = api.ContainerStopped
+			}
+		}
+
+		nextState, ok := mtask.containerNextState(cont)
+		if !ok {
+			continue
+		}
+		if nextState.Terminal() && cont.KnownStatus < api.ContainerCreated && cont.AppliedStatus < api.ContainerCreated {
+			// Never created; there is nothing in docker to stop
+			log.Debug("Container was never created; marking it stopped", "task", mtask.Task, "container", cont)
+			cont.AppliedStatus = api.ContainerStopped
+			cont.KnownStatus = api.ContainerStopped
+			mtask.emitEvent(&api.DockerContainer{Container: cont}, "")
+			changed = true
+			continue
+		}
+		if nextState.Terminal() && mtask.Shutdown != nil {
+			mtask.Shutdown.StopRequested(cont.Name, ttime.Now())
+		}
+
+		log.Info("Transitioning container", "task", mtask.Task, "container", cont, "from", cont.KnownStatus.String(), "to", nextState.String())
+		transitions[cont.Name] = nextState
+		// The request is prepared here so that the transition's goroutine
+		// never reads the task or container while this goroutine changes them
+		req, err := mtask.engine.newTransitionRequest(mtask.Task, cont, nextState)
+		if err != nil {
+			results <- containerTransition{container: cont, nextState: nextState, err: err}
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels[cont.Name] = cancel
+		go func(ctx context.Context, container *api.Container, req *transitionRequest) {
+			err := mtask.engine.applyContainerTransition(ctx, req)
+			results <- containerTransition{container: container, nextState: req.nextState, err: err}
+		}(ctx, cont, req)
+	}
+
+	if len(transitions) == 0 {
+		if !changed {
+			// Nothing can be done until something changes
+			mtask.waitEvent()
+		}
+		return
+	}
+
+	for len(transitions) > 0 {
+		select {
+		case result := <-results:
+			delete(transitions, result.container.Name)
+			mtask.handleContainerTransition(result)
+		case acsMessage := <-mtask.acsMessages:
+			mtask.handleDesiredStatusChange(acsMessage.desiredStatus)
+			if mtask.DesiredStatus.Terminal() {
+				// Anything still working towards running is no longer wanted
+				for name, nextState := range transitions {
+					if !nextState.Terminal() {
+						cancels[name]()
+					}
+				}
+			}
+		case dockerChange := <-mtask.dockerMessages:
+			mtask.handleContainerChange(dockerChange)
+		case <-mtask.shutdownDeadline:
+			mtask.handleShutdownDeadline()
+		}
+	}
+	mtask.engine.saver.Save()
+}
+
+// handleContainerTransition records the result of a container transition.
+func (mtask *managedTask) handleContainerTransition(result containerTransition) {
+	container := result.container
+	clog := log.New("task", mtask.Task, "container", container)
+
+	if result.err == nil {
+		clog.Debug("Successfully applied transition", "to", result.nextState.String())
+		container.AppliedStatus = result.nextState
+		if result.nextState == api.ContainerPulled {
+			// PullImage is a special case; update KnownStatus because there is
+			// no corresponding event from the docker eventstream to update
+			// this with.
+			container.KnownStatus = api.ContainerPulled
+		}
+		return
+	}
+
+	if result.err == context.Canceled {
+		// The transition was abandoned because the container should stop
+		// instead; it will be picked up again from its current status
+		clog.Debug("Transition cancelled", "to", result.nextState.String())
+		return
+	}
+
+	if _, ok := result.err.(*DockerTimeoutError); ok && result.nextState.Terminal() {
+		// The stop may still happen in docker, in which case an event will
+		// arrive for it. Until then, treat the container as still running so
+		// that the stop is tried again.
+		clog.Warn("Timed out stopping container", "err", result.err)
+		container.AppliedStatus = api.ContainerStatusNone
+		return
+	}
+
+	if result.nextState.Terminal() {
+		// Terminal cases do not record any error in applying this state; this
+		// is because it could overwrite an error that caused us to stop it
+		// and the previous error is more useful to show.
+		clog.Info("Unable to stop container", "err", result.err)
+		// If there was an error, assume we won't get an event in the
+		// eventstream and emit it ourselves.
+		container.AppliedStatus = api.ContainerStopped
+		container.KnownStatus = api.ContainerStopped
+		mtask.emitEvent(mtask.engine.dockerContainerFor(mtask.Task, container), "")
+		return
+	}
+
+	// If we were unable to successfully accomplish a state transition, we
+	// should move that container to 'stopped'
+	clog.Warn("Unable to transition container", "to", result.nextState.String(), "err", result.err)
+	container.ApplyingError = api.NewApplyingError(result.err)
+	container.DesiredStatus = api.ContainerStopped
+}
+
+// cleanupTask waits until the task has been stopped for long enough, removes
+// its containers, and then removes it from the engine. Inbox messages are
+// read and discarded in the meantime so that senders are not blocked.
+func (mtask *managedTask) cleanupTask() {
+	cleanupTime := ttime.After(mtask.KnownTime.Add(taskStoppedDuration).Sub(ttime.Now()))
+	cleanupTimeReached := make(chan struct{})
+	go func() {
+		<-cleanupTime
+		close(cleanupTimeReached)
+	}()
+	mtask.discardEventsUntil(cleanupTimeReached)
+
+	swept := make(chan struct{})
+	go func() {
+		mtask.engine.sweepTask(mtask.Task)
+		close(swept)
+	}()
+	mtask.discardEventsUntil(swept)
+
+	mtask.engine.removeManagedTask(mtask)
+}
+
+func (mtask *managedTask) discardEventsUntil(done <-chan struct{}) {
+	for {
+		select {
+		case <-mtask.acsMessages:
+		case <-mtask.dockerMessages:
+		case <-done:
+			return
+		}
+	}
+}
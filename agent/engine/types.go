@@ -13,8 +13,12 @@
 
 package engine
 
-import "fmt"
-import "github.com/aws/amazon-ecs-agent/agent/api"
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+)
 
 type ContainerNotFound struct {
 	TaskArn       string
@@ -25,6 +29,23 @@ func (cnferror ContainerNotFound) Error() string {
 	return fmt.Sprintf("Could not find container '%s' in task '%s'", cnferror.ContainerName, cnferror.TaskArn)
 }
 
+// DockerTimeoutError is returned when a call to docker does not complete
+// within the time allowed for it. The call may still succeed in docker, so the
+// error is retriable.
+// Implements Error & Retriable
+type DockerTimeoutError struct {
+	Operation string
+	Duration  time.Duration
+}
+
+func (err *DockerTimeoutError) Error() string {
+	return fmt.Sprintf("Docker %s timed out after %v", err.Operation, err.Duration)
+}
+
+func (err *DockerTimeoutError) Retry() bool {
+	return true
+}
+
 type DockerContainerChangeEvent struct {
 	DockerId string
 	Image    string
@@ -107,6 +107,7 @@ func SubmitTaskEvents(events *eventList, client api.ECSClient) {
 					// submitted or can't be retried; ensure we don't retry it
 					event.containerSent = true
 					event.Container.SentStatus = event.Status
+					event.recordSent()
 					statesaver.Save()
 					llog.Debug("Submitted container")
 				} else {
@@ -120,6 +121,7 @@ func SubmitTaskEvents(events *eventList, client api.ECSClient) {
 					// submitted or can't be retried; ensure we don't retry it
 					event.taskSent = true
 					event.Task.SentStatus = event.TaskStatus
+					event.recordSent()
 					statesaver.Save()
 				} else {
 					llog.Error("Error submitting task state change", "err", taskErr)
@@ -18,6 +18,8 @@ import (
 	"sync"
 
 	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/engine"
+	"github.com/aws/amazon-ecs-agent/agent/statemanager"
 	"github.com/aws/amazon-ecs-agent/agent/utils"
 )
 
@@ -58,6 +60,19 @@ func (event *sendableEvent) containerShouldBeSent() bool {
 	return true
 }
 
+// recordSent journals the statuses last sent for the event's container and
+// task, so that they are not submitted again after a crash
+func (event *sendableEvent) recordSent() {
+	entry := &engine.StatusSentEntry{TaskArn: event.TaskArn, ContainerName: event.ContainerName}
+	if event.Container != nil {
+		entry.ContainerSentStatus = event.Container.SentStatus
+	}
+	if event.Task != nil {
+		entry.TaskSentStatus = event.Task.SentStatus
+	}
+	statemanager.Record(statesaver, engine.JournalStatusSent, entry)
+}
+
 type eventList struct {
 	sending    bool // whether the list is already being handled
 	sync.Mutex      // Locks both the list and sending bool
@@ -0,0 +1,210 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package fakebackend
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// acsConnection is an agent's websocket connection to ACS
+type acsConnection struct {
+	conn                 *websocket.Conn
+	cluster              string
+	containerInstanceArn string
+
+	writeLock sync.Mutex // serializes writes to conn
+}
+
+func (connection *acsConnection) write(message []byte) error {
+	connection.writeLock.Lock()
+	defer connection.writeLock.Unlock()
+	return connection.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// serveACS upgrades a signed request from a registered container instance to
+// an ACS websocket, recording the acks the agent sends on it
+func (backend *Backend) serveACS(w http.ResponseWriter, r *http.Request) {
+	if err := backend.verifySignature(r, nil); err != nil {
+		log.Info("Rejecting ACS connection", "err", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	connection := &acsConnection{
+		cluster:              query.Get("clusterArn"),
+		containerInstanceArn: query.Get("containerInstanceArn"),
+	}
+	backend.lock.Lock()
+	_, registered := backend.instances[connection.containerInstanceArn]
+	backend.lock.Unlock()
+	if !registered {
+		http.Error(w, "Container instance not found: "+connection.containerInstanceArn, http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warn("Could not upgrade ACS connection", "err", err)
+		return
+	}
+	connection.conn = conn
+	backend.lock.Lock()
+	backend.connections[connection] = true
+	backend.requests = append(backend.requests, "ws")
+	backend.lock.Unlock()
+	log.Info("Agent connected", "containerInstance", connection.containerInstanceArn)
+
+	go backend.readAcks(connection)
+}
+
+func (backend *Backend) readAcks(connection *acsConnection) {
+	defer func() {
+		backend.lock.Lock()
+		delete(backend.connections, connection)
+		backend.lock.Unlock()
+		connection.conn.Close()
+	}()
+	for {
+		_, message, err := connection.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var typed struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(message, &typed)
+		if typed.Type == reconciliationResultType {
+			var result ReconciliationResult
+			if err := json.Unmarshal(message, &result); err != nil {
+				log.Warn("Unexpected reconciliation result from agent", "message", string(message))
+				continue
+			}
+			backend.lock.Lock()
+			backend.reconciliations = append(backend.reconciliations, result)
+			backend.lock.Unlock()
+			continue
+		}
+		var ack Ack
+		if err := json.Unmarshal(message, &ack); err != nil || ack.MessageId == "" {
+			log.Warn("Unexpected message from agent", "message", string(message))
+			continue
+		}
+		backend.lock.Lock()
+		backend.acks = append(backend.acks, ack)
+		backend.lock.Unlock()
+	}
+}
+
+// activeConnections returns the open ACS connections
+func (backend *Backend) activeConnections() []*acsConnection {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	connections := make([]*acsConnection, 0, len(backend.connections))
+	for connection := range backend.connections {
+		connections = append(connections, connection)
+	}
+	return connections
+}
+
+// Connected returns how many agents are connected to ACS
+func (backend *Backend) Connected() int {
+	return len(backend.activeConnections())
+}
+
+// WaitForConnection waits up to timeout for an agent to connect to ACS
+func (backend *Backend) WaitForConnection(timeout time.Duration) error {
+	if !backend.WaitFor(timeout, func() bool { return backend.Connected() > 0 }) {
+		return errors.New("Timed out waiting for an agent to connect")
+	}
+	return nil
+}
+
+// SendMessage sends a raw message to every connected agent
+func (backend *Backend) SendMessage(message []byte) error {
+	connections := backend.activeConnections()
+	if len(connections) == 0 {
+		return errors.New("No agent is connected")
+	}
+	for _, connection := range connections {
+		if err := connection.write(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (backend *Backend) sendTyped(messageType string, message interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":    messageType,
+		"message": message,
+	})
+	if err != nil {
+		return err
+	}
+	return backend.SendMessage(data)
+}
+
+// SendPayload sends tasks to every connected agent in a payload message,
+// returning the message's id. The tasks are marshaled as they are, so they may
+// be api.Tasks or anything else with their JSON.
+func (backend *Backend) SendPayload(tasks interface{}) (string, error) {
+	messageId := "message-" + backend.newID()
+	return messageId, backend.sendTyped("PayloadMessage", map[string]interface{}{
+		"tasks":     tasks,
+		"messageId": messageId,
+	})
+}
+
+// TaskDesiredStatus is a task of a reconciliation
+type TaskDesiredStatus struct {
+	Arn           string `json:"arn"`
+	DesiredStatus string `json:"desiredStatus"`
+}
+
+// SendReconciliation sends every connected agent the authoritative list of
+// its tasks, returning the message's id. The agent stops any other task.
+func (backend *Backend) SendReconciliation(tasks []TaskDesiredStatus) (string, error) {
+	if tasks == nil {
+		tasks = []TaskDesiredStatus{}
+	}
+	messageId := "message-" + backend.newID()
+	return messageId, backend.sendTyped("TaskReconciliationMessage", map[string]interface{}{
+		"tasks":     tasks,
+		"messageId": messageId,
+	})
+}
+
+// SendHeartbeat sends a heartbeat to every connected agent
+func (backend *Backend) SendHeartbeat() error {
+	return backend.sendTyped("HeartbeatMessage", map[string]interface{}{"healthy": true})
+}
+
+// Disconnect closes every agent's ACS connection, as ACS does from time to
+// time
+func (backend *Backend) Disconnect() {
+	for _, connection := range backend.activeConnections() {
+		connection.conn.Close()
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package fakebackend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The prefix of the X-Amz-Target of ECS operations
+const targetPrefix = "AmazonEC2ContainerServiceV20141113."
+
+// ecsError is an AWS JSON 1.1 error response
+type ecsError struct {
+	status  int
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func clientException(message string) *ecsError {
+	return &ecsError{status: http.StatusBadRequest, Type: "ClientException", Message: message}
+}
+
+type cluster struct {
+	ClusterArn  string `json:"clusterArn"`
+	ClusterName string `json:"clusterName"`
+	Status      string `json:"status"`
+}
+
+type containerInstance struct {
+	ContainerInstanceArn string `json:"containerInstanceArn"`
+	Status               string `json:"status"`
+}
+
+// serveECS serves the ECS API
+func (backend *Backend) serveECS(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	response, ecsErr := backend.handleECS(r, body)
+	if ecsErr != nil {
+		log.Info("Rejecting ECS request", "target", r.Header.Get("X-Amz-Target"), "type", ecsErr.Type, "message", ecsErr.Message)
+		data, _ := json.Marshal(ecsErr)
+		w.WriteHeader(ecsErr.status)
+		w.Write(data)
+		return
+	}
+	data, _ := json.Marshal(response)
+	w.Write(data)
+}
+
+func (backend *Backend) handleECS(r *http.Request, body []byte) (interface{}, *ecsError) {
+	if r.Method != "POST" {
+		return nil, &ecsError{status: http.StatusMethodNotAllowed, Type: "UnknownOperationException", Message: "Requests must be posted"}
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-amz-json-1.1") {
+		return nil, &ecsError{status: http.StatusBadRequest, Type: "SerializationException", Message: "Unexpected content type " + r.Header.Get("Content-Type")}
+	}
+	if err := backend.verifySignature(r, body); err != nil {
+		return nil, &ecsError{status: http.StatusForbidden, Type: "InvalidSignatureException", Message: err.Error()}
+	}
+	target := r.Header.Get("X-Amz-Target")
+	if !strings.HasPrefix(target, targetPrefix) {
+		return nil, &ecsError{status: http.StatusBadRequest, Type: "UnknownOperationException", Message: "Unknown target " + target}
+	}
+	operation := strings.TrimPrefix(target, targetPrefix)
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.failures[operation] > 0 {
+		backend.failures[operation]--
+		return nil, &ecsError{status: http.StatusInternalServerError, Type: "ServerException", Message: "Scripted failure"}
+	}
+	backend.requests = append(backend.requests, operation)
+
+	var input struct {
+		Cluster           string `json:"cluster"`
+		ClusterName       string `json:"clusterName"`
+		ContainerInstance string `json:"containerInstance"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, &ecsError{status: http.StatusBadRequest, Type: "SerializationException", Message: err.Error()}
+	}
+	if input.Cluster == "" {
+		input.Cluster = "default"
+	}
+
+	switch operation {
+	case "CreateCluster":
+		backend.clusters[input.ClusterName] = true
+		return map[string]interface{}{
+			"cluster": cluster{ClusterArn: backend.clusterArn(input.ClusterName), ClusterName: input.ClusterName, Status: "ACTIVE"},
+		}, nil
+	case "RegisterContainerInstance":
+		if !backend.clusters[input.Cluster] {
+			return nil, clientException("Cluster not found.")
+		}
+		backend.nextID++
+		arn := "arn:aws:ecs:" + backend.region + ":" + accountID + ":container-instance/" + strconv.Itoa(backend.nextID)
+		backend.instances[arn] = input.Cluster
+		return map[string]interface{}{
+			"containerInstance": containerInstance{ContainerInstanceArn: arn, Status: "ACTIVE"},
+		}, nil
+	case "DeregisterContainerInstance":
+		if _, ok := backend.instances[input.ContainerInstance]; !ok {
+			return nil, clientException("Container instance not found.")
+		}
+		delete(backend.instances, input.ContainerInstance)
+		return map[string]interface{}{
+			"containerInstance": containerInstance{ContainerInstanceArn: input.ContainerInstance, Status: "INACTIVE"},
+		}, nil
+	case "DiscoverPollEndpoint":
+		if _, ok := backend.instances[input.ContainerInstance]; !ok {
+			return nil, clientException("Container instance not found.")
+		}
+		return map[string]interface{}{"endpoint": backend.server.URL}, nil
+	case "SubmitContainerStateChange":
+		var change ContainerStateChange
+		json.Unmarshal(body, &change)
+		change.Cluster = input.Cluster
+		backend.containerChanges = append(backend.containerChanges, change)
+		return map[string]interface{}{"acknowledgment": "true"}, nil
+	case "SubmitTaskStateChange":
+		var change TaskStateChange
+		json.Unmarshal(body, &change)
+		change.Cluster = input.Cluster
+		backend.taskChanges = append(backend.taskChanges, change)
+		return map[string]interface{}{"acknowledgment": "true"}, nil
+	}
+	return nil, &ecsError{status: http.StatusBadRequest, Type: "UnknownOperationException", Message: "Unsupported operation " + operation}
+}
@@ -0,0 +1,300 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+// Package fakebackend is a local stand-in for the ECS and ACS backends, for
+// end-to-end tests of the agent without AWS.
+//
+// A Backend serves, over TLS with a self-signed certificate, both the ECS
+// API, in the AWS JSON 1.1 protocol, and the ACS websocket. Requests must be
+// signed with SigV4 by the credentials it is created with. It records the
+// clusters, container instances, state changes and acks the agent sends, and
+// tests script what ACS sends the agent: task payloads, heartbeats, or any
+// other message.
+//
+// The agent is pointed at a Backend by setting APIEndpoint and APIPort, or
+// ECS_BACKEND_HOST and ECS_BACKEND_PORT, and skipping certificate
+// verification, such as with the agent's -k flag.
+package fakebackend
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/authv4/credentials"
+	"github.com/aws/amazon-ecs-agent/agent/logger"
+)
+
+var log = logger.ForModule("fakebackend")
+
+// The account in the Arns the backend makes up
+const accountID = "000000000000"
+
+// How often the Wait methods check for what they wait for
+const waitInterval = 10 * time.Millisecond
+
+// Backend is a fake ECS and ACS backend listening on a local address
+type Backend struct {
+	region      string
+	credentials credentials.AWSCredentialProvider
+	server      *httptest.Server
+
+	lock             sync.Mutex // guards the fields below
+	clusters         map[string]bool
+	instances        map[string]string // container instance Arn to cluster
+	containerChanges []ContainerStateChange
+	taskChanges      []TaskStateChange
+	acks             []Ack
+	reconciliations  []ReconciliationResult
+	requests         []string // the operation of each request, or "ws"
+	failures         map[string]int
+	connections      map[*acsConnection]bool
+	nextID           int
+}
+
+// ContainerStateChange is a submitted container state change
+type ContainerStateChange struct {
+	Cluster         string           `json:"cluster"`
+	Task            string           `json:"task"`
+	ContainerName   string           `json:"containerName"`
+	Status          string           `json:"status"`
+	ExitCode        *int32           `json:"exitCode"`
+	Reason          string           `json:"reason"`
+	NetworkBindings []NetworkBinding `json:"networkBindings"`
+}
+
+// NetworkBinding is a port binding of a submitted container state change
+type NetworkBinding struct {
+	BindIP        string `json:"bindIP"`
+	ContainerPort int32  `json:"containerPort"`
+	HostPort      int32  `json:"hostPort"`
+}
+
+// TaskStateChange is a submitted task state change
+type TaskStateChange struct {
+	Cluster string `json:"cluster"`
+	Task    string `json:"task"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+}
+
+// Ack is an acknowledgement of an ACS message
+type Ack struct {
+	Cluster           string `json:"cluster"`
+	ContainerInstance string `json:"containerInstance"`
+	MessageId         string `json:"messageId"`
+}
+
+// The type of the message an agent reports a reconciliation's outcome with
+const reconciliationResultType = "TaskReconciliationResultMessage"
+
+// ReconciliationResult is the outcome of a reconciliation, as reported by
+// the agent
+type ReconciliationResult struct {
+	Cluster           string `json:"cluster"`
+	ContainerInstance string `json:"containerInstance"`
+	MessageId         string `json:"messageId"`
+	StoppedTasks      []struct {
+		Arn    string `json:"arn"`
+		Reason string `json:"reason"`
+	} `json:"stoppedTasks"`
+	UnknownTasks []string `json:"unknownTasks"`
+}
+
+// New starts a backend for the given region, accepting requests signed by
+// the given credentials
+func New(region string, credentialProvider credentials.AWSCredentialProvider) *Backend {
+	backend := &Backend{
+		region:      region,
+		credentials: credentialProvider,
+		clusters:    make(map[string]bool),
+		instances:   make(map[string]string),
+		failures:    make(map[string]int),
+		connections: make(map[*acsConnection]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", backend.serveECS)
+	mux.HandleFunc("/ws", backend.serveACS)
+	backend.server = httptest.NewTLSServer(mux)
+	return backend
+}
+
+// Close disconnects every agent and stops the backend
+func (backend *Backend) Close() {
+	backend.Disconnect()
+	backend.server.Close()
+}
+
+// Host returns the host the backend listens on
+func (backend *Backend) Host() string {
+	host, _, _ := net.SplitHostPort(backend.server.Listener.Addr().String())
+	return host
+}
+
+// Port returns the port the backend listens on
+func (backend *Backend) Port() uint16 {
+	_, port, _ := net.SplitHostPort(backend.server.Listener.Addr().String())
+	parsed, _ := strconv.ParseUint(port, 10, 16)
+	return uint16(parsed)
+}
+
+// URL returns the base URL of the backend; it is also the ACS endpoint
+func (backend *Backend) URL() string {
+	return backend.server.URL
+}
+
+// Configure points cfg at the backend
+func (backend *Backend) Configure(cfg *config.Config) {
+	cfg.APIEndpoint = backend.Host()
+	cfg.APIPort = backend.Port()
+	cfg.AWSRegion = backend.region
+}
+
+// Environment returns the environment variables that point an agent process
+// at the backend. Credentials must be provided separately, and certificates
+// not verified.
+func (backend *Backend) Environment() []string {
+	return []string{
+		"ECS_BACKEND_HOST=" + backend.Host(),
+		"ECS_BACKEND_PORT=" + strconv.Itoa(int(backend.Port())),
+		"AWS_DEFAULT_REGION=" + backend.region,
+	}
+}
+
+// CreateCluster creates a cluster, as if by the ECS API
+func (backend *Backend) CreateCluster(name string) string {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	backend.clusters[name] = true
+	return backend.clusterArn(name)
+}
+
+func (backend *Backend) clusterArn(name string) string {
+	return "arn:aws:ecs:" + backend.region + ":" + accountID + ":cluster/" + name
+}
+
+// FailNext makes the next count requests of an operation, such as
+// "SubmitTaskStateChange", fail with a server error
+func (backend *Backend) FailNext(operation string, count int) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	backend.failures[operation] += count
+}
+
+// ContainerInstances returns the Arns of the registered container instances
+func (backend *Backend) ContainerInstances() []string {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	arns := make([]string, 0, len(backend.instances))
+	for arn := range backend.instances {
+		arns = append(arns, arn)
+	}
+	return arns
+}
+
+// ContainerStateChanges returns the container state changes submitted, in
+// order
+func (backend *Backend) ContainerStateChanges() []ContainerStateChange {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return append([]ContainerStateChange{}, backend.containerChanges...)
+}
+
+// TaskStateChanges returns the task state changes submitted, in order
+func (backend *Backend) TaskStateChanges() []TaskStateChange {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return append([]TaskStateChange{}, backend.taskChanges...)
+}
+
+// Acks returns the ACS messages acknowledged, in order
+func (backend *Backend) Acks() []Ack {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return append([]Ack{}, backend.acks...)
+}
+
+// ReconciliationResults returns the reconciliation outcomes reported, in
+// order
+func (backend *Backend) ReconciliationResults() []ReconciliationResult {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return append([]ReconciliationResult{}, backend.reconciliations...)
+}
+
+// Requests returns the operation of each request accepted, in order; "ws"
+// is a websocket connection
+func (backend *Backend) Requests() []string {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return append([]string{}, backend.requests...)
+}
+
+// WaitFor waits up to timeout for condition to be true, returning whether
+// it became so. The condition is called without the backend locked, so it
+// may use the backend's other methods.
+func (backend *Backend) WaitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(waitInterval)
+	}
+	return true
+}
+
+// WaitForTaskStatus waits up to timeout for a task state change with the
+// given status to be submitted for a task
+func (backend *Backend) WaitForTaskStatus(taskArn, status string, timeout time.Duration) error {
+	found := backend.WaitFor(timeout, func() bool {
+		for _, change := range backend.TaskStateChanges() {
+			if change.Task == taskArn && change.Status == status {
+				return true
+			}
+		}
+		return false
+	})
+	if !found {
+		return errors.New("Timed out waiting for " + taskArn + " to be " + status)
+	}
+	return nil
+}
+
+// WaitForAck waits up to timeout for the given message to be acknowledged
+func (backend *Backend) WaitForAck(messageId string, timeout time.Duration) error {
+	found := backend.WaitFor(timeout, func() bool {
+		for _, ack := range backend.Acks() {
+			if ack.MessageId == messageId {
+				return true
+			}
+		}
+		return false
+	})
+	if !found {
+		return errors.New("Timed out waiting for an ack of " + messageId)
+	}
+	return nil
+}
+
+// newID returns an identifier unique to this backend
+func (backend *Backend) newID() string {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	backend.nextID++
+	return strconv.Itoa(backend.nextID)
+}
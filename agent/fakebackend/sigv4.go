@@ -0,0 +1,104 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package fakebackend
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/authv4/sign"
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/authv4/signable"
+)
+
+const (
+	// The service requests are signed for
+	signingService = "ecs"
+	// The format of X-Amz-Date
+	amzDateFormat = "20060102T150405Z"
+	// How far the signing time of a request may be from now
+	maxClockSkew = 15 * time.Minute
+)
+
+// verifySignature checks that a request, with the given body, is signed with
+// SigV4 by the backend's credentials. It does so by signing it again, as of
+// the time it was signed, and comparing the result.
+func (backend *Backend) verifySignature(r *http.Request, body []byte) error {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		return errors.New("Request is not signed with SigV4")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ", ") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	scope := strings.Split(fields["Credential"], "/")
+	if len(scope) != 5 || fields["SignedHeaders"] == "" || fields["Signature"] == "" {
+		return errors.New("Malformed Authorization header")
+	}
+
+	creds, err := backend.credentials.Credentials()
+	if err != nil {
+		return err
+	}
+	if scope[0] != creds.AccessKey {
+		return errors.New("Unknown access key " + scope[0])
+	}
+	if scope[2] != backend.region || scope[3] != signingService {
+		return errors.New("Signed for " + scope[2] + "/" + scope[3] + " instead of " + backend.region + "/" + signingService)
+	}
+	signedAt, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return errors.New("Missing or malformed X-Amz-Date")
+	}
+	if skew := time.Since(signedAt); skew > maxClockSkew || skew < -maxClockSkew {
+		return errors.New("Signature expired")
+	}
+
+	var extraHeaders []string
+	for _, header := range strings.Split(fields["SignedHeaders"], ";") {
+		if header != "host" {
+			extraHeaders = append(extraHeaders, header)
+		}
+	}
+	resigned := &http.Request{
+		Method: r.Method,
+		URL:    &url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery},
+		Host:   r.Host,
+		Header: make(http.Header),
+		Body:   ioutil.NopCloser(bytes.NewReader(body)),
+	}
+	for name, values := range r.Header {
+		if name != "Authorization" {
+			resigned.Header[name] = values
+		}
+	}
+	signer := sign.NewSigner(backend.region, signingService, backend.credentials, extraHeaders)
+	_, expected, err := signer.SignDetails(signedAt, signable.HttpRequest{Request: resigned})
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(authorization)) {
+		return errors.New("The request signature does not match")
+	}
+	return nil
+}
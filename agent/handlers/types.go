@@ -13,6 +13,8 @@
 
 package handlers
 
+import "time"
+
 type MetadataResponse struct {
 	Cluster           string
 	ContainerInstanceArn *string
@@ -24,6 +26,7 @@ type TaskResponse struct {
 	KnownStatus   string
 	Family        string
 	Version       string
+	NetworkMode   string
 	Containers    []ContainerResponse
 }
 
@@ -35,4 +38,28 @@ type ContainerResponse struct {
 	DockerId   string
 	DockerName string
 	Name       string
+	IPAddress  string
+}
+
+type DockerQueueResponse struct {
+	Concurrency     int
+	Running         int
+	Queued          map[string]int
+	PullConcurrency int
+	PullsRunning    int
+	PullsQueued     int
+}
+
+type ACSResponse struct {
+	Connections      int
+	Endpoint         string     `json:",omitempty"`
+	Connected        bool
+	ConnectedAt      *time.Time `json:",omitempty"`
+	DisconnectedAt   *time.Time `json:",omitempty"`
+	DisconnectReason string     `json:",omitempty"`
+	MessagesReceived int
+	LastMessageAt    *time.Time `json:",omitempty"`
+	LastHeartbeatAt  *time.Time `json:",omitempty"`
+	PingsSent        int
+	LastPongAt       *time.Time `json:",omitempty"`
 }
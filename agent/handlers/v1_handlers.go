@@ -20,11 +20,13 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/aws/amazon-ecs-agent/agent/acs"
 	"github.com/aws/amazon-ecs-agent/agent/api"
 	"github.com/aws/amazon-ecs-agent/agent/config"
 	"github.com/aws/amazon-ecs-agent/agent/engine"
 	"github.com/aws/amazon-ecs-agent/agent/engine/dockerstate"
 	"github.com/aws/amazon-ecs-agent/agent/logger"
+	"github.com/aws/amazon-ecs-agent/agent/utils"
 )
 
 var log = logger.ForModule("Handlers")
@@ -59,7 +61,12 @@ func NewTaskResponse(task *api.Task, containerMap map[string]*api.DockerContaine
 		if container.Container.IsInternal {
 			continue
 		}
-		containers = append(containers, ContainerResponse{container.DockerId, container.DockerName, containerName})
+		containers = append(containers, ContainerResponse{
+			DockerId:   container.DockerId,
+			DockerName: container.DockerName,
+			Name:       containerName,
+			IPAddress:  task.ContainerIPAddress(container.Container),
+		})
 	}
 
 	return &TaskResponse{
@@ -68,6 +75,7 @@ func NewTaskResponse(task *api.Task, containerMap map[string]*api.DockerContaine
 		KnownStatus:   task.KnownStatus.String(),
 		Family:        task.Family,
 		Version:       task.Version,
+		NetworkMode:   utils.DefaultIfBlank(task.NetworkMode, api.NetworkModeBridge),
 		Containers:    containers,
 	}
 }
@@ -147,10 +155,73 @@ func TasksV1RequestHandlerMaker(taskEngine engine.TaskEngine) func(http.Response
 	}
 }
 
-func ServeHttp(containerInstanceArn *string, taskEngine engine.TaskEngine, cfg *config.Config) {
+// Creates response for the 'v1/dockerqueue' API, which reports how many docker
+// operations are running and waiting to run.
+func DockerQueueV1RequestHandlerMaker(taskEngine engine.TaskEngine) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var responseJSON []byte
+		dockerTaskEngine, ok := taskEngine.(*engine.DockerTaskEngine)
+		if !ok {
+			w.WriteHeader(statusInternalServerError)
+			w.Write(responseJSON)
+			return
+		}
+		stats, ok := dockerTaskEngine.DockerQueueStats()
+		if !ok {
+			// Not yet connected to docker
+			w.WriteHeader(statusInternalServerError)
+			w.Write(responseJSON)
+			return
+		}
+		responseJSON, _ = json.Marshal(&DockerQueueResponse{
+			Concurrency:     stats.Concurrency,
+			Running:         stats.Running,
+			Queued:          stats.Queued,
+			PullConcurrency: stats.PullConcurrency,
+			PullsRunning:    stats.PullsRunning,
+			PullsQueued:     stats.PullsQueued,
+		})
+		w.Write(responseJSON)
+	}
+}
+
+// Creates response for the 'v1/acs' API, which reports the health of the
+// agent's connection to ACS.
+func ACSV1RequestHandlerMaker(monitor *acs.Monitor) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := monitor.Health()
+		response := &ACSResponse{Connections: health.Connections}
+		if current := health.Current; current != nil {
+			response.Endpoint = current.Endpoint
+			response.Connected = current.Connected
+			response.ConnectedAt = optionalTime(current.ConnectedAt)
+			response.DisconnectedAt = optionalTime(current.DisconnectedAt)
+			response.DisconnectReason = current.DisconnectReason
+			response.MessagesReceived = current.MessagesReceived
+			response.LastMessageAt = optionalTime(current.LastMessageAt)
+			response.LastHeartbeatAt = optionalTime(current.LastHeartbeatAt)
+			response.PingsSent = current.PingsSent
+			response.LastPongAt = optionalTime(current.LastPongAt)
+		}
+		responseJSON, _ := json.Marshal(response)
+		w.Write(responseJSON)
+	}
+}
+
+// optionalTime returns nil for the zero time, so that it is omitted
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func ServeHttp(containerInstanceArn *string, taskEngine engine.TaskEngine, acsMonitor *acs.Monitor, cfg *config.Config) {
 	serverFunctions := map[string]func(w http.ResponseWriter, r *http.Request){
-		"/v1/metadata": MetadataV1RequestHandlerMaker(containerInstanceArn, cfg),
-		"/v1/tasks":    TasksV1RequestHandlerMaker(taskEngine),
+		"/v1/metadata":    MetadataV1RequestHandlerMaker(containerInstanceArn, cfg),
+		"/v1/tasks":       TasksV1RequestHandlerMaker(taskEngine),
+		"/v1/dockerqueue": DockerQueueV1RequestHandlerMaker(taskEngine),
+		"/v1/acs":         ACSV1RequestHandlerMaker(acsMonitor),
 	}
 
 	paths := make([]string, 0, len(serverFunctions))
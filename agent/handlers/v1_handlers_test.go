@@ -21,7 +21,9 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/aws/amazon-ecs-agent/agent/acs"
 	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/auth"
 	"github.com/aws/amazon-ecs-agent/agent/config"
 	"github.com/aws/amazon-ecs-agent/agent/engine"
 	"github.com/aws/amazon-ecs-agent/agent/utils"
@@ -48,6 +50,41 @@ func TestMetadataHandler(t *testing.T) {
 	}
 }
 
+func TestDockerQueueHandlerUninitialized(t *testing.T) {
+	dockerQueueHandler := DockerQueueV1RequestHandlerMaker(engine.NewTaskEngine(&config.Config{}))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:"+strconv.Itoa(config.AGENT_INTROSPECTION_PORT)+"/v1/dockerqueue", nil)
+	dockerQueueHandler(w, req)
+
+	if w.Code != statusInternalServerError {
+		t.Error("Expected an error for an engine that is not connected to docker; got ", w.Code)
+	}
+}
+
+func TestACSHandler(t *testing.T) {
+	monitor := acs.NewMonitor()
+	acsHandler := ACSV1RequestHandlerMaker(monitor)
+
+	get := func() ACSResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:"+strconv.Itoa(config.AGENT_INTROSPECTION_PORT)+"/v1/acs", nil)
+		acsHandler(w, req)
+		var resp ACSResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	if resp := get(); resp.Connections != 0 || resp.Connected || resp.ConnectedAt != nil {
+		t.Error("Expected no connection to be reported, got", resp)
+	}
+
+	monitor.Track(acs.NewAgentCommunicationClient("https://acs.example.com", &config.Config{}, auth.TestCredentialProvider{}, TestContainerInstanceArn))
+	if resp := get(); resp.Connections != 1 || resp.Endpoint != "https://acs.example.com" || resp.Connected {
+		t.Error("Expected the tracked connection to be reported, got", resp)
+	}
+}
+
 func getResponseBodyFromLocalHost(url string, t *testing.T) []byte {
 	resp, err := http.Get("http://localhost:" + strconv.Itoa(config.AGENT_INTROSPECTION_PORT) + url)
 	if err != nil {
@@ -79,7 +116,7 @@ func TestServeHttp(t *testing.T) {
 	dockerTaskEngine, _ := taskEngine.(*engine.DockerTaskEngine)
 	dockerTaskEngine.State().AddOrUpdateTask(&testTask)
 	dockerTaskEngine.State().AddContainer(&api.DockerContainer{DockerId: "docker1", DockerName: "someName", Container: containers[0]}, &testTask)
-	go ServeHttp(utils.Strptr(TestContainerInstanceArn), taskEngine, &config.Config{Cluster: TestClusterArn})
+	go ServeHttp(utils.Strptr(TestContainerInstanceArn), taskEngine, acs.NewMonitor(), &config.Config{Cluster: TestClusterArn})
 
 	body := getResponseBodyFromLocalHost("/v1/metadata", t)
 	var metadata MetadataResponse
@@ -151,3 +188,31 @@ func TestServeHttp(t *testing.T) {
 		t.Error("API did not return bad request status when both dockerid and taskarn are specified.")
 	}
 }
+
+func TestTaskResponseSharedNetwork(t *testing.T) {
+	task := &api.Task{
+		Arn:         "task1",
+		NetworkMode: api.NetworkModeShared,
+		Containers:  []*api.Container{&api.Container{Name: "c1"}},
+	}
+	task.PostUnmarshalTask()
+
+	containerMap := make(map[string]*api.DockerContainer)
+	for _, cont := range task.Containers {
+		if cont.IsInternal {
+			cont.KnownIPAddress = "172.17.0.2"
+		}
+		containerMap[cont.Name] = &api.DockerContainer{DockerId: "id-" + cont.Name, DockerName: "name-" + cont.Name, Container: cont}
+	}
+
+	response := NewTaskResponse(task, containerMap)
+	if response.NetworkMode != "shared" {
+		t.Error("Incorrect network mode in response: ", response.NetworkMode)
+	}
+	if len(response.Containers) != 1 {
+		t.Fatal("Internal containers should not be in the response: ", response.Containers)
+	}
+	if response.Containers[0].IPAddress != "172.17.0.2" {
+		t.Error("Expected the address of the shared network, got: ", response.Containers[0].IPAddress)
+	}
+}
@@ -74,11 +74,13 @@ func SetHandlerChain() {
 
 	logger.SetHandler(
 		log15.LazyHandler(
-			log15.MultiHandler(
-				log15.LvlFilterHandler(level,
-					log15.StdoutHandler,
+			RedactHandler(
+				log15.MultiHandler(
+					log15.LvlFilterHandler(level,
+						log15.StdoutHandler,
+					),
+					fileHandler,
 				),
-				fileHandler,
 			),
 		),
 	)
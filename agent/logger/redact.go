@@ -0,0 +1,278 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log15 "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// REDACT_KEYS_ENV_VAR is a comma separated list of regular expressions.
+	// Values whose key, field, or map key matches one of them are redacted,
+	// in addition to those matching DefaultRedactKeys.
+	REDACT_KEYS_ENV_VAR = "ECS_LOG_REDACT_KEYS"
+
+	// Redacted replaces every redacted value
+	Redacted = "[REDACTED]"
+
+	// maxRedactDepth bounds how deeply structured values are walked; anything
+	// deeper is redacted rather than risk logging it
+	maxRedactDepth = 10
+)
+
+// DefaultRedactKeys match, case-insensitively, the names under which the
+// agent's sensitive values are found: container environments, registry auth
+// data, credentials, and request signatures.
+var DefaultRedactKeys = []string{
+	"passw(or)?d",
+	"secret",
+	"token",
+	"credential",
+	"signature",
+	"access_?key",
+	"^authorization$",
+	"authdata",
+	"^auth$",
+	"^environment$",
+	"^env$",
+}
+
+var (
+	redactLock sync.RWMutex
+	redactKeys = regexp.MustCompile(redactKeysPattern(nil))
+
+	// signedURLParam matches the values of the query parameters of a signed
+	// URL that are sufficient to reuse it
+	signedURLParam = regexp.MustCompile(`(?i)((?:X-Amz-Signature|X-Amz-Credential|X-Amz-Security-Token|Signature|AWSAccessKeyId)=)[^&\s"']+`)
+)
+
+func init() {
+	var extra []string
+	for _, pattern := range strings.Split(os.Getenv(REDACT_KEYS_ENV_VAR), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			extra = append(extra, pattern)
+		}
+	}
+	if err := SetRedactKeys(extra...); err != nil {
+		os.Stderr.WriteString("Invalid " + REDACT_KEYS_ENV_VAR + ", using the default redacted keys: " + err.Error() + "\n")
+	}
+}
+
+// SetRedactKeys sets the patterns of keys to redact, in addition to
+// DefaultRedactKeys. Patterns are regular expressions matched
+// case-insensitively against log keys, struct field names, and map and JSON
+// object keys. On error, the previous patterns are kept.
+func SetRedactKeys(patterns ...string) error {
+	compiled, err := regexp.Compile(redactKeysPattern(patterns))
+	if err != nil {
+		return err
+	}
+	redactLock.Lock()
+	defer redactLock.Unlock()
+	redactKeys = compiled
+	return nil
+}
+
+func redactKeysPattern(patterns []string) string {
+	all := append(append([]string{}, DefaultRedactKeys...), patterns...)
+	return "(?i)(" + strings.Join(all, ")|(") + ")"
+}
+
+func isRedactedKey(key string) bool {
+	redactLock.RLock()
+	defer redactLock.RUnlock()
+	return redactKeys.MatchString(key)
+}
+
+// RedactHandler redacts the values of each record before passing it on to h.
+// Values under a redacted key are replaced entirely. Other values are
+// rendered as log15 would render them, with any redacted struct fields, map
+// keys, and JSON object keys within them replaced, and with signed URLs
+// stripped of their signatures.
+func RedactHandler(h log15.Handler) log15.Handler {
+	return log15.FuncHandler(func(r *log15.Record) error {
+		redacted := *r
+		redacted.Msg = redactString(r.Msg)
+		redacted.Ctx = make([]interface{}, len(r.Ctx))
+		for i := 0; i < len(r.Ctx); i += 2 {
+			redacted.Ctx[i] = r.Ctx[i]
+			if i+1 >= len(r.Ctx) {
+				break
+			}
+			key, _ := r.Ctx[i].(string)
+			if isRedactedKey(key) {
+				redacted.Ctx[i+1] = Redacted
+			} else {
+				redacted.Ctx[i+1] = RedactValue(r.Ctx[i+1])
+			}
+		}
+		return h.Log(&redacted)
+	})
+}
+
+// RedactValue returns value with anything sensitive within it redacted.
+// Numbers and booleans are returned as they are; everything else is returned
+// as a string.
+func RedactValue(value interface{}) interface{} {
+	switch value.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return value
+	}
+	return redactReflect(reflect.ValueOf(value), 0)
+}
+
+func redactReflect(v reflect.Value, depth int) string {
+	if !v.IsValid() {
+		return "<nil>"
+	}
+	if depth > maxRedactDepth {
+		return Redacted
+	}
+	if v.CanInterface() {
+		switch value := v.Interface().(type) {
+		case string:
+			return redactString(value)
+		case []byte:
+			return redactString(string(value))
+		case error:
+			if isNil(v) {
+				return "<nil>"
+			}
+			return redactString(value.Error())
+		case fmt.Stringer:
+			if isNil(v) {
+				return "<nil>"
+			}
+			return redactString(value.String())
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "<nil>"
+		}
+		return redactReflect(v.Elem(), depth+1)
+	case reflect.Struct:
+		var buf bytes.Buffer
+		buf.WriteString("{")
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				buf.WriteString(" ")
+			}
+			name := v.Type().Field(i).Name
+			buf.WriteString(name + ":")
+			if isRedactedKey(name) {
+				buf.WriteString(Redacted)
+			} else {
+				buf.WriteString(redactReflect(v.Field(i), depth+1))
+			}
+		}
+		buf.WriteString("}")
+		return buf.String()
+	case reflect.Map:
+		if v.IsNil() {
+			return "map[]"
+		}
+		// Sort the entries, as fmt does, so that output is stable
+		entries := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keyString := redactReflect(key, depth+1)
+			if isRedactedKey(keyString) {
+				entries = append(entries, keyString+":"+Redacted)
+			} else {
+				entries = append(entries, keyString+":"+redactReflect(v.MapIndex(key), depth+1))
+			}
+		}
+		sort.Strings(entries)
+		return "map[" + strings.Join(entries, " ") + "]"
+	case reflect.Slice, reflect.Array:
+		var buf bytes.Buffer
+		buf.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteString(" ")
+			}
+			buf.WriteString(redactReflect(v.Index(i), depth+1))
+		}
+		buf.WriteString("]")
+		return buf.String()
+	case reflect.String:
+		return redactString(v.String())
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return v.Type().String()
+	}
+	// Unexported numbers and booleans
+	return fmt.Sprintf("%v", v)
+}
+
+// redactString redacts a string that may hold JSON, such as a raw websocket
+// message or saved state, and strips signatures from any signed URLs in it
+func redactString(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var parsed interface{}
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.UseNumber()
+		if err := decoder.Decode(&parsed); err == nil && !decoder.More() {
+			if redacted, err := json.Marshal(redactJSON(parsed, 0)); err == nil {
+				s = string(redacted)
+			}
+		}
+	}
+	return signedURLParam.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+func redactJSON(value interface{}, depth int) interface{} {
+	if depth > maxRedactDepth {
+		return Redacted
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if isRedactedKey(key) {
+				v[key] = Redacted
+			} else {
+				v[key] = redactJSON(child, depth+1)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactJSON(child, depth+1)
+		}
+	case string:
+		// Strings may themselves hold JSON, as ACS messages and saved state
+		// both do
+		return redactString(v)
+	}
+	return value
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package logger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	log15 "gopkg.in/inconshreveable/log15.v2"
+)
+
+type redactTestContainer struct {
+	Name        string
+	Environment map[string]string
+	Ports       []uint16
+}
+
+type redactTestConfig struct {
+	Cluster        string
+	EngineAuthData []byte
+	Container      *redactTestContainer
+}
+
+func logRedacted(ctx ...interface{}) *log15.Record {
+	var logged *log15.Record
+	handler := RedactHandler(log15.FuncHandler(func(r *log15.Record) error {
+		logged = r
+		return nil
+	}))
+	logger := log15.New()
+	logger.SetHandler(handler)
+	logger.Info("message", ctx...)
+	return logged
+}
+
+func TestRedactKeys(t *testing.T) {
+	record := logRedacted("password", "hunter2", "Token", 42, "task", "arn:task")
+	if record.Ctx[1] != Redacted || record.Ctx[3] != Redacted {
+		t.Error("Expected values under sensitive keys to be redacted", record.Ctx)
+	}
+	if record.Ctx[5] != "arn:task" {
+		t.Error("Expected other values to be kept", record.Ctx)
+	}
+}
+
+func TestRedactStructuredValues(t *testing.T) {
+	cfg := &redactTestConfig{
+		Cluster:        "default",
+		EngineAuthData: []byte(`{"registry":{"password":"hunter2"}}`),
+		Container: &redactTestContainer{
+			Name:        "web",
+			Environment: map[string]string{"DB_PASSWORD": "hunter2"},
+			Ports:       []uint16{80},
+		},
+	}
+	redacted := logRedacted("config", cfg).Ctx[1].(string)
+	if strings.Contains(redacted, "hunter2") {
+		t.Error("Expected sensitive fields to be redacted:", redacted)
+	}
+	for _, expected := range []string{"Cluster:default", "Name:web", "Ports:[80]"} {
+		if !strings.Contains(redacted, expected) {
+			t.Errorf("Expected %v in %v", expected, redacted)
+		}
+	}
+}
+
+func TestRedactJSONStrings(t *testing.T) {
+	message := `{"type":"PayloadMessage","message":{"tasks":[{"arn":"arn:task","containers":[{"name":"web","environment":{"KEY":"hunter2"}}]}]}}`
+	redacted := logRedacted("message", message).Ctx[1].(string)
+	if strings.Contains(redacted, "hunter2") {
+		t.Error("Expected the environment to be redacted:", redacted)
+	}
+	if !strings.Contains(redacted, `"arn":"arn:task"`) {
+		t.Error("Expected the rest of the message to be kept:", redacted)
+	}
+}
+
+func TestRedactSignedURLs(t *testing.T) {
+	err := errors.New("GET https://bucket.s3.amazonaws.com/key?X-Amz-Credential=AKID%2F20150101&X-Amz-Signature=abcdef&versionId=3 failed")
+	redacted := logRedacted("err", err).Ctx[1].(string)
+	if strings.Contains(redacted, "abcdef") || strings.Contains(redacted, "AKID") {
+		t.Error("Expected the signature to be redacted:", redacted)
+	}
+	if !strings.Contains(redacted, "versionId=3") {
+		t.Error("Expected other parameters to be kept:", redacted)
+	}
+}
+
+func TestSetRedactKeys(t *testing.T) {
+	defer SetRedactKeys()
+
+	if err := SetRedactKeys("^internal"); err != nil {
+		t.Fatal(err)
+	}
+	if record := logRedacted("internalId", "x"); record.Ctx[1] != Redacted {
+		t.Error("Expected the configured key to be redacted", record.Ctx)
+	}
+	if err := SetRedactKeys("("); err == nil {
+		t.Error("Expected an invalid pattern to fail")
+	}
+	if record := logRedacted("internalId", "x"); record.Ctx[1] != Redacted {
+		t.Error("Expected the previous keys to be kept after an error", record.Ctx)
+	}
+}
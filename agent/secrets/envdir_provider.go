@@ -0,0 +1,82 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package secrets
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EnvDirProvider reads secrets from the "*.env" files of a directory. Each
+// line of those files is NAME=VALUE; blank lines and lines starting with '#'
+// are skipped. Files are read in name order, and a later definition of a name
+// wins.
+type EnvDirProvider struct {
+	dir string
+}
+
+// NewEnvDirProvider returns a provider for the env files in dir
+func NewEnvDirProvider(dir string) *EnvDirProvider {
+	return &EnvDirProvider{dir: dir}
+}
+
+func (p *EnvDirProvider) GetSecret(name string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(p.dir, "*.env"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	value, found := "", false
+	for _, file := range files {
+		fileValue, ok, err := readEnvFile(file, name)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			value, found = fileValue, true
+		}
+	}
+	if !found {
+		return "", NotFoundError{name}
+	}
+	return value, nil
+}
+
+// readEnvFile returns the last value of name in the given env file
+func readEnvFile(file, name string) (string, bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	value, found := "", false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != name {
+			continue
+		}
+		value, found = parts[1], true
+	}
+	return value, found, scanner.Err()
+}
@@ -0,0 +1,110 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// EncryptedFileProvider reads secrets from a file holding a JSON object of
+// secret names to values, sealed with AES-256-GCM. The file is the random
+// nonce followed by the ciphertext; the key is the 32 bytes of the key file.
+// Both files are read on every lookup so that they may be rotated in place.
+type EncryptedFileProvider struct {
+	path    string
+	keyPath string
+}
+
+// NewEncryptedFileProvider returns a provider for the encrypted secrets file
+// at path, whose key is in the file at keyPath
+func NewEncryptedFileProvider(path, keyPath string) *EncryptedFileProvider {
+	return &EncryptedFileProvider{path: path, keyPath: keyPath}
+}
+
+func (p *EncryptedFileProvider) GetSecret(name string) (string, error) {
+	key, err := ioutil.ReadFile(p.keyPath)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openSecrets(key, sealed)
+	if err != nil {
+		return "", err
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return "", errors.New("secrets file does not hold a JSON object of strings")
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", NotFoundError{name}
+	}
+	return value, nil
+}
+
+// EncryptSecrets seals the given secrets in the format read by
+// EncryptedFileProvider
+func EncryptSecrets(key []byte, secrets map[string]string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openSecrets(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("secrets file is truncated")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		// Don't pass on the underlying error; it only ever says the
+		// authentication failed
+		return nil, errors.New("unable to decrypt secrets file; wrong key or corrupt file")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("secrets key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
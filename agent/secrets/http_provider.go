@@ -0,0 +1,69 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const httpProviderTimeout = 10 * time.Second
+
+// HTTPProvider looks secrets up from an HTTP endpoint. A GET of
+// {endpoint}/secrets/{name} must answer 200 with a body of
+// {"value": "..."}, or 404 if there is no such secret.
+type HTTPProvider struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTPProvider returns a provider for the given endpoint, such as
+// "http://localhost:8080"
+func NewHTTPProvider(endpoint string) *HTTPProvider {
+	return &HTTPProvider{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   &http.Client{Timeout: httpProviderTimeout},
+	}
+}
+
+type httpSecretResponse struct {
+	Value *string `json:"value"`
+}
+
+func (p *HTTPProvider) GetSecret(name string) (string, error) {
+	resp, err := p.client.Get(p.endpoint + "/secrets/" + url.PathEscape(name))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", NotFoundError{name}
+	default:
+		return "", errors.New("secrets endpoint returned status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	var secret httpSecretResponse
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil || secret.Value == nil {
+		return "", errors.New("secrets endpoint returned a malformed response")
+	}
+	return *secret.Value, nil
+}
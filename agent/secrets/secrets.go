@@ -0,0 +1,76 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package secrets resolves the secrets referenced by task definitions into
+// their values. Values are looked up when a container is created and are
+// never cached, persisted, or logged by this package.
+package secrets
+
+import (
+	"errors"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/logger"
+)
+
+var log = logger.ForModule("secrets")
+
+// Supported values of config.Config.SecretsProvider
+const (
+	ProviderFile   = "file"
+	ProviderEnvDir = "envdir"
+	ProviderHTTP   = "http"
+)
+
+// Provider looks up the value of a secret by name
+type Provider interface {
+	GetSecret(name string) (string, error)
+}
+
+// NotFoundError is returned by a Provider which has no secret with the
+// requested name
+type NotFoundError struct {
+	Name string
+}
+
+func (err NotFoundError) Error() string {
+	return "secret not found: " + err.Name
+}
+
+// NewProvider returns the Provider selected by the given config. If no
+// provider is configured, or the configured one is not known, every lookup
+// fails.
+func NewProvider(cfg *config.Config) Provider {
+	switch cfg.SecretsProvider {
+	case ProviderFile:
+		return NewEncryptedFileProvider(cfg.SecretsPath, cfg.SecretsKeyFile)
+	case ProviderEnvDir:
+		return NewEnvDirProvider(cfg.SecretsPath)
+	case ProviderHTTP:
+		return NewHTTPProvider(cfg.SecretsEndpoint)
+	case "":
+		return unavailableProvider{errors.New("no secrets provider is configured")}
+	default:
+		log.Error("Unrecognized secrets provider", "provider", cfg.SecretsProvider)
+		return unavailableProvider{errors.New("unrecognized secrets provider: " + cfg.SecretsProvider)}
+	}
+}
+
+// unavailableProvider fails every lookup with the same error
+type unavailableProvider struct {
+	err error
+}
+
+func (p unavailableProvider) GetSecret(name string) (string, error) {
+	return "", p.err
+}
@@ -0,0 +1,128 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package secrets
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ecs-secrets-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestEncryptedFileProvider(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	sealed, err := EncryptSecrets(key, map[string]string{"db-password": "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath, path := filepath.Join(dir, "key"), filepath.Join(dir, "secrets")
+	ioutil.WriteFile(keyPath, key, 0600)
+	ioutil.WriteFile(path, sealed, 0600)
+
+	provider := NewEncryptedFileProvider(path, keyPath)
+	value, err := provider.GetSecret("db-password")
+	if err != nil || value != "hunter2" {
+		t.Error("Expected the secret to be decrypted", value, err)
+	}
+	if _, err := provider.GetSecret("missing"); err != (NotFoundError{"missing"}) {
+		t.Error("Expected a not found error, got", err)
+	}
+
+	ioutil.WriteFile(keyPath, []byte("fedcba9876543210fedcba9876543210"), 0600)
+	if _, err := provider.GetSecret("db-password"); err == nil {
+		t.Error("Expected decrypting with the wrong key to fail")
+	}
+}
+
+func TestEnvDirProvider(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "a.env"), []byte("# comment\nTOKEN=first\nOTHER=x=y\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "b.env"), []byte("\nTOKEN=second\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "c.txt"), []byte("IGNORED=1\n"), 0600)
+
+	provider := NewEnvDirProvider(dir)
+	if value, err := provider.GetSecret("TOKEN"); err != nil || value != "second" {
+		t.Error("Expected the last file's definition to win", value, err)
+	}
+	if value, err := provider.GetSecret("OTHER"); err != nil || value != "x=y" {
+		t.Error("Expected values to keep any '='", value, err)
+	}
+	if _, err := provider.GetSecret("IGNORED"); err != (NotFoundError{"IGNORED"}) {
+		t.Error("Expected files without the .env suffix to be ignored", err)
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/secrets/api-key":
+			w.Write([]byte(`{"value":"s3cr3t"}`))
+		case "/secrets/db password":
+			w.Write([]byte(`{"value":"hunter2"}`))
+		case "/secrets/broken":
+			w.Write([]byte(`{}`))
+		case "/secrets/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewHTTPProvider(server.URL + "/")
+	if value, err := provider.GetSecret("api-key"); err != nil || value != "s3cr3t" {
+		t.Error("Expected the secret from the endpoint", value, err)
+	}
+	if value, err := provider.GetSecret("db password"); err != nil || value != "hunter2" {
+		t.Error("Expected the name to be escaped as a path segment", value, err)
+	}
+	if _, err := provider.GetSecret("api-key/../api-key"); err != (NotFoundError{"api-key/../api-key"}) {
+		t.Error("Expected a name with slashes to stay one path segment, got", err)
+	}
+	if _, err := provider.GetSecret("missing"); err != (NotFoundError{"missing"}) {
+		t.Error("Expected a not found error, got", err)
+	}
+	if _, err := provider.GetSecret("broken"); err == nil {
+		t.Error("Expected a response without a value to fail")
+	}
+	if _, err := provider.GetSecret("error"); err == nil {
+		t.Error("Expected a server error to fail")
+	}
+}
+
+func TestNewProviderUnconfigured(t *testing.T) {
+	for _, name := range []string{"", "unknown"} {
+		provider := NewProvider(&config.Config{SecretsProvider: name})
+		if _, err := provider.GetSecret("anything"); err == nil {
+			t.Error("Expected lookups to fail for provider", name)
+		}
+	}
+}
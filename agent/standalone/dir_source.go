@@ -0,0 +1,139 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package standalone
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+)
+
+// How often a task directory is checked for changes
+const dirPollInterval = 2 * time.Second
+
+// taskArnPrefix prefixes the Arn of tasks whose files do not give one
+const taskArnPrefix = "arn:aws:ecs:local:000000000000:task/"
+
+// DirSource reads tasks from the *.json files of a directory, one task per
+// file, checking it for changes periodically. A new file adds its task; if
+// the task has no Arn, it is named after the file. A changed file sends its
+// task again, which changes only its desired status; to change what a task
+// runs, give it a new Arn, and the task with the old one is stopped. A
+// removed file stops its task. Files that cannot be parsed are logged and
+// otherwise ignored until they change.
+type DirSource struct {
+	dir      string
+	interval time.Duration
+
+	files map[string]dirFile
+}
+
+type dirFile struct {
+	modTime time.Time
+	size    int64
+	arn     string // the Arn of the file's task, if it parsed
+}
+
+// NewDirSource returns a source of the task files in dir, which is checked
+// for changes every interval
+func NewDirSource(dir string, interval time.Duration) *DirSource {
+	return &DirSource{
+		dir:      dir,
+		interval: interval,
+		files:    make(map[string]dirFile),
+	}
+}
+
+// Start sends the tasks of the directory, and then checks it for changes in
+// the background
+func (source *DirSource) Start(tasks chan<- *api.Task) error {
+	if _, err := ioutil.ReadDir(source.dir); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			for _, task := range source.poll() {
+				tasks <- task
+			}
+			time.Sleep(source.interval)
+		}
+	}()
+	return nil
+}
+
+// poll returns the tasks to send for the changes made to the directory
+// since it was last polled
+func (source *DirSource) poll() []*api.Task {
+	infos, err := ioutil.ReadDir(source.dir)
+	if err != nil {
+		log.Warn("Could not read task directory", "dir", source.dir, "err", err)
+		return nil
+	}
+
+	var tasks []*api.Task
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		seen[name] = true
+		previous, known := source.files[name]
+		if known && previous.modTime.Equal(info.ModTime()) && previous.size == info.Size() {
+			continue
+		}
+		current := dirFile{modTime: info.ModTime(), size: info.Size(), arn: previous.arn}
+		source.files[name] = current
+
+		data, err := ioutil.ReadFile(filepath.Join(source.dir, name))
+		if err != nil {
+			log.Warn("Could not read task file", "file", name, "err", err)
+			continue
+		}
+		task, err := parseTask(data)
+		if err != nil {
+			log.Warn("Could not parse task file", "file", name, "err", err)
+			continue
+		}
+		if task.Arn == "" {
+			task.Arn = taskArnPrefix + strings.TrimSuffix(name, ".json")
+		}
+		if previous.arn != "" && previous.arn != task.Arn {
+			tasks = append(tasks, stoppedTask(previous.arn))
+		}
+		current.arn = task.Arn
+		source.files[name] = current
+		tasks = append(tasks, task)
+	}
+
+	for name, file := range source.files {
+		if seen[name] {
+			continue
+		}
+		delete(source.files, name)
+		if file.arn != "" {
+			tasks = append(tasks, stoppedTask(file.arn))
+		}
+	}
+	return tasks
+}
+
+// stoppedTask returns a task that changes the desired status of the task
+// with the given Arn to stopped
+func stoppedTask(arn string) *api.Task {
+	return &api.Task{Arn: arn, DesiredStatus: api.TaskStopped}
+}
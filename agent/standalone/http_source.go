@@ -0,0 +1,93 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package standalone
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+)
+
+// The most a posted task may be
+const maxTaskSize = 1 << 20
+
+// HTTPSource reads tasks posted, as JSON, to /v1/tasks at a local address.
+// Tasks must have an Arn; post a task with a desiredStatus of STOPPED to stop
+// it.
+type HTTPSource struct {
+	address string
+	tasks   chan<- *api.Task
+}
+
+type taskPostResponse struct {
+	Arn   string `json:"arn,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// NewHTTPSource returns a source of the tasks posted to address, such as
+// "127.0.0.1:51679"
+func NewHTTPSource(address string) *HTTPSource {
+	return &HTTPSource{address: address}
+}
+
+// Start listens at the source's address, serving in the background
+func (source *HTTPSource) Start(tasks chan<- *api.Task) error {
+	listener, err := net.Listen("tcp", source.address)
+	if err != nil {
+		return err
+	}
+	source.tasks = tasks
+	mux := http.NewServeMux()
+	mux.Handle("/v1/tasks", source)
+	go func() {
+		err := http.Serve(listener, mux)
+		log.Error("Standalone task endpoint stopped", "err", err)
+	}()
+	log.Info("Accepting tasks", "address", listener.Addr().String())
+	return nil
+}
+
+func (source *HTTPSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		respond(w, http.StatusMethodNotAllowed, taskPostResponse{Error: "tasks must be posted"})
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxTaskSize))
+	if err != nil {
+		respond(w, http.StatusBadRequest, taskPostResponse{Error: err.Error()})
+		return
+	}
+	task, err := parseTask(data)
+	if err != nil {
+		respond(w, http.StatusBadRequest, taskPostResponse{Error: "could not parse task: " + err.Error()})
+		return
+	}
+	if task.Arn == "" {
+		respond(w, http.StatusBadRequest, taskPostResponse{Error: "task has no arn"})
+		return
+	}
+	source.tasks <- task
+	respond(w, http.StatusAccepted, taskPostResponse{Arn: task.Arn})
+}
+
+func respond(w http.ResponseWriter, status int, response taskPostResponse) {
+	data, _ := json.Marshal(response)
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,120 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package standalone
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/ecs_client/authv4/credentials"
+	"github.com/aws/amazon-ecs-agent/agent/utils"
+)
+
+// StateChange is a task or container state change, as written by a Sink
+type StateChange struct {
+	Time          time.Time         `json:"time"`
+	TaskArn       string            `json:"taskArn"`
+	ContainerName string            `json:"containerName,omitempty"`
+	Status        string            `json:"status"`
+	Reason        string            `json:"reason,omitempty"`
+	ExitCode      *int              `json:"exitCode,omitempty"`
+	PortBindings  []api.PortBinding `json:"portBindings,omitempty"`
+}
+
+// Sink is an api.ECSClient that writes the state changes submitted to it,
+// one JSON object per line, instead of submitting them to ECS. Every state
+// change is written, not only those ECS accepts.
+type Sink struct {
+	lock   sync.Mutex
+	writer io.Writer
+	now    func() time.Time
+}
+
+// NewSink returns a sink appending to the file at path, or only logging
+// state changes if path is empty
+func NewSink(path string) (*Sink, error) {
+	sink := &Sink{now: time.Now}
+	if path == "" {
+		return sink, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sink.writer = file
+	return sink, nil
+}
+
+func (sink *Sink) write(change StateChange) utils.RetriableError {
+	change.Time = sink.now()
+	log.Info("State change", "change", change)
+	if sink.writer == nil {
+		return nil
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return utils.NewRetriableError(utils.NewRetriable(false), err)
+	}
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if _, err := sink.writer.Write(append(data, '\n')); err != nil {
+		return utils.NewRetriableError(utils.NewRetriable(true), err)
+	}
+	return nil
+}
+
+func (sink *Sink) SubmitTaskStateChange(change api.ContainerStateChange) utils.RetriableError {
+	if change.TaskStatus == api.TaskStatusNone {
+		return utils.NewRetriableError(utils.NewRetriable(false), errors.New("SubmitTaskStateChange called with an invalid change"))
+	}
+	return sink.write(StateChange{
+		TaskArn: change.TaskArn,
+		Status:  change.TaskStatus.String(),
+	})
+}
+
+func (sink *Sink) SubmitContainerStateChange(change api.ContainerStateChange) utils.RetriableError {
+	return sink.write(StateChange{
+		TaskArn:       change.TaskArn,
+		ContainerName: change.ContainerName,
+		Status:        change.Status.String(),
+		Reason:        change.Reason,
+		ExitCode:      change.ExitCode,
+		PortBindings:  change.PortBindings,
+	})
+}
+
+// RegisterContainerInstance returns ContainerInstanceArn; there is nothing
+// to register with
+func (sink *Sink) RegisterContainerInstance() (string, error) {
+	return ContainerInstanceArn, nil
+}
+
+func (sink *Sink) DiscoverPollEndpoint(containerInstanceArn string) (string, error) {
+	return "", errors.New("There is no poll endpoint in standalone mode")
+}
+
+func (sink *Sink) DeregisterContainerInstance(containerInstanceArn string) error {
+	return nil
+}
+
+// CredentialProvider returns nil; nothing is signed in standalone mode
+func (sink *Sink) CredentialProvider() credentials.AWSCredentialProvider {
+	return nil
+}
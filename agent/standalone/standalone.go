@@ -0,0 +1,89 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package standalone runs the agent without an ECS backend. Tasks are read
+// from local sources, a watched directory of task files or a local HTTP
+// endpoint, instead of ACS, and state changes are written to a local sink
+// instead of being submitted to ECS.
+package standalone
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/engine"
+	"github.com/aws/amazon-ecs-agent/agent/logger"
+	"github.com/aws/amazon-ecs-agent/agent/statemanager"
+)
+
+var log = logger.ForModule("standalone")
+
+// ContainerInstanceArn is the container instance a standalone agent
+// "registers" as
+const ContainerInstanceArn = "arn:aws:ecs:local:000000000000:container-instance/standalone"
+
+// A Source provides tasks to run. A task sent again with the same Arn only
+// changes the task's desired status, as it does when it comes from ACS.
+type Source interface {
+	// Start begins sending tasks to the given channel, returning once the
+	// source is ready
+	Start(tasks chan<- *api.Task) error
+}
+
+// Sources returns the task sources configured in cfg
+func Sources(cfg *config.Config) []Source {
+	var sources []Source
+	if cfg.StandaloneTaskDir != "" {
+		sources = append(sources, NewDirSource(cfg.StandaloneTaskDir, dirPollInterval))
+	}
+	if cfg.StandaloneListenAddress != "" {
+		sources = append(sources, NewHTTPSource(cfg.StandaloneListenAddress))
+	}
+	return sources
+}
+
+// Run adds the tasks from each of sources to taskEngine, saving after each,
+// until every source stops; in practice, forever
+func Run(sources []Source, taskEngine engine.TaskEngine, saver statemanager.Saver) error {
+	if len(sources) == 0 {
+		return errors.New("Standalone mode needs a task directory or a listen address to read tasks from")
+	}
+	tasks := make(chan *api.Task)
+	for _, source := range sources {
+		if err := source.Start(tasks); err != nil {
+			return err
+		}
+	}
+	for task := range tasks {
+		log.Info("Adding task", "arn", task.Arn, "desiredStatus", task.DesiredStatus.String())
+		taskEngine.AddTask(task)
+		if err := saver.Save(); err != nil {
+			log.Error("Error saving state", "err", err)
+		}
+	}
+	return nil
+}
+
+// parseTask parses a task document. Tasks with no desired status are run.
+func parseTask(data []byte) (*api.Task, error) {
+	var task api.Task
+	if err := json.Unmarshal(data, &task); err != nil {
+		return nil, err
+	}
+	if task.DesiredStatus == api.TaskStatusNone {
+		task.DesiredStatus = api.TaskRunning
+	}
+	return &task, nil
+}
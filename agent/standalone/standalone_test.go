@@ -0,0 +1,168 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package standalone
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+)
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecs_standalone_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := NewDirSource(dir, time.Second)
+
+	ioutil.WriteFile(filepath.Join(dir, "web.json"), []byte(`{"family":"web","containers":[{"name":"nginx","image":"nginx"}]}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "db.json"), []byte(`{"arn":"db-arn","desiredStatus":"STOPPED"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte(`{}`), 0644)
+
+	tasks := source.poll()
+	if len(tasks) != 2 {
+		t.Fatal("Expected a task for each parseable task file, got", len(tasks))
+	}
+	byArn := make(map[string]*api.Task)
+	for _, task := range tasks {
+		byArn[task.Arn] = task
+	}
+	web, ok := byArn[taskArnPrefix+"web"]
+	if !ok || web.DesiredStatus != api.TaskRunning || len(web.Containers) != 1 {
+		t.Error("Expected a running task named after its file", tasks)
+	}
+	if db, ok := byArn["db-arn"]; !ok || db.DesiredStatus != api.TaskStopped {
+		t.Error("Expected the task's own Arn and desired status to be kept", tasks)
+	}
+
+	if tasks := source.poll(); len(tasks) != 0 {
+		t.Error("Expected no tasks when nothing changed, got", len(tasks))
+	}
+
+	// Changing a task's Arn stops the old one; removing a file stops its task
+	ioutil.WriteFile(filepath.Join(dir, "web.json"), []byte(`{"arn":"web-2","family":"web"}`), 0644)
+	os.Chtimes(filepath.Join(dir, "web.json"), time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	os.Remove(filepath.Join(dir, "db.json"))
+	tasks = source.poll()
+	desired := make(map[string]api.TaskStatus)
+	for _, task := range tasks {
+		desired[task.Arn] = task.DesiredStatus
+	}
+	expected := map[string]api.TaskStatus{
+		taskArnPrefix + "web": api.TaskStopped,
+		"web-2":               api.TaskRunning,
+		"db-arn":              api.TaskStopped,
+	}
+	if len(desired) != len(expected) {
+		t.Fatal("Expected the changes to be sent, got", desired)
+	}
+	for arn, status := range expected {
+		if actual := desired[arn]; actual != status {
+			t.Errorf("Expected %s to be %s, got %s", arn, status.String(), actual.String())
+		}
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	tasks := make(chan *api.Task, 1)
+	source := NewHTTPSource("127.0.0.1:0")
+	source.tasks = tasks
+	server := httptest.NewServer(source)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"arn":"task-arn","containers":[{"name":"c"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Error("Expected the task to be accepted, got", resp.StatusCode)
+	}
+	task := <-tasks
+	if task.Arn != "task-arn" || task.DesiredStatus != api.TaskRunning {
+		t.Error("Expected the posted task to run", task)
+	}
+
+	for _, body := range []string{`{"containers":[]}`, `{`} {
+		resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %v", body, resp.StatusCode)
+		}
+	}
+
+	resp, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Error("Expected only posts to be allowed, got", resp.StatusCode)
+	}
+}
+
+func TestSink(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink := &Sink{writer: &out, now: func() time.Time { return now }}
+
+	exitCode := 1
+	if err := sink.SubmitContainerStateChange(api.ContainerStateChange{TaskArn: "task", ContainerName: "c", Status: api.ContainerStopped, ExitCode: &exitCode}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.SubmitTaskStateChange(api.ContainerStateChange{TaskArn: "task", TaskStatus: api.TaskStopped}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.SubmitTaskStateChange(api.ContainerStateChange{TaskArn: "task"}); err == nil || err.Retry() {
+		t.Error("Expected a change with no task status to be rejected")
+	}
+
+	var changes []StateChange
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var change StateChange
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) != 2 {
+		t.Fatal("Expected a line per state change, got", len(changes))
+	}
+	if changes[0].ContainerName != "c" || changes[0].Status != "STOPPED" || changes[0].ExitCode == nil || *changes[0].ExitCode != 1 || !changes[0].Time.Equal(now) {
+		t.Error("Unexpected container state change", changes[0])
+	}
+	if changes[1].ContainerName != "" || changes[1].Status != "STOPPED" {
+		t.Error("Unexpected task state change", changes[1])
+	}
+
+	if arn, _ := sink.RegisterContainerInstance(); arn != ContainerInstanceArn {
+		t.Error("Expected the standalone container instance, got", arn)
+	}
+}
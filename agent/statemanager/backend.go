@@ -0,0 +1,145 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package statemanager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+)
+
+// A Backend stores saved state. It is given, and returns, the plaintext JSON
+// of the whole state; how it is laid out on disk, and whether it is
+// encrypted there, is up to the backend.
+type Backend interface {
+	// Read returns the saved state, or nil if none has been saved
+	Read() ([]byte, error)
+	// Write replaces the saved state. It must not return until the state is
+	// durable, and must leave either the old state or the new one in place
+	// should the agent stop part way through.
+	Write(data []byte) error
+	// Exists returns whether the backend has anything saved, without
+	// reading it
+	Exists() bool
+	Close() error
+}
+
+// stateCodec encodes saved state for storage and decodes it again, such as
+// by encrypting it
+type stateCodec interface {
+	encodeStateFile(plaintext []byte) ([]byte, error)
+	decodeStateFile(data []byte) ([]byte, error)
+}
+
+// NewBackend returns the backend of the given type, from config.StateBackend*,
+// for the state in dataDir. The keyFile, if set, is the key saved state is
+// encrypted with.
+func NewBackend(backendType, dataDir, keyFile string) (Backend, error) {
+	manager := &basicStateManager{statePath: dataDir}
+	if keyFile != "" {
+		manager.keyProvider = NewFileKeyProvider(keyFile)
+	}
+	return newBackend(backendType, dataDir, manager)
+}
+
+func newBackend(backendType, dataDir string, codec stateCodec) (Backend, error) {
+	switch backendType {
+	case "", config.StateBackendJSON:
+		return &fileBackend{path: filepath.Join(dataDir, ecsDataFile), codec: codec}, nil
+	case config.StateBackendKV:
+		return &kvBackend{path: filepath.Join(dataDir, ecsKVDataFile), codec: codec}, nil
+	}
+	return nil, errors.New("Unknown state backend: " + backendType)
+}
+
+// otherBackendTypes returns the backend types other than the given one
+func otherBackendTypes(backendType string) []string {
+	var others []string
+	for _, other := range []string{config.StateBackendJSON, config.StateBackendKV} {
+		if other != backendType && !(backendType == "" && other == config.StateBackendJSON) {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
+// fileBackend stores saved state as a single JSON file, replaced in full on
+// every save
+type fileBackend struct {
+	path  string
+	codec stateCodec
+}
+
+func (backend *fileBackend) Read() ([]byte, error) {
+	data, err := ioutil.ReadFile(backend.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return backend.codec.decodeStateFile(data)
+}
+
+func (backend *fileBackend) Write(data []byte) error {
+	data, err := backend.codec.encodeStateFile(data)
+	if err != nil {
+		return errors.New("could not encrypt data: " + err.Error())
+	}
+	return writeFileAtomic(filepath.Dir(backend.path), filepath.Base(backend.path), data)
+}
+
+func (backend *fileBackend) Exists() bool {
+	_, err := os.Stat(backend.path)
+	return err == nil
+}
+
+func (backend *fileBackend) Close() error {
+	return nil
+}
+
+// ConvertBackend copies the state in dataDir saved with the from backend to
+// the to backend. The source is left in place; the destination must not
+// already have state saved, so that converting never overwrites anything.
+// The agent must not be running.
+func ConvertBackend(dataDir, keyFile, from, to string) error {
+	if from == to {
+		return errors.New("Cannot convert state to the backend it is already in")
+	}
+	source, err := NewBackend(from, dataDir, keyFile)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := NewBackend(to, dataDir, keyFile)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	if destination.Exists() {
+		return errors.New("State is already saved with the " + to + " backend; remove it to convert again")
+	}
+	data, err := source.Read()
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return errors.New("No state is saved with the " + from + " backend")
+	}
+	return destination.Write(data)
+}
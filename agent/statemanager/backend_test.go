@@ -0,0 +1,88 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package statemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+)
+
+// countingCodec counts the values encoded, leaving them as they are
+type countingCodec struct {
+	encoded int
+}
+
+func (codec *countingCodec) encodeStateFile(plaintext []byte) ([]byte, error) {
+	codec.encoded++
+	return plaintext, nil
+}
+
+func (codec *countingCodec) decodeStateFile(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func TestKVBackendWritesChangedKeys(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "ecs_statemanager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	codec := &countingCodec{}
+	backend, _ := newBackend(config.StateBackendKV, tmpDir, codec)
+	state := `{"Data":{"TaskEngine":{"Tasks":[{"Arn":"a","Containers":[{"Name":"c"}]},{"Arn":"b"}]}},"Version":1}`
+	if err := backend.Write([]byte(state)); err != nil {
+		t.Fatal(err)
+	}
+	written := codec.encoded
+
+	// Change one task; only it should be rewritten
+	codec.encoded = 0
+	state = `{"Data":{"TaskEngine":{"Tasks":[{"Arn":"a","Containers":[{"Name":"c"}]},{"Arn":"b","KnownStatus":"RUNNING"}]}},"Version":1}`
+	if err := backend.Write([]byte(state)); err != nil {
+		t.Fatal(err)
+	}
+	if codec.encoded != 1 {
+		t.Errorf("Expected only the changed task to be written, wrote %v of %v values", codec.encoded, written)
+	}
+
+	// Remove a task; nothing new is written
+	codec.encoded = 0
+	state = `{"Data":{"TaskEngine":{"Tasks":[{"Arn":"b","KnownStatus":"RUNNING"}]}},"Version":1}`
+	if err := backend.Write([]byte(state)); err != nil {
+		t.Fatal(err)
+	}
+	if codec.encoded != 0 {
+		t.Error("Expected removing a task to write nothing, wrote", codec.encoded)
+	}
+	backend.Close()
+
+	backend, _ = newBackend(config.StateBackendKV, tmpDir, codec)
+	defer backend.Close()
+	data, err := backend.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected, actual interface{}
+	json.Unmarshal([]byte(state), &expected)
+	json.Unmarshal(data, &actual)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Error("Expected to read back what was written, got", string(data))
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package statemanager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+)
+
+// Backup copies the state saved in the data directory of cfg, and its
+// journal, to backup files beside it, returning the path of the state's
+// backup. The state is backed up as a JSON state file, encrypted if state
+// is, whatever the backend; to restore it, move it and the journal's backup
+// back to ecs_agent_data.json and ecs_agent_journal.json.
+func Backup(cfg *config.Config) (string, error) {
+	manager := &basicStateManager{statePath: cfg.DataDir}
+	if cfg.StateKeyFile != "" {
+		manager.keyProvider = NewFileKeyProvider(cfg.StateKeyFile)
+	}
+	backend, err := newBackend(cfg.StateBackend, cfg.DataDir, manager)
+	if err != nil {
+		return "", err
+	}
+	defer backend.Close()
+	data, err := backend.Read()
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		return "", errors.New("No state is saved in " + cfg.DataDir)
+	}
+	data, err = manager.encodeStateFile(data)
+	if err != nil {
+		return "", err
+	}
+
+	// Named to the nanosecond so that repeated repairs never overwrite the
+	// original's backup
+	suffix := "." + time.Now().UTC().Format("20060102T150405.000000000Z") + ".bak"
+	journal, err := ioutil.ReadFile(filepath.Join(cfg.DataDir, ecsJournalFile))
+	if err == nil {
+		err = writeFileAtomic(cfg.DataDir, ecsJournalFile+suffix, journal)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return "", errors.New("could not back up state journal: " + err.Error())
+	}
+	if err := writeFileAtomic(cfg.DataDir, ecsDataFile+suffix, data); err != nil {
+		return "", errors.New("could not back up state: " + err.Error())
+	}
+	return filepath.Join(cfg.DataDir, ecsDataFile+suffix), nil
+}
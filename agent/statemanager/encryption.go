@@ -0,0 +1,185 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package statemanager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// The version of the encrypted state envelope
+const encryptedStateVersion = 1
+
+// KeyProvider provides the key which encrypts the keys of saved state. Keys
+// must be 32 bytes, for AES-256.
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+// fileKeyProvider reads the key from a file each time it is needed so that
+// the file may be replaced while the agent runs
+type fileKeyProvider struct {
+	path string
+}
+
+// NewFileKeyProvider returns a KeyProvider which reads the key from the file
+// at path
+func NewFileKeyProvider(path string) KeyProvider {
+	return &fileKeyProvider{path: path}
+}
+
+func (p *fileKeyProvider) Key() ([]byte, error) {
+	return ioutil.ReadFile(p.path)
+}
+
+// WithKeyProvider is an option that encrypts saved state with keys wrapped by
+// the given provider's key
+func WithKeyProvider(provider KeyProvider) Option {
+	return (Option)(func(m StateManager) {
+		manager, ok := m.(*basicStateManager)
+		if !ok {
+			log.Crit("Unable to set key provider; unknown instantiation")
+			return
+		}
+		manager.keyProvider = provider
+	})
+}
+
+// encryptedState is the envelope saved in place of plaintext state. The state
+// is sealed with a data key generated for each save, and that data key is in
+// turn sealed with the provider's key.
+type encryptedState struct {
+	EncryptedStateVersion int `json:"ecsEncryptedStateVersion"`
+	// KeyFingerprint identifies the provider's key without revealing it so
+	// that a mismatched key can be reported clearly
+	KeyFingerprint string `json:"keyFingerprint"`
+	WrappedKey     []byte `json:"wrappedKey"`
+	Ciphertext     []byte `json:"ciphertext"`
+}
+
+// encryptState seals plaintext state in an envelope under the given key
+func encryptState(key, plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := seal(key, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encryptedState{
+		EncryptedStateVersion: encryptedStateVersion,
+		KeyFingerprint:        keyFingerprint(key),
+		WrappedKey:            wrappedKey,
+		Ciphertext:            ciphertext,
+	})
+}
+
+// decodeStateFile returns the plaintext state held by a state file, which
+// may be an encrypted envelope or legacy plaintext state
+func (manager *basicStateManager) decodeStateFile(data []byte) ([]byte, error) {
+	var envelope encryptedState
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.EncryptedStateVersion == 0 {
+		// Plaintext; any error is reported when it is parsed as state
+		return data, nil
+	}
+	if envelope.EncryptedStateVersion > encryptedStateVersion {
+		return nil, errors.New("Unsupported encrypted state version")
+	}
+	if manager.keyProvider == nil {
+		return nil, errors.New("State file is encrypted but no state key is configured")
+	}
+	key, err := manager.keyProvider.Key()
+	if err != nil {
+		return nil, err
+	}
+	if fingerprint := keyFingerprint(key); fingerprint != envelope.KeyFingerprint {
+		return nil, errors.New("State file was encrypted with a different key; expected key " + envelope.KeyFingerprint + " but have " + fingerprint)
+	}
+	dataKey, err := open(key, envelope.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(dataKey, envelope.Ciphertext)
+}
+
+// encodeStateFile returns the contents of a state file for the given
+// plaintext state, encrypted if a key provider is configured
+func (manager *basicStateManager) encodeStateFile(plaintext []byte) ([]byte, error) {
+	if manager.keyProvider == nil {
+		return plaintext, nil
+	}
+	key, err := manager.keyProvider.Key()
+	if err != nil {
+		return nil, err
+	}
+	return encryptState(key, plaintext)
+}
+
+// keyFingerprint is the first 8 bytes, in hex, of the SHA-256 of a domain
+// separated key
+func keyFingerprint(key []byte) string {
+	sum := sha256.Sum256(append([]byte("ecs-agent-state-key:"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// seal encrypts plaintext with AES-256-GCM, prefixing the random nonce
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Encrypted state is truncated")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("Unable to decrypt state; it is corrupt")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("State key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,218 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package statemanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Filename of the journal in the ECS_DATADIR
+const ecsJournalFile = "ecs_agent_journal.json"
+
+// Journal is a StateManager that can durably record individual mutations of
+// its saveables between saves. Recorded mutations are replayed by Load onto
+// the last saved state.
+type Journal interface {
+	Record(entryType string, entry interface{}) error
+}
+
+// JournalReplayer applies the journaled mutations of a saveable. Entries may
+// be replayed onto state that already includes them, so applying one must
+// be idempotent.
+type JournalReplayer interface {
+	ReplayJournalEntry(entryType string, entry json.RawMessage) error
+}
+
+// Record records a mutation in saver's journal if it has one. The mutation
+// must already have been made to the saveable, so that any later save
+// includes it. Entries should be pointers so that they marshal as saveables
+// do.
+func Record(saver Saver, entryType string, entry interface{}) error {
+	journal, ok := saver.(Journal)
+	if !ok {
+		return nil
+	}
+	err := journal.Record(entryType, entry)
+	if err != nil {
+		log.Error("Error recording state change", "type", entryType, "err", err)
+	}
+	return err
+}
+
+// WithJournalReplayer is an option that replays journaled mutations with the
+// given replayer when state is loaded
+func WithJournalReplayer(replayer JournalReplayer) Option {
+	return (Option)(func(m StateManager) {
+		manager, ok := m.(*basicStateManager)
+		if !ok {
+			log.Crit("Unable to add journal replayer; unknown instantiation")
+			return
+		}
+		manager.replayer = replayer
+	})
+}
+
+type journalEntry struct {
+	Seq   int64
+	Type  string
+	Entry json.RawMessage
+}
+
+// pendingEntry is a journal line, as written, that no save includes yet
+type pendingEntry struct {
+	seq  int64
+	line []byte
+}
+
+// Record appends an entry to the journal and syncs it to disk
+func (manager *basicStateManager) Record(entryType string, entry interface{}) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	manager.journalLock.Lock()
+	defer manager.journalLock.Unlock()
+
+	line, err := json.Marshal(journalEntry{Seq: manager.journalSeq + 1, Type: entryType, Entry: data})
+	if err != nil {
+		return err
+	}
+	line, err = manager.encodeStateFile(line)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if manager.journal == nil {
+		manager.journal, err = os.OpenFile(filepath.Join(manager.statePath, ecsJournalFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		if err := syncDir(manager.statePath); err != nil {
+			return err
+		}
+	}
+	if _, err := manager.journal.Write(line); err != nil {
+		return err
+	}
+	if err := manager.journal.Sync(); err != nil {
+		return err
+	}
+	manager.journalSeq++
+	manager.pending = append(manager.pending, pendingEntry{seq: manager.journalSeq, line: line})
+	return nil
+}
+
+// compactJournal drops the entries a save up to seq includes
+func (manager *basicStateManager) compactJournal(seq int64) error {
+	manager.journalLock.Lock()
+	defer manager.journalLock.Unlock()
+
+	var remaining []pendingEntry
+	var data bytes.Buffer
+	for _, entry := range manager.pending {
+		if entry.seq > seq {
+			remaining = append(remaining, entry)
+			data.Write(entry.line)
+		}
+	}
+	if err := writeFileAtomic(manager.statePath, ecsJournalFile, data.Bytes()); err != nil {
+		return err
+	}
+	manager.pending = remaining
+	if manager.journal != nil {
+		manager.journal.Close()
+		manager.journal = nil
+	}
+	return nil
+}
+
+// replayJournal replays the journal entries after seq onto the loaded state.
+// If lenient, entries that do not decode or replay are skipped, and a
+// description of each is returned.
+func (manager *basicStateManager) replayJournal(seq int64, lenient bool) ([]string, error) {
+	manager.journalLock.Lock()
+	defer manager.journalLock.Unlock()
+
+	manager.journalSeq = seq
+	file, err := os.Open(filepath.Join(manager.statePath, ecsJournalFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var complete int64 // the length of the complete entries read
+	var skipped []string
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if len(line) == 0 {
+				return skipped, nil
+			}
+			// A crash while appending leaves a partial last line; the change
+			// it recorded was never acknowledged. Drop it so that later
+			// entries are not appended to it.
+			log.Warn("Dropping incomplete last journal entry")
+			return skipped, os.Truncate(file.Name(), complete)
+		}
+		complete += int64(len(line))
+		entry, err := manager.replayJournalLine(line, seq)
+		if err != nil {
+			if !lenient {
+				return nil, err
+			}
+			log.Warn("Skipping journal entry that does not replay", "line", lineNumber, "err", err)
+			skipped = append(skipped, "journal entry on line "+strconv.Itoa(lineNumber)+": "+err.Error())
+			continue
+		}
+		if entry == nil {
+			continue
+		}
+		manager.journalSeq = entry.Seq
+		manager.pending = append(manager.pending, pendingEntry{seq: entry.Seq, line: line})
+	}
+}
+
+// replayJournalLine replays a line of the journal, returning its entry, or
+// nil if the entry is included in the state saved up to seq
+func (manager *basicStateManager) replayJournalLine(line []byte, seq int64) (*journalEntry, error) {
+	data, err := manager.decodeStateFile(line)
+	if err != nil {
+		return nil, err
+	}
+	var entry journalEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if entry.Seq <= seq {
+		return nil, nil
+	}
+	if manager.replayer == nil {
+		log.Warn("No replayer for journal entry", "type", entry.Type)
+	} else if err := manager.replayer.ReplayJournalEntry(entry.Type, entry.Entry); err != nil {
+		log.Error("Error replaying journal entry", "type", entry.Type, "err", err)
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -0,0 +1,296 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package statemanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/amazon-ecs-agent/agent/statemanager/kvstore"
+)
+
+// Filename of the kv backend's store in the ECS_DATADIR
+const ecsKVDataFile = "ecs_agent_data.kv"
+
+const (
+	// kvRoot is the key of the root of saved state
+	kvRoot = "state"
+	// kvSplitDepth is how many levels of saved state are split into keys of
+	// their own: the state, its Data, each saveable, and each saveable's
+	// fields. The elements of those fields, such as each of the task
+	// engine's tasks and containers, are each stored under one key.
+	kvSplitDepth = 4
+)
+
+// kvNode is the value stored under each key of the kv backend. Objects and
+// arrays that are split have their members stored under keys of their own,
+// below the object's or array's key.
+type kvNode struct {
+	Kind  string          `json:"kind"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+const (
+	kvObject = "object"
+	kvArray  = "array"
+	kvValue  = "value"
+)
+
+// kvBackend stores saved state in an embedded key-value store, split so that
+// each task and container has a key of its own. Only the keys whose values
+// have changed since the last save are written.
+type kvBackend struct {
+	path  string
+	codec stateCodec
+
+	store *kvstore.Store
+	// written holds a hash of the plaintext value of each key as last read
+	// or written, to find the keys a save changes
+	written map[string][sha256.Size]byte
+}
+
+func (backend *kvBackend) open() error {
+	if backend.store != nil {
+		return nil
+	}
+	store, err := kvstore.Open(backend.path)
+	if err != nil {
+		return err
+	}
+	backend.store = store
+	backend.written = make(map[string][sha256.Size]byte)
+	return nil
+}
+
+func (backend *kvBackend) Read() ([]byte, error) {
+	if backend.store == nil {
+		if _, err := os.Stat(backend.path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	if err := backend.open(); err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]kvNode)
+	for _, key := range backend.store.Keys() {
+		value, _ := backend.store.Get(key)
+		plaintext, err := backend.codec.decodeStateFile(value)
+		if err != nil {
+			return nil, err
+		}
+		var node kvNode
+		if err := json.Unmarshal(plaintext, &node); err != nil {
+			return nil, errors.New("Corrupt value for " + key + ": " + err.Error())
+		}
+		nodes[key] = node
+		backend.written[key] = sha256.Sum256(plaintext)
+	}
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	return assembleKV(nodes)
+}
+
+func (backend *kvBackend) Write(data []byte) error {
+	if err := backend.open(); err != nil {
+		return err
+	}
+	nodes := make(map[string][]byte)
+	if err := splitKV(kvRoot, data, 0, nodes); err != nil {
+		return err
+	}
+
+	puts := make(map[string][]byte)
+	hashes := make(map[string][sha256.Size]byte, len(nodes))
+	for key, plaintext := range nodes {
+		hash := sha256.Sum256(plaintext)
+		hashes[key] = hash
+		if written, ok := backend.written[key]; ok && written == hash {
+			continue
+		}
+		encoded, err := backend.codec.encodeStateFile(plaintext)
+		if err != nil {
+			return errors.New("could not encrypt data: " + err.Error())
+		}
+		puts[key] = encoded
+	}
+	var deletes []string
+	for _, key := range backend.store.Keys() {
+		if _, ok := nodes[key]; !ok {
+			deletes = append(deletes, key)
+		}
+	}
+	if err := backend.store.Update(puts, deletes); err != nil {
+		return err
+	}
+	backend.written = hashes
+	return nil
+}
+
+func (backend *kvBackend) Exists() bool {
+	if backend.store == nil {
+		if _, err := os.Stat(backend.path); err != nil {
+			return false
+		}
+		if err := backend.open(); err != nil {
+			// Something is there, even if it cannot be read
+			return true
+		}
+	}
+	return len(backend.store.Keys()) != 0
+}
+
+func (backend *kvBackend) Close() error {
+	if backend.store == nil {
+		return nil
+	}
+	err := backend.store.Close()
+	backend.store = nil
+	return err
+}
+
+// splitKV adds the nodes for the JSON value raw, at key, to nodes
+func splitKV(key string, raw json.RawMessage, depth int, nodes map[string][]byte) error {
+	trimmed := bytes.TrimSpace(raw)
+	if depth < kvSplitDepth && len(trimmed) > 0 {
+		switch trimmed[0] {
+		case '{':
+			var members map[string]json.RawMessage
+			if err := json.Unmarshal(trimmed, &members); err != nil {
+				return err
+			}
+			for name, member := range members {
+				if err := splitKV(key+"/"+url.PathEscape(name), member, depth+1, nodes); err != nil {
+					return err
+				}
+			}
+			return putNode(key, kvNode{Kind: kvObject}, nodes)
+		case '[':
+			var elements []json.RawMessage
+			if err := json.Unmarshal(trimmed, &elements); err != nil {
+				return err
+			}
+			names := elementNames(elements)
+			for i, element := range elements {
+				if err := splitKV(key+"/"+names[i], element, depth+1, nodes); err != nil {
+					return err
+				}
+			}
+			return putNode(key, kvNode{Kind: kvArray}, nodes)
+		}
+	}
+	return putNode(key, kvNode{Kind: kvValue, Value: trimmed}, nodes)
+}
+
+func putNode(key string, node kvNode, nodes map[string][]byte) error {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	nodes[key] = data
+	return nil
+}
+
+// elementNames names the elements of an array. Elements with an Arn, such as
+// tasks, are named by it so that adding or removing one does not rename the
+// others; otherwise elements are named by their index. Named elements are
+// reassembled in the order of their names.
+func elementNames(elements []json.RawMessage) []string {
+	names := make([]string, len(elements))
+	seen := make(map[string]bool, len(elements))
+	for i, element := range elements {
+		var withArn struct {
+			Arn string
+		}
+		if json.Unmarshal(element, &withArn) != nil || withArn.Arn == "" || seen[withArn.Arn] {
+			names = nil
+			break
+		}
+		seen[withArn.Arn] = true
+		names[i] = "arn=" + url.PathEscape(withArn.Arn)
+	}
+	if names != nil {
+		return names
+	}
+	names = make([]string, len(elements))
+	for i := range elements {
+		names[i] = fmt.Sprintf("%08d", i)
+	}
+	return names
+}
+
+// assembleKV rebuilds the JSON of saved state from its nodes
+func assembleKV(nodes map[string]kvNode) ([]byte, error) {
+	children := make(map[string][]string)
+	for key := range nodes {
+		if key == kvRoot {
+			continue
+		}
+		slash := strings.LastIndex(key, "/")
+		if slash < 0 {
+			return nil, errors.New("Unexpected key in state store: " + key)
+		}
+		children[key[:slash]] = append(children[key[:slash]], key[slash+1:])
+	}
+	for _, names := range children {
+		sort.Strings(names)
+	}
+	if _, ok := nodes[kvRoot]; !ok {
+		return nil, errors.New("State store has no root")
+	}
+	return assembleNode(kvRoot, nodes, children)
+}
+
+func assembleNode(key string, nodes map[string]kvNode, children map[string][]string) (json.RawMessage, error) {
+	node, ok := nodes[key]
+	if !ok {
+		return nil, errors.New("State store is missing " + key)
+	}
+	switch node.Kind {
+	case kvValue:
+		return node.Value, nil
+	case kvObject:
+		members := make(map[string]json.RawMessage, len(children[key]))
+		for _, name := range children[key] {
+			member, err := assembleNode(key+"/"+name, nodes, children)
+			if err != nil {
+				return nil, err
+			}
+			unescaped, err := url.PathUnescape(name)
+			if err != nil {
+				return nil, err
+			}
+			members[unescaped] = member
+		}
+		return json.Marshal(members)
+	case kvArray:
+		elements := make([]json.RawMessage, 0, len(children[key]))
+		for _, name := range children[key] {
+			element, err := assembleNode(key+"/"+name, nodes, children)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+		}
+		return json.Marshal(elements)
+	}
+	return nil, errors.New("Unknown kind of " + key + ": " + node.Kind)
+}
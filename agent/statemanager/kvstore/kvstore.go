@@ -0,0 +1,377 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package kvstore is a small embedded key-value store kept in a single
+// append-only file.
+//
+// Changes are appended to the file in batches, each ending with a checksummed
+// commit record, and synced before Update returns; a batch is applied in full
+// or not at all. The file is compacted, by rewriting only the live keys, once
+// most of it is overwritten or deleted values. Every key and value is also
+// held in memory, so the store suits data that fits comfortably in memory but
+// is costly to rewrite in full on every change.
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	magic = "ECSKV1\n"
+
+	opPut    = 'P'
+	opDelete = 'D'
+	opCommit = 'C'
+
+	// The file is compacted once it is at least compactMinSize and more than
+	// compactRatio times the size of its live data
+	compactMinSize = 1 << 20
+	compactRatio   = 4
+)
+
+// Store is an open key-value store. It is safe for concurrent use.
+type Store struct {
+	path string
+
+	lock sync.RWMutex // guards the fields below
+	file *os.File
+	data map[string][]byte
+	size int64 // the size of the file
+	live int64 // the encoded size of the live data
+}
+
+// Open opens the store at path, creating it if it does not exist. Any
+// incomplete batch at the end of the file, left by a crash during an Update,
+// is dropped.
+func Open(path string) (*Store, error) {
+	store := &Store{path: path, data: make(map[string][]byte)}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := store.load(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if valid == 0 {
+		// New, or never had a batch committed
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if _, err := file.WriteAt([]byte(magic), 0); err != nil {
+			file.Close()
+			return nil, err
+		}
+		valid = int64(len(magic))
+	} else if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	store.file = file
+	store.size = valid
+	return store, nil
+}
+
+// load reads the committed batches of file into the store, returning the
+// length of the file they take up
+func (store *Store) load(file *os.File) (int64, error) {
+	reader := bufio.NewReader(file)
+	header := make([]byte, len(magic))
+	n, err := io.ReadFull(reader, header)
+	if string(header[:n]) != magic[:n] {
+		return 0, errors.New("kvstore: " + store.path + " is not a key-value store")
+	}
+	if err != nil {
+		// New, or a crash while writing the header; nothing was committed
+		return 0, nil
+	}
+
+	valid := int64(len(magic))
+	offset := valid
+	var batch bytes.Buffer
+	var puts map[string][]byte
+	var deletes []string
+	for {
+		start := batch.Len()
+		op, key, value, n, err := readRecord(reader, &batch)
+		if err != nil {
+			// EOF, or a batch cut short by a crash
+			return valid, nil
+		}
+		offset += int64(n)
+		switch op {
+		case opPut:
+			if puts == nil {
+				puts = make(map[string][]byte)
+			}
+			puts[key] = value
+		case opDelete:
+			deletes = append(deletes, key)
+			delete(puts, key)
+		case opCommit:
+			if crc32.ChecksumIEEE(batch.Bytes()[:start]) != binary.BigEndian.Uint32(value) {
+				return valid, nil
+			}
+			store.apply(puts, deletes)
+			valid = offset
+			batch.Reset()
+			puts, deletes = nil, nil
+		default:
+			return valid, nil
+		}
+	}
+}
+
+// readRecord reads one record, also copying its bytes to batch, and returns
+// the number of bytes read
+func readRecord(reader *bufio.Reader, batch *bytes.Buffer) (byte, string, []byte, int, error) {
+	op, err := reader.ReadByte()
+	if err != nil {
+		return 0, "", nil, 0, err
+	}
+	if op == opCommit {
+		sum := make([]byte, 4)
+		if _, err := io.ReadFull(reader, sum); err != nil {
+			return 0, "", nil, 0, io.ErrUnexpectedEOF
+		}
+		return op, "", sum, 5, nil
+	}
+	batch.WriteByte(op)
+	n := 1
+	key, read, err := readBytes(reader, batch)
+	n += read
+	if err != nil {
+		return 0, "", nil, n, err
+	}
+	if op == opDelete {
+		return op, string(key), nil, n, nil
+	}
+	value, read, err := readBytes(reader, batch)
+	n += read
+	return op, string(key), value, n, err
+}
+
+func readBytes(reader *bufio.Reader, batch *bytes.Buffer) ([]byte, int, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	prefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, length)
+	batch.Write(prefix[:n])
+	if length > 1<<31 {
+		return nil, n, errors.New("kvstore: corrupt record length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, n, io.ErrUnexpectedEOF
+	}
+	batch.Write(data)
+	return data, n + int(length), nil
+}
+
+// Get returns the value of key
+func (store *Store) Get(key string) ([]byte, bool) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	value, ok := store.data[key]
+	return value, ok
+}
+
+// Keys returns every key in the store, sorted
+func (store *Store) Keys() []string {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	keys := make([]string, 0, len(store.data))
+	for key := range store.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Update atomically sets the given keys and deletes the others given, and
+// syncs the change to disk. Deleting a key that does not exist is not an
+// error.
+func (store *Store) Update(puts map[string][]byte, deletes []string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.file == nil {
+		return errors.New("kvstore: store is closed")
+	}
+	if len(puts) == 0 && len(deletes) == 0 {
+		return nil
+	}
+
+	var batch bytes.Buffer
+	for _, key := range deletes {
+		writeRecord(&batch, opDelete, key, nil)
+	}
+	keys := make([]string, 0, len(puts))
+	for key := range puts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeRecord(&batch, opPut, key, puts[key])
+	}
+	commit(&batch)
+
+	_, err := store.file.Write(batch.Bytes())
+	if err == nil {
+		err = store.file.Sync()
+	}
+	if err != nil {
+		// Whatever was written is an incomplete, or not durable, batch;
+		// drop it so that it is not followed by a later one
+		store.file.Truncate(store.size)
+		store.file.Seek(store.size, io.SeekStart)
+		return err
+	}
+	store.size += int64(batch.Len())
+	store.apply(puts, deletes)
+
+	if store.size >= compactMinSize && store.size > compactRatio*store.live {
+		// The batch is committed either way; if compacting fails, the file
+		// is only larger than it need be until a later Update compacts it
+		store.compact()
+	}
+	return nil
+}
+
+// apply applies a committed batch to the in-memory data. The caller must hold
+// the write lock, or be the only user of store.
+func (store *Store) apply(puts map[string][]byte, deletes []string) {
+	for _, key := range deletes {
+		if value, ok := store.data[key]; ok {
+			store.live -= recordSize(key, value)
+			delete(store.data, key)
+		}
+	}
+	for key, value := range puts {
+		if old, ok := store.data[key]; ok {
+			store.live -= recordSize(key, old)
+		}
+		store.data[key] = value
+		store.live += recordSize(key, value)
+	}
+}
+
+// compact replaces the file with one holding only the live data. The caller
+// must hold the write lock.
+func (store *Store) compact() error {
+	var contents bytes.Buffer
+	contents.WriteString(magic)
+	var batch bytes.Buffer
+	keys := make([]string, 0, len(store.data))
+	for key := range store.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeRecord(&batch, opPut, key, store.data[key])
+	}
+	commit(&batch)
+	contents.Write(batch.Bytes())
+
+	dir := filepath.Dir(store.path)
+	tmpfile, err := ioutil.TempFile(dir, "tmp_"+filepath.Base(store.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+	err = tmpfile.Chmod(0600)
+	if err == nil {
+		_, err = tmpfile.Write(contents.Bytes())
+	}
+	if err == nil {
+		err = tmpfile.Sync()
+	}
+	if err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err := os.Rename(tmpfile.Name(), store.path); err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	store.file.Close()
+	store.file = tmpfile
+	store.size = int64(contents.Len())
+	return nil
+}
+
+// Close closes the store
+func (store *Store) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
+
+func writeRecord(batch *bytes.Buffer, op byte, key string, value []byte) {
+	batch.WriteByte(op)
+	writeBytes(batch, []byte(key))
+	if op == opPut {
+		writeBytes(batch, value)
+	}
+}
+
+func writeBytes(batch *bytes.Buffer, data []byte) {
+	prefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, uint64(len(data)))
+	batch.Write(prefix[:n])
+	batch.Write(data)
+}
+
+// commit ends a batch with its checksum
+func commit(batch *bytes.Buffer) {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(batch.Bytes()))
+	batch.WriteByte(opCommit)
+	batch.Write(sum)
+}
+
+// recordSize is the approximate size of a key and value once written
+func recordSize(key string, value []byte) int64 {
+	return int64(3 + len(key) + len(value))
+}
@@ -0,0 +1,158 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kvstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func tempStore(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "ecs_kvstore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "store.kv"), func() { os.RemoveAll(dir) }
+}
+
+func mustOpen(t *testing.T, path string) *Store {
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestUpdatePersists(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	store := mustOpen(t, path)
+	if err := store.Update(map[string][]byte{"a": []byte("1"), "b": []byte("2")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update(map[string][]byte{"a": []byte("3")}, []string{"b", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = mustOpen(t, path)
+	defer store.Close()
+	if keys := store.Keys(); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Error("Expected only a to remain, got", keys)
+	}
+	if value, ok := store.Get("a"); !ok || string(value) != "3" {
+		t.Error("Expected the latest value of a, got", string(value))
+	}
+}
+
+func TestIncompleteBatchDropped(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	store := mustOpen(t, path)
+	store.Update(map[string][]byte{"a": []byte("1")}, nil)
+	store.Update(map[string][]byte{"a": []byte("2"), "b": []byte("2")}, nil)
+	store.Close()
+
+	// Cut the last batch short, as a crash while writing it would
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-3)
+
+	store = mustOpen(t, path)
+	if value, _ := store.Get("a"); string(value) != "1" {
+		t.Error("Expected the incomplete batch to be dropped, got", string(value))
+	}
+	if _, ok := store.Get("b"); ok {
+		t.Error("Expected none of the incomplete batch to be applied")
+	}
+	// Later batches must not be lost behind the dropped one
+	store.Update(map[string][]byte{"c": []byte("3")}, nil)
+	store.Close()
+
+	store = mustOpen(t, path)
+	defer store.Close()
+	if value, _ := store.Get("c"); string(value) != "3" {
+		t.Error("Expected a batch after the dropped one to persist, got", string(value))
+	}
+}
+
+func TestCorruptBatchDropped(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	store := mustOpen(t, path)
+	store.Update(map[string][]byte{"a": []byte("1")}, nil)
+	store.Update(map[string][]byte{"a": []byte("2")}, nil)
+	store.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	// Flip the last value; its batch's checksum no longer matches
+	data[len(data)-6] ^= 0xff
+	ioutil.WriteFile(path, data, 0600)
+
+	store = mustOpen(t, path)
+	defer store.Close()
+	if value, _ := store.Get("a"); string(value) != "1" {
+		t.Error("Expected the corrupt batch to be dropped, got", string(value))
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	store := mustOpen(t, path)
+	for i := 0; i < 100; i++ {
+		store.Update(map[string][]byte{"a": make([]byte, 1000)}, nil)
+	}
+	store.Update(map[string][]byte{"b": []byte("2")}, nil)
+	before, _ := os.Stat(path)
+
+	store.lock.Lock()
+	err := store.compact()
+	store.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size()/10 {
+		t.Errorf("Expected compaction to shrink the file from %v, got %v", before.Size(), after.Size())
+	}
+
+	// Updates continue to the compacted file
+	store.Update(map[string][]byte{"c": []byte("3")}, nil)
+	store.Close()
+	store = mustOpen(t, path)
+	defer store.Close()
+	if keys := store.Keys(); !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Error("Expected every key to survive compaction, got", keys)
+	}
+}
+
+func TestOpenOtherFile(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	ioutil.WriteFile(path, []byte(`{"Data":{}}`), 0600)
+	if _, err := Open(path); err == nil {
+		t.Error("Expected opening a file that is not a store to fail")
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != `{"Data":{}}` {
+		t.Error("Expected the file to be left alone, got", string(data))
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package statemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// A Migration upgrades saved state by one data version. It is given the
+// plaintext JSON of the whole saved state, an object with "Data" and
+// "Version" keys, and returns the upgraded JSON; the Version key is updated
+// after it returns. Migrations must be pure functions of their input so that
+// they can be tested on their own.
+//
+// Only the saved state is migrated. Journal entries are replayed as they were
+// written, so a migration must not change the shape of anything the journal
+// records without the journal's replayer accepting both shapes.
+type Migration func(data []byte) ([]byte, error)
+
+// migrationRegistry holds, by the version they upgrade from, the migrations
+// between data versions
+type migrationRegistry map[int]Migration
+
+// migrations holds the migrations for every data version before
+// EcsDataVersion
+var migrations = make(migrationRegistry)
+
+// RegisterMigration registers the migration from data version from to
+// from+1. Every version from 1 up to EcsDataVersion must have one.
+func RegisterMigration(from int, migration Migration) {
+	migrations.register(from, migration)
+}
+
+func (registry migrationRegistry) register(from int, migration Migration) {
+	if _, exists := registry[from]; exists {
+		panic("statemanager: duplicate migration from data version " + strconv.Itoa(from))
+	}
+	registry[from] = migration
+}
+
+// migrate upgrades the saved state in data from version from to version to,
+// one version at a time
+func (registry migrationRegistry) migrate(data []byte, from, to int) ([]byte, error) {
+	for version := from; version < to; version++ {
+		migration, ok := registry[version]
+		if !ok {
+			return nil, errors.New("No migration from data version " + strconv.Itoa(version) + " to " + strconv.Itoa(version+1))
+		}
+		migrated, err := migration(data)
+		if err != nil {
+			return nil, errors.New("Migrating from data version " + strconv.Itoa(version) + " to " + strconv.Itoa(version+1) + ": " + err.Error())
+		}
+		data, err = setVersion(migrated, version+1)
+		if err != nil {
+			return nil, errors.New("Migrating from data version " + strconv.Itoa(version) + " to " + strconv.Itoa(version+1) + ": " + err.Error())
+		}
+	}
+	return data, nil
+}
+
+// setVersion sets the Version key of saved state, leaving the rest of it as
+// it is
+func setVersion(data []byte, version int) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["Version"] = json.RawMessage(strconv.Itoa(version))
+	return json.Marshal(fields)
+}
+
+// downgradeError explains that saved state is too new to be read
+func downgradeError(version int) error {
+	return errors.New("Saved state has data version " + strconv.Itoa(version) +
+		", written by a newer agent; this agent reads data versions up to " + strconv.Itoa(EcsDataVersion) +
+		" and cannot downgrade it. Run a newer agent, restore a backup of " + ecsDataFile +
+		" written by this agent's version, or remove it to start without the running tasks")
+}
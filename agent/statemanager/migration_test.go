@@ -0,0 +1,124 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package statemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+)
+
+// renameKey returns a migration that renames a key of the saved data
+func renameKey(from, to string) Migration {
+	return func(data []byte) ([]byte, error) {
+		var saved struct {
+			Data    map[string]json.RawMessage
+			Version int
+		}
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, err
+		}
+		saved.Data[to] = saved.Data[from]
+		delete(saved.Data, from)
+		return json.Marshal(saved)
+	}
+}
+
+func TestMigrateStepByStep(t *testing.T) {
+	registry := make(migrationRegistry)
+	registry.register(1, renameKey("a", "b"))
+	registry.register(2, renameKey("b", "c"))
+
+	migrated, err := registry.migrate([]byte(`{"Data":{"a":"value"},"Version":1}`), 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Data    map[string]string
+		Version int
+	}
+	json.Unmarshal(migrated, &saved)
+	if saved.Version != 3 || saved.Data["c"] != "value" || len(saved.Data) != 1 {
+		t.Error("Expected both migrations to apply in order, got", string(migrated))
+	}
+}
+
+func TestMigrateMissingStep(t *testing.T) {
+	registry := make(migrationRegistry)
+	registry.register(1, renameKey("a", "b"))
+
+	_, err := registry.migrate([]byte(`{"Data":{"a":"value"},"Version":1}`), 1, 3)
+	if err == nil || !strings.Contains(err.Error(), "No migration from data version 2") {
+		t.Error("Expected an error naming the missing migration, got", err)
+	}
+}
+
+func TestMigrateFailure(t *testing.T) {
+	registry := make(migrationRegistry)
+	registry.register(1, func([]byte) ([]byte, error) { return nil, errors.New("bad data") })
+
+	_, err := registry.migrate([]byte(`{"Data":{},"Version":1}`), 1, 2)
+	if err == nil || !strings.Contains(err.Error(), "bad data") {
+		t.Error("Expected the migration's error, got", err)
+	}
+}
+
+func TestMigrateBacksUpState(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "ecs_statemanager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	defer func(saved migrationRegistry) { migrations = saved }(migrations)
+	migrations = make(migrationRegistry)
+	migrations.register(EcsDataVersion-1, renameKey("old", "new"))
+
+	raw := []byte(`{"Data":{"old":"value"},"Version":0}`)
+	manager := &basicStateManager{statePath: tmpDir}
+	migrated, err := manager.migrate(raw, EcsDataVersion-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(migrated), `"new":"value"`) {
+		t.Error("Expected the state to be migrated, got", string(migrated))
+	}
+	backup, err := ioutil.ReadFile(filepath.Join(tmpDir, "ecs_agent_data.json.v0.bak"))
+	if err != nil || !bytes.Equal(backup, raw) {
+		t.Error("Expected the state to be backed up as it was", string(backup), err)
+	}
+}
+
+func TestLoadNewerVersion(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "ecs_statemanager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ecsDataFile), []byte(`{"Data":{},"Version":99}`), 0600)
+
+	manager := &basicStateManager{statePath: tmpDir, state: &state{Data: make(saveableState)}}
+	manager.backend, _ = newBackend(config.StateBackendJSON, tmpDir, manager)
+	err = manager.Load()
+	if err == nil || !strings.Contains(err.Error(), "cannot downgrade") {
+		t.Error("Expected a downgrade error, got", err)
+	}
+}
@@ -32,3 +32,8 @@ func (nsm *NoopStateManager) Save() error {
 func (nsm *NoopStateManager) Load() error {
 	return nil
 }
+
+// ForceSave does nothing, successfully
+func (nsm *NoopStateManager) ForceSave() error {
+	return nil
+}
@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -32,8 +33,8 @@ import (
 )
 
 // The current version of saved data. Any backwards or forwards incompatible
-// changes to the data-format should increment this number and retain the
-// ability to read old data versions.
+// changes to the data-format should increment this number and register a
+// Migration from the previous version with RegisterMigration.
 const EcsDataVersion = 1
 
 // Filename in the ECS_DATADIR
@@ -59,6 +60,18 @@ type ForceSaver interface {
 	ForceSave() error
 }
 
+// LenientLoader is a StateManager that can load corrupted state. What does
+// not decode is skipped, and described, rather than failing the load.
+type LenientLoader interface {
+	LoadLenient() ([]string, error)
+}
+
+// LenientUnmarshaler is a Saveable that can restore what decodes of
+// corrupted data, returning a description of each part it skipped
+type LenientUnmarshaler interface {
+	UnmarshalJSONLenient([]byte) ([]string, error)
+}
+
 // Option functions are functions that may be used as part of constructing a new
 // StateManager
 type Option func(StateManager)
@@ -77,6 +90,9 @@ type state struct {
 	Data saveableState
 
 	Version int
+	// JournalSeq is the sequence number of the last journal entry this state
+	// includes
+	JournalSeq int64 `json:",omitempty"`
 }
 
 type intermediateState struct {
@@ -84,7 +100,8 @@ type intermediateState struct {
 }
 
 type versionOnlyState struct {
-	Version int
+	Version    int
+	JournalSeq int64
 }
 
 // A StateManager can load and save state from disk.
@@ -99,6 +116,20 @@ type basicStateManager struct {
 
 	state *state // pointers to the data we should save / load into
 
+	// keyProvider, if set, provides the key saved state is encrypted under
+	keyProvider KeyProvider
+	// backend stores the saved state
+	backend     Backend
+	backendType string
+
+	saveLock sync.Mutex // serializes ForceSave so saves never go backwards
+
+	journalLock sync.Mutex // guards the fields below
+	journal     *os.File   // the journal, opened for appending, if open
+	journalSeq  int64      // the sequence number of the last journal entry
+	pending     []pendingEntry
+	replayer    JournalReplayer
+
 	sync.Mutex                // guards save times
 	lastSave        time.Time //the last time a save completed
 	nextPlannedSave time.Time //the next time a save is planned
@@ -125,6 +156,14 @@ func NewStateManager(cfg *config.Config, options ...Option) (StateManager, error
 		statePath: cfg.DataDir,
 		state:     state,
 	}
+	if cfg.StateKeyFile != "" {
+		manager.keyProvider = NewFileKeyProvider(cfg.StateKeyFile)
+	}
+	manager.backendType = cfg.StateBackend
+	manager.backend, err = newBackend(cfg.StateBackend, cfg.DataDir, manager)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, option := range options {
 		option(manager)
@@ -181,65 +220,169 @@ func (manager *basicStateManager) Save() error {
 // In addition, the StateManager internally buffers save requests in order to
 // only save at most every STATE_SAVE_INTERVAL.
 func (manager *basicStateManager) ForceSave() error {
+	manager.saveLock.Lock()
+	defer manager.saveLock.Unlock()
+
 	log.Info("Saving state!")
 	s := manager.state
 	s.Version = EcsDataVersion
+	// Every journaled change up to here has been made, so the state about to
+	// be marshaled includes it
+	manager.journalLock.Lock()
+	s.JournalSeq = manager.journalSeq
+	manager.journalLock.Unlock()
 
 	data, err := json.Marshal(s)
 	if err != nil {
 		log.Error("Error saving state; could not marshal data; this is odd", "err", err)
 		return err
 	}
+	err = manager.backend.Write(data)
+	if err != nil {
+		log.Error("Error saving state", "err", err)
+		return err
+	}
+	err = manager.compactJournal(s.JournalSeq)
+	if err != nil {
+		// The saved state includes what was dropped, so a longer journal is
+		// only wasteful
+		log.Warn("Error compacting state journal", "err", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the named file in dir with one holding data,
+// readable only by its owner. The data is synced to disk before the rename,
+// and the rename before returning, so that a crash leaves either the old file
+// or the new one.
+func writeFileAtomic(dir, name string, data []byte) error {
 	// Make our temp-file on the same volume as our data-file to ensure we can
 	// actually move it atomically; cross-device renaming will error out.
-	tmpfile, err := ioutil.TempFile(manager.statePath, "tmp_ecs_agent_data")
+	tmpfile, err := ioutil.TempFile(dir, "tmp_"+name)
 	if err != nil {
-		log.Error("Error saving state; could not create temp file to save state", "err", err)
-		return err
+		return errors.New("could not create temp file: " + err.Error())
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmpfile.Name())
+		}
+	}()
+	err = tmpfile.Chmod(0600)
+	if err == nil {
+		_, err = tmpfile.Write(data)
+	}
+	if err == nil {
+		err = tmpfile.Sync()
+	}
+	if closeErr := tmpfile.Close(); err == nil {
+		err = closeErr
 	}
-	_, err = tmpfile.Write(data)
 	if err != nil {
-		log.Error("Error saving state; could not write to temp file to save state", "err", err)
-		return err
+		return errors.New("could not write temp file: " + err.Error())
 	}
-	err = os.Rename(tmpfile.Name(), filepath.Join(manager.statePath, ecsDataFile))
+	err = os.Rename(tmpfile.Name(), filepath.Join(dir, name))
 	if err != nil {
-		log.Error("Error saving state; could not move to data file", "err", err)
+		return errors.New("could not move temp file: " + err.Error())
 	}
-	return err
+	renamed = true
+	return syncDir(dir)
+}
+
+// migrate backs up saved state and upgrades it to EcsDataVersion. The backup
+// is a JSON state file, encrypted if state is, whatever the backend. It is
+// kept until the next migration of the same version.
+func (manager *basicStateManager) migrate(data []byte, version int) ([]byte, error) {
+	backup := ecsDataFile + ".v" + strconv.Itoa(version) + ".bak"
+	encoded, err := manager.encodeStateFile(data)
+	if err == nil {
+		err = writeFileAtomic(manager.statePath, backup, encoded)
+	}
+	if err != nil {
+		return nil, errors.New("could not back up state before migrating: " + err.Error())
+	}
+	log.Info("Migrating state", "from", version, "to", EcsDataVersion, "backup", filepath.Join(manager.statePath, backup))
+	return migrations.migrate(data, version, EcsDataVersion)
+}
+
+// syncDir syncs a directory so that renames within it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 // Load reads state off the disk from the well-known filepath and loads it into
 // the passed State object.
 func (manager *basicStateManager) Load() error {
+	_, err := manager.load(false)
+	return err
+}
+
+// LoadLenient loads state as Load does, but skips the saved state, saveables,
+// and journal entries that do not decode. It returns a description of each
+// one skipped; saving afterwards drops them.
+func (manager *basicStateManager) LoadLenient() ([]string, error) {
+	return manager.load(true)
+}
+
+func (manager *basicStateManager) load(lenient bool) ([]string, error) {
 	// Note that even if Save overwrites the file we're looking at here, we
 	// still hold the old inode and should read the old data so no locking is
 	// needed (given Linux and the ext* family of fs at least).
 	s := manager.state
 	log.Info("Loading state!")
-	file, err := os.Open(filepath.Join(manager.statePath, ecsDataFile))
+	data, err := manager.backend.Read()
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Happens every first run; not a real error
-			return nil
-		}
-		return err
+		log.Error("Error reading existing state", "err", err)
+		return nil, err
 	}
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
-		log.Error("Error reading existing state file", "err", err)
-		return err
+	if data == nil {
+		// Check that state was not saved with another backend, which would
+		// otherwise be silently abandoned
+		for _, other := range otherBackendTypes(manager.backendType) {
+			otherBackend, err := newBackend(other, manager.statePath, manager)
+			if err != nil {
+				continue
+			}
+			exists := otherBackend.Exists()
+			otherBackend.Close()
+			if exists {
+				return nil, errors.New("State was saved with the " + other + " backend; convert it to the configured backend before starting")
+			}
+		}
+		// Happens every first run; not a real error. The agent may have
+		// stopped before its first save, though, so there may be changes
+		// journaled.
+		return manager.replayJournal(0, lenient)
 	}
 	// Dry-run to make sure this is a version we can understand
 	tmps := versionOnlyState{}
 	err = json.Unmarshal(data, &tmps)
 	if err != nil {
 		log.Crit("Could not unmarshal existing state; corrupted data?", "err", err, "data", data)
-		return err
+		if !lenient {
+			return nil, err
+		}
+		// Nothing saved can be recovered, but the journal may still hold
+		// changes
+		skipped, replayErr := manager.replayJournal(0, lenient)
+		return append([]string{"saved state: " + err.Error()}, skipped...), replayErr
 	}
 	if tmps.Version > EcsDataVersion {
-		strversion := strconv.Itoa(tmps.Version)
-		return errors.New("Unsupported data format: Version " + strversion + " not " + strconv.Itoa(EcsDataVersion))
+		err = downgradeError(tmps.Version)
+		log.Crit("Unable to load state", "err", err)
+		return nil, err
+	}
+	if tmps.Version > 0 && tmps.Version < EcsDataVersion {
+		data, err = manager.migrate(data, tmps.Version)
+		if err != nil {
+			log.Crit("Unable to migrate state", "from", tmps.Version, "to", EcsDataVersion, "err", err)
+			return nil, err
+		}
 	}
 	// Now load it into the actual state. The reason we do this with the
 	// intermediate state is that we *must* unmarshal directly into the
@@ -252,22 +395,46 @@ func (manager *basicStateManager) Load() error {
 	err = json.Unmarshal(data, &intermediate)
 	if err != nil {
 		log.Debug("Could not unmarshal into intermediate")
-		return err
+		return nil, err
 	}
 
+	var skipped []string
 	for key, rawJSON := range intermediate.Data {
 		actualPointer, ok := manager.state.Data[key]
 		if !ok {
 			log.Error("Loading state: potentially malformed json key of " + key)
 			continue
 		}
-		err = json.Unmarshal(rawJSON, actualPointer)
-		if err != nil {
-			log.Debug("Could not unmarshal into actual")
-			return err
+		if !lenient {
+			err = json.Unmarshal(rawJSON, actualPointer)
+			if err != nil {
+				log.Debug("Could not unmarshal into actual")
+				return nil, err
+			}
+			continue
+		}
+		if unmarshaler, ok := (*actualPointer).(LenientUnmarshaler); ok {
+			skippedParts, err := unmarshaler.UnmarshalJSONLenient(rawJSON)
+			for _, part := range skippedParts {
+				skipped = append(skipped, key+": "+part)
+			}
+			if err == nil {
+				continue
+			}
 		}
+		if err := json.Unmarshal(rawJSON, actualPointer); err != nil {
+			log.Warn("Skipping saved state that does not decode", "key", key, "err", err)
+			skipped = append(skipped, key+": "+err.Error())
+		}
+	}
+	sort.Strings(skipped)
+
+	skippedEntries, err := manager.replayJournal(tmps.JournalSeq, lenient)
+	if err != nil {
+		return nil, err
 	}
+	skipped = append(skipped, skippedEntries...)
 
 	log.Debug("Loaded state!", "state", s)
-	return nil
+	return skipped, nil
 }
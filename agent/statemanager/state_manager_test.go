@@ -16,6 +16,8 @@ package statemanager_test
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/aws/amazon-ecs-agent/agent/api"
@@ -107,3 +109,215 @@ func TestStateManagerNonexistantDirectory(t *testing.T) {
 		t.Fatal("State manager should not load if the directory doesn't exist")
 	}
 }
+
+func TestStateManagerEncryption(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "ecs_statemanager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	keyFile := filepath.Join(tmpDir, "state.key")
+	ioutil.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef"), 0600)
+	cfg := &config.Config{DataDir: tmpDir, StateKeyFile: keyFile}
+
+	containerInstanceArn := "containerInstanceArn"
+	manager, err := statemanager.NewStateManager(cfg, statemanager.AddSaveable("ContainerInstanceArn", &containerInstanceArn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Save(); err != nil {
+		t.Fatal("Error saving state", err)
+	}
+
+	dataFile := filepath.Join(tmpDir, "ecs_agent_data.json")
+	fi, err := os.Stat(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Error("Expected the state file to be readable only by its owner, got", fi.Mode())
+	}
+	data, _ := ioutil.ReadFile(dataFile)
+	if strings.Contains(string(data), containerInstanceArn) {
+		t.Error("Expected the saved state to be encrypted")
+	}
+
+	var loaded string
+	manager, _ = statemanager.NewStateManager(cfg, statemanager.AddSaveable("ContainerInstanceArn", &loaded))
+	if err := manager.Load(); err != nil || loaded != containerInstanceArn {
+		t.Error("Expected encrypted state to load", loaded, err)
+	}
+
+	manager, _ = statemanager.NewStateManager(&config.Config{DataDir: tmpDir}, statemanager.AddSaveable("ContainerInstanceArn", &loaded))
+	if err := manager.Load(); err == nil {
+		t.Error("Expected loading encrypted state without a key to fail")
+	}
+
+	manager, _ = statemanager.NewStateManager(cfg, statemanager.AddSaveable("ContainerInstanceArn", &loaded), statemanager.WithKeyProvider(staticKey("fedcba9876543210fedcba9876543210")))
+	if err := manager.Load(); err == nil {
+		t.Error("Expected loading encrypted state with the wrong key to fail")
+	}
+}
+
+func TestStateManagerLoadsPlaintextWithKey(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "ecs_statemanager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	containerInstanceArn := "containerInstanceArn"
+	manager, _ := statemanager.NewStateManager(&config.Config{DataDir: tmpDir}, statemanager.AddSaveable("ContainerInstanceArn", &containerInstanceArn))
+	if err := manager.Save(); err != nil {
+		t.Fatal("Error saving state", err)
+	}
+
+	var loaded string
+	manager, _ = statemanager.NewStateManager(&config.Config{DataDir: tmpDir}, statemanager.AddSaveable("ContainerInstanceArn", &loaded), statemanager.WithKeyProvider(staticKey("0123456789abcdef0123456789abcdef")))
+	if err := manager.Load(); err != nil || loaded != containerInstanceArn {
+		t.Error("Expected plaintext state to load when a key is configured", loaded, err)
+	}
+}
+
+type staticKey string
+
+func (k staticKey) Key() ([]byte, error) {
+	return []byte(k), nil
+}
+
+func TestStateManagerJournal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "ecs_statemanager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	cfg := &config.Config{DataDir: tmpDir}
+
+	taskEngine := engine.NewTaskEngine(&config.Config{})
+	manager, err := statemanager.NewStateManager(cfg, statemanager.AddSaveable("TaskEngine", taskEngine))
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskEngine.SetSaver(manager)
+
+	// Not saved, only journaled
+	container := &api.Container{Name: "web"}
+	taskEngine.AddTask(&api.Task{Arn: "test-arn", Containers: []*api.Container{container}})
+
+	load := func() *engine.DockerTaskEngine {
+		loaded := engine.NewDockerTaskEngine(&config.Config{})
+		manager, err := statemanager.NewStateManager(cfg, statemanager.AddSaveable("TaskEngine", loaded), statemanager.WithJournalReplayer(loaded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := manager.Load(); err != nil {
+			t.Fatal("Error loading state", err)
+		}
+		return loaded
+	}
+
+	if _, ok := load().State().TaskByArn("test-arn"); !ok {
+		t.Fatal("Expected the journaled task to be replayed without a save")
+	}
+
+	if err := manager.(statemanager.ForceSaver).ForceSave(); err != nil {
+		t.Fatal(err)
+	}
+	journal, _ := ioutil.ReadFile(filepath.Join(tmpDir, "ecs_agent_journal.json"))
+	if len(journal) != 0 {
+		t.Error("Expected the journal to be compacted by a save, got", string(journal))
+	}
+
+	container.SentStatus = api.ContainerRunning
+	statemanager.Record(manager, engine.JournalStatusSent, &engine.StatusSentEntry{TaskArn: "test-arn", ContainerName: "web", ContainerSentStatus: api.ContainerRunning})
+	// A crash while appending leaves a partial entry
+	f, _ := os.OpenFile(filepath.Join(tmpDir, "ecs_agent_journal.json"), os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"Seq":3,"Type":"statusS`)
+	f.Close()
+
+	task, ok := load().State().TaskByArn("test-arn")
+	if !ok {
+		t.Fatal("Expected the saved task to load")
+	}
+	if task.Containers[0].SentStatus != api.ContainerRunning {
+		t.Error("Expected the journaled sent status to be replayed, got", task.Containers[0].SentStatus)
+	}
+	journal, _ = ioutil.ReadFile(filepath.Join(tmpDir, "ecs_agent_journal.json"))
+	if strings.Contains(string(journal), "statusS\"") || !strings.HasSuffix(string(journal), "\n") {
+		t.Error("Expected the partial entry to be dropped, got", string(journal))
+	}
+}
+
+func TestStateManagerKVBackend(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "ecs_statemanager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	keyFile := filepath.Join(tmpDir, "state.key")
+	ioutil.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef"), 0600)
+	cfg := &config.Config{DataDir: tmpDir, StateBackend: config.StateBackendKV, StateKeyFile: keyFile}
+
+	taskEngine := engine.NewTaskEngine(&config.Config{})
+	taskEngine.AddTask(&api.Task{Arn: "arn:aws:ecs:task/1", Containers: []*api.Container{{Name: "c1"}}})
+	taskEngine.AddTask(&api.Task{Arn: "arn:aws:ecs:task/2"})
+	manager, err := statemanager.NewStateManager(cfg, statemanager.AddSaveable("TaskEngine", taskEngine))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Save(); err != nil {
+		t.Fatal("Error saving state", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "ecs_agent_data.json")); !os.IsNotExist(err) {
+		t.Error("Expected no JSON state file to be written")
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(tmpDir, "ecs_agent_data.kv"))
+	if strings.Contains(string(data), "arn:aws:ecs:task/1") {
+		t.Error("Expected the saved state to be encrypted")
+	}
+
+	loadedTaskEngine := engine.NewTaskEngine(&config.Config{})
+	manager, _ = statemanager.NewStateManager(cfg, statemanager.AddSaveable("TaskEngine", &loadedTaskEngine))
+	if err := manager.Load(); err != nil {
+		t.Fatal("Error loading state", err)
+	}
+	if !engine_testutils.DockerTaskEnginesEqual(loadedTaskEngine.(*engine.DockerTaskEngine), taskEngine.(*engine.DockerTaskEngine)) {
+		t.Error("Did not load taskEngine correctly")
+	}
+}
+
+func TestStateManagerBackendMismatch(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("/tmp", "ecs_statemanager_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	containerInstanceArn := "containerInstanceArn"
+	manager, _ := statemanager.NewStateManager(&config.Config{DataDir: tmpDir, StateBackend: config.StateBackendJSON}, statemanager.AddSaveable("ContainerInstanceArn", &containerInstanceArn))
+	if err := manager.Save(); err != nil {
+		t.Fatal("Error saving state", err)
+	}
+
+	var loaded string
+	kvCfg := &config.Config{DataDir: tmpDir, StateBackend: config.StateBackendKV}
+	manager, _ = statemanager.NewStateManager(kvCfg, statemanager.AddSaveable("ContainerInstanceArn", &loaded))
+	if err := manager.Load(); err == nil {
+		t.Error("Expected loading with a backend other than the one state was saved with to fail")
+	}
+
+	if err := statemanager.ConvertBackend(tmpDir, "", config.StateBackendJSON, config.StateBackendKV); err != nil {
+		t.Fatal("Error converting state", err)
+	}
+	manager, _ = statemanager.NewStateManager(kvCfg, statemanager.AddSaveable("ContainerInstanceArn", &loaded))
+	if err := manager.Load(); err != nil || loaded != containerInstanceArn {
+		t.Error("Expected converted state to load", loaded, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "ecs_agent_data.json")); err != nil {
+		t.Error("Expected converting to leave the source in place", err)
+	}
+	if err := statemanager.ConvertBackend(tmpDir, "", config.StateBackendJSON, config.StateBackendKV); err == nil {
+		t.Error("Expected converting over existing state to fail")
+	}
+}
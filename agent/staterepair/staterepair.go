@@ -0,0 +1,238 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package staterepair inspects and repairs the agent's saved state while the
+// agent is stopped, so that a checkpoint the agent refuses to start with can
+// be fixed without deleting it and orphaning every running container.
+package staterepair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/engine"
+	"github.com/aws/amazon-ecs-agent/agent/statemanager"
+)
+
+// SavedState is the agent's saved state, loaded as the agent would load it
+type SavedState struct {
+	Cluster              string
+	ContainerInstanceArn string
+	EC2InstanceID        string
+	TaskEngine           *engine.DockerTaskEngine
+	// Skipped describes each part of the saved state that did not decode.
+	// Saving drops them.
+	Skipped []string
+
+	cfg     *config.Config
+	manager statemanager.StateManager
+}
+
+// Load loads the state saved in the data directory of cfg, including any
+// journaled changes. Unlike the agent, it loads corrupted state, skipping the
+// tasks, containers and journal entries that do not decode.
+func Load(cfg *config.Config) (*SavedState, error) {
+	saved := &SavedState{
+		TaskEngine: engine.NewDockerTaskEngine(cfg),
+		cfg:        cfg,
+	}
+	manager, err := statemanager.NewStateManager(cfg,
+		statemanager.AddSaveable("TaskEngine", saved.TaskEngine),
+		statemanager.AddSaveable("ContainerInstanceArn", &saved.ContainerInstanceArn),
+		statemanager.AddSaveable("Cluster", &saved.Cluster),
+		statemanager.AddSaveable("EC2InstanceID", &saved.EC2InstanceID),
+		statemanager.WithJournalReplayer(saved.TaskEngine),
+	)
+	if err != nil {
+		return nil, err
+	}
+	lenientLoader, ok := manager.(statemanager.LenientLoader)
+	if !ok {
+		return nil, errors.New("State manager cannot load corrupted state")
+	}
+	saved.Skipped, err = lenientLoader.LoadLenient()
+	if err != nil {
+		return nil, err
+	}
+	saved.manager = manager
+	return saved, nil
+}
+
+// tasks returns the saved tasks, sorted by Arn
+func (saved *SavedState) tasks() []*api.Task {
+	tasks := saved.TaskEngine.State().AllTasks()
+	sort.Sort(byArn(tasks))
+	return tasks
+}
+
+type byArn []*api.Task
+
+func (tasks byArn) Len() int           { return len(tasks) }
+func (tasks byArn) Less(i, j int) bool { return tasks[i].Arn < tasks[j].Arn }
+func (tasks byArn) Swap(i, j int)      { tasks[i], tasks[j] = tasks[j], tasks[i] }
+
+// dockerContainer returns the Docker container saved for a task's container,
+// if one was created
+func (saved *SavedState) dockerContainer(task *api.Task, container *api.Container) (*api.DockerContainer, bool) {
+	containers, ok := saved.TaskEngine.State().ContainerMapByArn(task.Arn)
+	if !ok {
+		return nil, false
+	}
+	dockerContainer, ok := containers[container.Name]
+	if !ok || dockerContainer.DockerId == "" {
+		return nil, false
+	}
+	return dockerContainer, true
+}
+
+// Print writes the saved instance, and its tasks and containers, to w
+func (saved *SavedState) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Cluster:\t%s\n", saved.Cluster)
+	fmt.Fprintf(tw, "Container instance:\t%s\n", saved.ContainerInstanceArn)
+	fmt.Fprintf(tw, "EC2 instance:\t%s\n", saved.EC2InstanceID)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, task := range saved.tasks() {
+		fmt.Fprintf(tw, "\nTask %s (%s:%s)\tknown %s\tdesired %s\tsent %s\n", task.Arn, task.Family, task.Version,
+			task.KnownStatus.String(), task.DesiredStatus.String(), task.SentStatus.String())
+		for _, container := range task.Containers {
+			dockerId := "-"
+			if dockerContainer, ok := saved.dockerContainer(task, container); ok {
+				dockerId = dockerContainer.DockerId
+			}
+			fmt.Fprintf(tw, "  %s\tknown %s\tdesired %s\tdocker %s\n", container.Name,
+				container.KnownStatus.String(), container.DesiredStatus.String(), dockerId)
+		}
+	}
+	return tw.Flush()
+}
+
+// ContainerDescriber describes Docker containers; engine.DockerGoClient is
+// one
+type ContainerDescriber interface {
+	DescribeContainer(context.Context, string) (api.ContainerStatus, error)
+}
+
+// Problem is a difference between saved state and Docker
+type Problem struct {
+	TaskArn   string
+	Container string
+	DockerId  string
+	Problem   string
+}
+
+func (problem Problem) String() string {
+	return fmt.Sprintf("%s %s (%s): %s", problem.TaskArn, problem.Container, problem.DockerId, problem.Problem)
+}
+
+// Validate compares each saved container with Docker, returning the
+// containers whose saved state does not match
+func (saved *SavedState) Validate(client ContainerDescriber) []Problem {
+	var problems []Problem
+	for _, task := range saved.tasks() {
+		for _, container := range task.Containers {
+			problem := Problem{TaskArn: task.Arn, Container: container.Name}
+			dockerContainer, ok := saved.dockerContainer(task, container)
+			if !ok {
+				if container.KnownStatus >= api.ContainerCreated && !container.KnownTerminal() {
+					problem.Problem = "saved as " + container.KnownStatus.String() + " but has no Docker container"
+					problems = append(problems, problem)
+				}
+				continue
+			}
+			problem.DockerId = dockerContainer.DockerId
+
+			status, err := client.DescribeContainer(context.Background(), dockerContainer.DockerId)
+			switch {
+			case err != nil:
+				if !container.KnownTerminal() {
+					problem.Problem = "could not be described by Docker: " + err.Error()
+				}
+			case status == api.ContainerRunning && container.KnownTerminal():
+				problem.Problem = "running in Docker but saved as " + container.KnownStatus.String() + "; the agent will not stop it"
+			case status != api.ContainerRunning && container.KnownStatus == api.ContainerRunning:
+				problem.Problem = "stopped in Docker but saved as RUNNING"
+			}
+			if problem.Problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+	}
+	return problems
+}
+
+// SetCluster changes the saved cluster
+func (saved *SavedState) SetCluster(cluster string) {
+	saved.Cluster = cluster
+}
+
+// SetContainerInstanceArn changes the saved container instance. If it is
+// empty the agent registers a new container instance when it starts.
+func (saved *SavedState) SetContainerInstanceArn(arn string) {
+	saved.ContainerInstanceArn = arn
+}
+
+// DropTask removes a task, and its containers, from saved state. Its Docker
+// containers are left as they are; the agent forgets them.
+func (saved *SavedState) DropTask(taskArn string) error {
+	state := saved.TaskEngine.State()
+	task, ok := state.TaskByArn(taskArn)
+	if !ok {
+		return errors.New("No task is saved with Arn " + taskArn)
+	}
+	state.RemoveTask(task)
+	state.RemoveVolumeReferences(taskArn)
+	return nil
+}
+
+// MarkContainerDead marks a task's container as dead, so that the agent
+// neither describes nor restarts it
+func (saved *SavedState) MarkContainerDead(taskArn, containerName string) error {
+	task, ok := saved.TaskEngine.State().TaskByArn(taskArn)
+	if !ok {
+		return errors.New("No task is saved with Arn " + taskArn)
+	}
+	container, ok := task.ContainerByName(containerName)
+	if !ok {
+		return errors.New("Task " + taskArn + " has no container named " + containerName)
+	}
+	container.KnownStatus = api.ContainerDead
+	if container.DesiredStatus < api.ContainerStopped {
+		container.DesiredStatus = api.ContainerStopped
+	}
+	return nil
+}
+
+// Save backs up the state on disk and then replaces it with the loaded
+// state, as changed. It returns the path of the backup.
+func (saved *SavedState) Save() (string, error) {
+	backup, err := statemanager.Backup(saved.cfg)
+	if err != nil {
+		return "", err
+	}
+	forceSaver, ok := saved.manager.(statemanager.ForceSaver)
+	if !ok {
+		return backup, errors.New("State manager cannot save immediately")
+	}
+	return backup, forceSaver.ForceSave()
+}
@@ -0,0 +1,233 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package staterepair
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-ecs-agent/agent/api"
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/engine"
+	"github.com/aws/amazon-ecs-agent/agent/statemanager"
+)
+
+// saveState saves two tasks, the first with a running container and the
+// second with a stopped one, as the agent would
+func saveState(t *testing.T, cfg *config.Config) {
+	taskEngine := engine.NewDockerTaskEngine(cfg)
+	tasks := []*api.Task{
+		{Arn: "task1", Containers: []*api.Container{{Name: "c1", KnownStatus: api.ContainerRunning, DesiredStatus: api.ContainerRunning}}},
+		{Arn: "task2", Containers: []*api.Container{{Name: "c2", KnownStatus: api.ContainerStopped, DesiredStatus: api.ContainerStopped}}},
+	}
+	for i, task := range tasks {
+		taskEngine.State().AddOrUpdateTask(task)
+		taskEngine.State().AddContainer(&api.DockerContainer{DockerId: []string{"id1", "id2"}[i], DockerName: "name", Container: task.Containers[0]}, task)
+	}
+	cluster, arn, instance := "old-cluster", "instance-arn", "i-123"
+	manager, err := statemanager.NewStateManager(cfg,
+		statemanager.AddSaveable("TaskEngine", taskEngine),
+		statemanager.AddSaveable("ContainerInstanceArn", &arn),
+		statemanager.AddSaveable("Cluster", &cluster),
+		statemanager.AddSaveable("EC2InstanceID", &instance),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.(statemanager.ForceSaver).ForceSave(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempConfig(t *testing.T) (*config.Config, func()) {
+	tmpDir, err := ioutil.TempDir("", "ecs_staterepair_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &config.Config{DataDir: tmpDir}, func() { os.RemoveAll(tmpDir) }
+}
+
+func TestPrint(t *testing.T) {
+	cfg, cleanup := tempConfig(t)
+	defer cleanup()
+	saveState(t, cfg)
+
+	saved, err := Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := saved.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"old-cluster", "instance-arn", "i-123", "task1", "c1", "id1", "task2", "STOPPED"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q to be printed, got %s", expected, out.String())
+		}
+	}
+}
+
+type mapDescriber map[string]api.ContainerStatus
+
+func (describer mapDescriber) DescribeContainer(ctx context.Context, id string) (api.ContainerStatus, error) {
+	status, ok := describer[id]
+	if !ok {
+		return api.ContainerStatusUnknown, errors.New("no such container")
+	}
+	return status, nil
+}
+
+func TestValidate(t *testing.T) {
+	cfg, cleanup := tempConfig(t)
+	defer cleanup()
+	saveState(t, cfg)
+
+	saved, err := Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := saved.Validate(mapDescriber{"id1": api.ContainerRunning, "id2": api.ContainerStopped}); len(problems) != 0 {
+		t.Error("Expected matching state to have no problems, got", problems)
+	}
+
+	problems := saved.Validate(mapDescriber{"id2": api.ContainerRunning})
+	if len(problems) != 2 {
+		t.Fatal("Expected a problem with each container, got", problems)
+	}
+	if problems[0].DockerId != "id1" || !strings.Contains(problems[0].Problem, "no such container") {
+		t.Error("Expected the missing container to be reported, got", problems[0])
+	}
+	if problems[1].DockerId != "id2" || !strings.Contains(problems[1].Problem, "running in Docker") {
+		t.Error("Expected the running stopped container to be reported, got", problems[1])
+	}
+}
+
+func TestRepair(t *testing.T) {
+	cfg, cleanup := tempConfig(t)
+	defer cleanup()
+	saveState(t, cfg)
+	original, _ := ioutil.ReadFile(filepath.Join(cfg.DataDir, "ecs_agent_data.json"))
+
+	saved, err := Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved.SetCluster("new-cluster")
+	saved.SetContainerInstanceArn("")
+	if err := saved.DropTask("task2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saved.MarkContainerDead("task1", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saved.DropTask("task3"); err == nil {
+		t.Error("Expected dropping an unknown task to fail")
+	}
+	if err := saved.MarkContainerDead("task1", "c3"); err == nil {
+		t.Error("Expected marking an unknown container dead to fail")
+	}
+	backup, err := saved.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(backup); !bytes.Equal(data, original) {
+		t.Error("Expected the backup to hold the original state")
+	}
+
+	repaired, err := Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired.Cluster != "new-cluster" || repaired.ContainerInstanceArn != "" || repaired.EC2InstanceID != "i-123" {
+		t.Error("Expected the instance to be repaired", repaired.Cluster, repaired.ContainerInstanceArn, repaired.EC2InstanceID)
+	}
+	if _, ok := repaired.TaskEngine.State().TaskByArn("task2"); ok {
+		t.Error("Expected the dropped task to be gone")
+	}
+	if _, ok := repaired.TaskEngine.State().ContainerById("id2"); ok {
+		t.Error("Expected the dropped task's container to be gone")
+	}
+	task, ok := repaired.TaskEngine.State().TaskByArn("task1")
+	if !ok {
+		t.Fatal("Expected the other task to remain")
+	}
+	if task.Containers[0].KnownStatus != api.ContainerDead || task.Containers[0].DesiredStatus != api.ContainerStopped {
+		t.Error("Expected the container to be marked dead, got", task.Containers[0].KnownStatus.String())
+	}
+}
+
+func TestLoadCorrupted(t *testing.T) {
+	cfg, cleanup := tempConfig(t)
+	defer cleanup()
+	saveState(t, cfg)
+
+	// The second task no longer decodes, and neither does the journal
+	path := filepath.Join(cfg.DataDir, "ecs_agent_data.json")
+	data, _ := ioutil.ReadFile(path)
+	var state map[string]interface{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	tasks := state["Data"].(map[string]interface{})["TaskEngine"].(map[string]interface{})["Tasks"].([]interface{})
+	for _, task := range tasks {
+		if task.(map[string]interface{})["Arn"] == "task2" {
+			task.(map[string]interface{})["Containers"] = "corrupted"
+		}
+	}
+	data, _ = json.Marshal(state)
+	ioutil.WriteFile(path, data, 0600)
+	ioutil.WriteFile(filepath.Join(cfg.DataDir, "ecs_agent_journal.json"), []byte("corrupted\n"), 0600)
+
+	saved, err := Load(cfg)
+	if err != nil {
+		t.Fatal("Expected corrupted state to load", err)
+	}
+	if len(saved.Skipped) != 3 ||
+		!strings.HasPrefix(saved.Skipped[0], "TaskEngine: docker container id2:") ||
+		!strings.HasPrefix(saved.Skipped[1], "TaskEngine: task task2:") ||
+		!strings.HasPrefix(saved.Skipped[2], "journal entry on line 1:") {
+		t.Fatal("Expected the undecodable task, its container, and the journal entry to be skipped, got", saved.Skipped)
+	}
+	if _, ok := saved.TaskEngine.State().TaskByArn("task1"); !ok || saved.Cluster != "old-cluster" {
+		t.Error("Expected the rest of the state to load")
+	}
+	if _, err := saved.Save(); err != nil {
+		t.Fatal(err)
+	}
+	repaired, err := Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repaired.Skipped) != 0 {
+		t.Error("Expected saving to drop what was skipped, got", repaired.Skipped)
+	}
+
+	// Nothing of state that is not JSON decodes
+	ioutil.WriteFile(path, []byte("corrupted"), 0600)
+	saved, err = Load(cfg)
+	if err != nil {
+		t.Fatal("Expected corrupted state to load", err)
+	}
+	if len(saved.Skipped) != 1 || !strings.HasPrefix(saved.Skipped[0], "saved state:") {
+		t.Error("Expected the saved state to be skipped, got", saved.Skipped)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2014-2015 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/amazon-ecs-agent/agent/config"
+	"github.com/aws/amazon-ecs-agent/agent/engine"
+	"github.com/aws/amazon-ecs-agent/agent/statemanager"
+	"github.com/aws/amazon-ecs-agent/agent/staterepair"
+)
+
+// subcommands are offline tools run instead of the agent, as the first
+// argument, such as `agent convert-state -from json -to kv`. Each is given
+// its remaining arguments and returns the process's exit code.
+var subcommands = map[string]func(args []string) int{
+	"convert-state": convertState,
+	"state":         stateCommand,
+}
+
+// runSubcommand runs the subcommand named by the first argument, if any,
+// returning whether it ran and its exit code
+func runSubcommand(args []string) (bool, int) {
+	if len(args) == 0 {
+		return false, 0
+	}
+	subcommand, ok := subcommands[args[0]]
+	if !ok {
+		return false, 0
+	}
+	return true, subcommand(args[1:])
+}
+
+// offlineConfig returns the agent's configuration without querying EC2
+// metadata, which offline tools have no need of
+func offlineConfig() *config.Config {
+	cfg := config.EnvironmentConfig()
+	cfg.Merge(config.FileConfig())
+	cfg.Merge(config.DefaultConfig())
+	return &cfg
+}
+
+// convertState converts saved state from one backend to another. It reads
+// the data directory and state key from the same environment the agent does.
+func convertState(args []string) int {
+	flags := flag.NewFlagSet("convert-state", flag.ContinueOnError)
+	from := flags.String("from", config.StateBackendJSON, "Backend to convert from: [json|kv]")
+	to := flags.String("to", config.StateBackendKV, "Backend to convert to: [json|kv]")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg := offlineConfig()
+	err := statemanager.ConvertBackend(cfg.DataDir, cfg.StateKeyFile, *from, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error converting state:", err)
+		return 1
+	}
+	fmt.Printf("Converted state in %s from %s to %s; set ECS_STATE_BACKEND=%s to use it\n", cfg.DataDir, *from, *to, *to)
+	return 0
+}
+
+const stateUsage = `Usage: agent state <command> [arguments]
+
+Inspects and repairs saved state. The agent must be stopped. Every repair
+backs up the saved state first. Tasks, containers and journal entries that do
+not decode are skipped and reported; a repair drops them.
+
+Commands:
+  show                          print the saved instance, tasks and containers
+  validate                      compare saved containers with Docker
+  set-cluster <cluster>         change the saved cluster
+  set-instance-arn [<arn>]      change the saved container instance; if empty,
+                                the agent registers a new one
+  drop-task <task arn>          forget a task; its containers are left running
+  mark-dead <task arn> <name>   mark a task's container as dead
+`
+
+// stateCommand inspects and repairs saved state
+func stateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, stateUsage)
+		return 2
+	}
+	command, args := args[0], args[1:]
+	arity := map[string][2]int{
+		"show":             {0, 0},
+		"validate":         {0, 0},
+		"set-cluster":      {1, 1},
+		"set-instance-arn": {0, 1},
+		"drop-task":        {1, 1},
+		"mark-dead":        {2, 2},
+	}
+	bounds, ok := arity[command]
+	if !ok || len(args) < bounds[0] || len(args) > bounds[1] {
+		fmt.Fprint(os.Stderr, stateUsage)
+		return 2
+	}
+
+	cfg := offlineConfig()
+	saved, err := staterepair.Load(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading state:", err)
+		return 1
+	}
+	for _, skipped := range saved.Skipped {
+		fmt.Fprintln(os.Stderr, "Skipped state that does not decode:", skipped)
+	}
+
+	switch command {
+	case "show":
+		if err := saved.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "Error printing state:", err)
+			return 1
+		}
+		return 0
+	case "validate":
+		client, err := engine.NewDockerGoClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error connecting to Docker:", err)
+			return 1
+		}
+		problems := saved.Validate(client)
+		for _, problem := range problems {
+			fmt.Println(problem.String())
+		}
+		if len(problems) != 0 {
+			return 1
+		}
+		fmt.Println("Saved state matches Docker")
+		return 0
+	case "set-cluster":
+		saved.SetCluster(args[0])
+	case "set-instance-arn":
+		arn := ""
+		if len(args) == 1 {
+			arn = args[0]
+		}
+		saved.SetContainerInstanceArn(arn)
+	case "drop-task":
+		err = saved.DropTask(args[0])
+	case "mark-dead":
+		err = saved.MarkContainerDead(args[0], args[1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error repairing state:", err)
+		return 1
+	}
+
+	backup, err := saved.Save()
+	if backup != "" {
+		fmt.Println("Backed up state to", backup)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error saving state:", err)
+		return 1
+	}
+	fmt.Println("Saved repaired state")
+	return 0
+}
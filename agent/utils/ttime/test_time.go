@@ -1,43 +1,62 @@
 package ttime
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 // TestTime implements a time that is able to be moved forward easily
 type TestTime struct {
 	// IsLudicrousSpeed indicates whether sleeps will all succeed instantly
 	IsLudicrousSpeed bool
 
+	lock   sync.RWMutex // guards the fields below
 	warped time.Duration
 	slept  time.Duration
 
-	// Whenever we warp around or toggle behavior, send a value down this
-	// channel so all current calls can check if it affects them
-	timeChange chan bool
+	// Whenever we warp around or toggle behavior, this channel is closed and
+	// replaced so all current calls can check if it affects them
+	timeChange chan struct{}
 }
 
 // NewTestTime returns a default TestTime. It will not be LudicrousSpeed and
 // will behave like a normal time
 func NewTestTime() *TestTime {
 	return &TestTime{
-		timeChange: make(chan bool),
+		timeChange: make(chan struct{}),
 	}
 }
 
 // Warp moves the mock time forwards by the given duration.
 func (t *TestTime) Warp(d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	t.warped += d
-	go func() { t.timeChange <- true }()
+	t.notifyTimeChange()
 }
 
 // LudicrousSpeed can be called to toggle LudicrousSpeed (default off). When
 // LudicrousSpeed is engaged, all calls to "sleep" will succeed instantly.
 func (t *TestTime) LudicrousSpeed(b bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	t.IsLudicrousSpeed = b
-	go func() { t.timeChange <- true }()
+	t.notifyTimeChange()
+}
+
+// notifyTimeChange wakes every current sleeper. The caller must hold the
+// write lock.
+func (t *TestTime) notifyTimeChange() {
+	if t.timeChange != nil {
+		close(t.timeChange)
+	}
+	t.timeChange = make(chan struct{})
 }
 
 // Now returns the current time, including any time-warping that has occured.
 func (t *TestTime) Now() time.Time {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 	return time.Now().Add(t.warped)
 }
 
@@ -47,29 +66,50 @@ func (t *TestTime) Now() time.Time {
 func (t *TestTime) Sleep(d time.Duration) {
 	// Calculate the 'real' end time so previously applied Warps work as
 	// expected. Add in the 'slept' time to ensure sleeps accumulate time
+	t.lock.RLock()
 	endTime := time.Now().Add(t.slept).Add(d)
-	done := make(chan bool)
+	t.lock.RUnlock()
 
 	defer func() {
+		t.lock.Lock()
 		t.slept += d
+		t.lock.Unlock()
 	}()
 
 	for {
 		remainingTime := endTime.Sub(t.Now())
-		if t.IsLudicrousSpeed {
+
+		t.lock.Lock()
+		ludicrous := t.IsLudicrousSpeed
+		if t.timeChange == nil {
+			// TestTime may have been created without NewTestTime
+			t.timeChange = make(chan struct{})
+		}
+		timeChange := t.timeChange
+		t.lock.Unlock()
+
+		if ludicrous {
 			t.Warp(remainingTime)
 			return
 		}
 
-		go func() {
-			time.Sleep(remainingTime)
-			done <- true
-		}()
-
+		timer := time.NewTimer(remainingTime)
 		select {
-		case <-done:
+		case <-timer.C:
 			return
-		case <-t.timeChange:
+		case <-timeChange:
+			timer.Stop()
 		}
 	}
 }
+
+// After returns a channel which is written to after the given duration, taking
+// into account time-warping
+func (t *TestTime) After(d time.Duration) <-chan time.Time {
+	done := make(chan time.Time, 1)
+	go func() {
+		t.Sleep(d)
+		done <- t.Now()
+	}()
+	return done
+}
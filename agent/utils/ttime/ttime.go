@@ -7,6 +7,7 @@ import "time"
 type Time interface {
 	Now() time.Time
 	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
 }
 
 // DefaultTime is a Time that behaves normally
@@ -24,6 +25,12 @@ func (*DefaultTime) Sleep(d time.Duration) {
 	time.Sleep(d)
 }
 
+// After waits for the duration to elapse and then sends the current time on
+// the returned channel
+func (*DefaultTime) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
 // SetTime configures what 'Time' implementation to use for each of the
 // package-level methods.
 func SetTime(t Time) {
@@ -44,3 +51,8 @@ func Sleep(d time.Duration) {
 func Since(t time.Time) time.Duration {
 	return _time.Now().Sub(t)
 }
+
+// After calls the implementation's After method
+func After(d time.Duration) <-chan time.Time {
+	return _time.After(d)
+}
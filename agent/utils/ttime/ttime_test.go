@@ -53,3 +53,16 @@ func TestSleepWarp(t *testing.T) {
 		t.Error("Time should have been warped")
 	}
 }
+
+func TestAfterWarp(t *testing.T) {
+	testTime := NewTestTime()
+	SetTime(testTime)
+
+	realnow := time.Now()
+	after := After(1 * time.Minute)
+	testTime.Warp(2 * time.Minute)
+	<-after
+	if time.Since(realnow) > 1*time.Second {
+		t.Error("After should have returned once time was warped past it")
+	}
+}
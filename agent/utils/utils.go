@@ -14,6 +14,7 @@
 package utils
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
@@ -132,6 +133,28 @@ func RetryNWithBackoff(backoff Backoff, n int, fn func() error) error {
 	return err
 }
 
+// RetryNWithBackoffCtx is RetryNWithBackoff, but stops once ctx is done,
+// whether before an attempt or while backing off. It then returns ctx.Err().
+func RetryNWithBackoffCtx(ctx context.Context, backoff Backoff, n int, fn func() error) error {
+	var err error
+	for ; n > 0; n-- {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = fn()
+		retriable, isRetriable := err.(Retriable)
+		if err == nil || isRetriable && !retriable.Retry() || n == 1 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ttime.After(backoff.Duration()):
+		}
+	}
+	return err
+}
+
 // Uint16SliceToStringSlice converts a slice of type uint16 to a slice of type
 // *string. It uses strconv.Itoa on each element
 func Uint16SliceToStringSlice(slice []uint16) []*string {
@@ -14,6 +14,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -173,3 +174,34 @@ func TestRetryNWithBackoff(t *testing.T) {
 		t.Error("Retry didn't backoff for as long as expected: %v", testTime.Seconds())
 	}
 }
+
+func TestRetryNWithBackoffCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := RetryNWithBackoffCtx(ctx, NewSimpleBackoff(time.Hour, time.Hour, 0, 1), 3, func() error {
+		calls++
+		// Cancelled while the attempt runs, so the backoff must end early
+		cancel()
+		return errors.New("err")
+	})
+	if calls != 1 || err != context.Canceled {
+		t.Errorf("Expected one try and a cancellation, got %v tries and %v", calls, err)
+	}
+
+	err = RetryNWithBackoffCtx(ctx, NewSimpleBackoff(time.Hour, time.Hour, 0, 1), 3, func() error {
+		calls++
+		return nil
+	})
+	if calls != 1 || err != context.Canceled {
+		t.Errorf("Expected no tries on a done context, got %v tries and %v", calls-1, err)
+	}
+
+	calls = 0
+	err = RetryNWithBackoffCtx(context.Background(), NewSimpleBackoff(time.Millisecond, time.Millisecond, 0, 1), 3, func() error {
+		calls++
+		return errors.New("err")
+	})
+	if calls != 3 || err == nil {
+		t.Errorf("Expected three tries and the last error, got %v tries and %v", calls, err)
+	}
+}